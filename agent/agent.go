@@ -20,6 +20,15 @@ type Agent struct {
 	publishch  chan []string
 	procsCache map[string]*proc.ProcessStatus
 	cacheMutex sync.RWMutex
+	// the last upgrade of each service, driven by master
+	upgrades     map[string]*Upgrade
+	upgradeMutex sync.Mutex
+	// the last scale of each service, driven by master
+	scales     map[string]*Scale
+	scaleMutex sync.Mutex
+	// the last decommission of each process, driven by master
+	decommissions     map[string]*Decommission
+	decommissionMutex sync.Mutex
 }
 
 func (a *Agent) Subscribe(procIDs []string) {
@@ -46,43 +55,60 @@ func (a *Agent) GetProcsFomeCache() map[string]*proc.ProcessStatus {
 
 func NewAgent(reg registry.Registry, pm proc.ProcMgr, m machine.Machine) *Agent {
 	return &Agent{
-		Reg:        reg,
-		ProcMgr:    pm,
-		Mach:       m,
-		publishch:  make(chan []string, 10),
-		procsCache: make(map[string]*proc.ProcessStatus),
+		Reg:           reg,
+		ProcMgr:       pm,
+		Mach:          m,
+		publishch:     make(chan []string, 10),
+		procsCache:    make(map[string]*proc.ProcessStatus),
+		upgrades:      make(map[string]*Upgrade),
+		scales:        make(map[string]*Scale),
+		decommissions: make(map[string]*Decommission),
 	}
 }
 
-func (a *Agent) StartNewProcess(machID, svcName string, runinfo *proc.ProcessRunInfo) error {
+// StartNewProcess creates a process of service on the machine, the settings not given by runinfo
+// take the defaults of service, returns the procID allocated
+func (a *Agent) StartNewProcess(machID, svcName string, runinfo *proc.ProcessRunInfo) (string, error) {
 	var hostIP string
 	var hostName string
 	var hostRegion string
 	var hostIDC string
+	var version string
 	var executor []string
 	var command string
 	var args []string
 	var envs map[string]string
+	var logRotation proc.LogRotation
+	var stop proc.StopSpec
+	var restart proc.RestartSpec
 	var endpoints = map[string]utils.Endpoint{}
 
 	// retrieve machine infomation from etcd
-	if mach, err := a.Reg.Machine(machID); err == nil {
-		hostIP = mach.MachInfo.PublicIP
-		hostName = mach.MachInfo.HostName
-		hostRegion = mach.MachInfo.HostRegion
-		hostIDC = mach.MachInfo.HostIDC
-		// check if the target machine is offline
-		if !mach.IsAlive {
-			e := fmt.Sprintf("Should not start new processes on a offline host, machID: %s, svcName: %s", machID, svcName)
-			log.Error(e)
-			return errors.New(e)
-		}
-	} else {
-		return err
+	mach, err := a.Reg.Machine(machID)
+	if err != nil {
+		return "", err
+	}
+	hostIP = mach.MachInfo.PublicIP
+	hostName = mach.MachInfo.HostName
+	hostRegion = mach.MachInfo.HostRegion
+	hostIDC = mach.MachInfo.HostIDC
+	// check if the target machine is offline
+	if !mach.IsAlive {
+		e := fmt.Sprintf("Should not start new processes on a offline host, machID: %s, svcName: %s", machID, svcName)
+		log.Error(e)
+		return "", errors.New(e)
 	}
 
-	if svc, ok := service.Registered[svcName]; ok {
+	if svc, ok := service.Registered()[svcName]; ok {
 		ss := svc.Status()
+		if err := a.checkPlacement(svcName, ss.Placement, mach); err != nil {
+			return "", err
+		}
+		if len(runinfo.Version) > 0 {
+			version = runinfo.Version
+		} else {
+			version = ss.Version
+		}
 		if len(runinfo.Executor) > 0 {
 			executor = runinfo.Executor
 		} else {
@@ -103,6 +129,26 @@ func (a *Agent) StartNewProcess(machID, svcName string, runinfo *proc.ProcessRun
 		} else {
 			envs = ss.Environments
 		}
+		if !runinfo.LogRotation.IsZero() {
+			logRotation = runinfo.LogRotation
+		} else {
+			logRotation = ss.LogRotation
+		}
+		if !runinfo.Stop.IsZero() {
+			stop = runinfo.Stop
+		} else {
+			stop = ss.Stop
+		}
+		if len(runinfo.Restart.Policy) > 0 {
+			restart.Policy = runinfo.Restart.Policy
+		} else {
+			restart.Policy = ss.Restart.Policy
+		}
+		if runinfo.Restart.MaxRetries > 0 {
+			restart.MaxRetries = runinfo.Restart.MaxRetries
+		} else {
+			restart.MaxRetries = ss.Restart.MaxRetries
+		}
 		parsedEndpoints := svc.ParseEndpointFromArgs(args)
 		for k, v := range parsedEndpoints {
 			if len(v.IPAddr) == 0 {
@@ -110,19 +156,136 @@ func (a *Agent) StartNewProcess(machID, svcName string, runinfo *proc.ProcessRun
 			}
 			endpoints[k] = v
 		}
+		if svcName == service.PD_SERVICE {
+			pds, err := a.Reg.ProcessesOfService(svcName)
+			if err != nil {
+				log.Errorf("List processes of service failed, %s, %v", svcName, err)
+				return "", err
+			}
+			args = service.PDClusterArgs(args, endpoints, pds)
+		}
 	} else {
 		e := fmt.Sprintf("Unregistered service: %s", svcName)
 		log.Error(e)
-		return errors.New(e)
+		return "", errors.New(e)
 	}
 
-	if err := a.Reg.NewProcess(machID, svcName, hostIP, hostName, hostRegion, hostIDC,
-		executor, command, args, envs, endpoints); err != nil {
+	procID, err := a.Reg.NewProcess(machID, svcName, &proc.ProcessRunInfo{
+		HostIP:      hostIP,
+		HostName:    hostName,
+		HostRegion:  hostRegion,
+		HostIDC:     hostIDC,
+		Version:     version,
+		Generation:  1,
+		Executor:    executor,
+		Command:     command,
+		Args:        args,
+		Environment: envs,
+		Endpoints:   endpoints,
+		Restart:     restart,
+		LogRotation: logRotation,
+		Limits:      runinfo.Limits,
+		Stop:        stop,
+	})
+	if err != nil {
 		e := fmt.Sprintf("Create new process failed in etcd, %s, %s, %v", machID, svcName, err)
 		log.Error(e)
-		return errors.New(e)
+		return "", errors.New(e)
 	}
-	return nil
+	return procID, nil
+}
+
+// RestartUpdate is the restart spec to update, the nil fields are not given, so that the zero values can be set
+type RestartUpdate struct {
+	Policy     *proc.RestartPolicy
+	MaxRetries *int
+}
+
+// UpdateProcess changes the run spec of process in place, keeping its procID and machine,
+// the empty fields of runinfo are taken from the current spec if merge, otherwise from the definition of service,
+// but the flags of PD process to join its cluster are always kept. The restart spec is given by restart instead of runinfo.
+// The update fails if generation is not the current generation of process, unless generation is 0
+func (a *Agent) UpdateProcess(procID string, generation int64, runinfo *proc.ProcessRunInfo, restart RestartUpdate, merge bool) (*proc.ProcessStatus, error) {
+	status, err := a.Reg.Process(procID)
+	if err != nil {
+		log.Errorf("Failed to find process, %s, %v", procID, err)
+		return nil, err
+	}
+	svc, ok := service.Registered()[status.SvcName]
+	if !ok {
+		e := fmt.Sprintf("Unregistered service: %s", status.SvcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	if generation == 0 {
+		generation = status.RunInfo.Generation
+	}
+	base := status.RunInfo
+	if !merge {
+		ss := svc.Status()
+		base.Version = ss.Version
+		base.Executor = ss.Executor
+		base.Command = ss.Command
+		base.Args = ss.Args
+		base.Environment = ss.Environments
+		base.Restart = ss.Restart
+		base.LogRotation = ss.LogRotation
+		base.Limits = proc.ResourceLimits{}
+		base.Stop = ss.Stop
+	}
+	updated := overrideRunInfo(base, runinfo, restart)
+	if status.SvcName == service.PD_SERVICE {
+		// the member keeps its place in the PD cluster, which is not given by the definition of service
+		updated.Args = service.KeepPDClusterArgs(updated.Args, status.RunInfo.Args)
+	}
+	updated.Endpoints = make(map[string]utils.Endpoint)
+	for k, v := range svc.ParseEndpointFromArgs(updated.Args) {
+		if len(v.IPAddr) == 0 {
+			v.IPAddr = updated.HostIP
+		}
+		updated.Endpoints[k] = v
+	}
+	if err := a.Reg.UpdateProcessRunInfo(procID, generation, &updated); err != nil {
+		log.Errorf("Update run info of process failed in etcd, %s, %v", procID, err)
+		return nil, err
+	}
+	return a.Reg.Process(procID)
+}
+
+// overrideRunInfo returns the run info with the non-empty spec of runinfo and the given restart spec
+// taking place of the ones of base
+func overrideRunInfo(base proc.ProcessRunInfo, runinfo *proc.ProcessRunInfo, restart RestartUpdate) proc.ProcessRunInfo {
+	if len(runinfo.Version) > 0 {
+		base.Version = runinfo.Version
+	}
+	if len(runinfo.Executor) > 0 {
+		base.Executor = runinfo.Executor
+	}
+	if len(runinfo.Command) > 0 {
+		base.Command = runinfo.Command
+	}
+	if len(runinfo.Args) > 0 {
+		base.Args = runinfo.Args
+	}
+	if len(runinfo.Environment) > 0 {
+		base.Environment = runinfo.Environment
+	}
+	if restart.Policy != nil {
+		base.Restart.Policy = *restart.Policy
+	}
+	if restart.MaxRetries != nil {
+		base.Restart.MaxRetries = *restart.MaxRetries
+	}
+	if !runinfo.LogRotation.IsZero() {
+		base.LogRotation = runinfo.LogRotation
+	}
+	if !runinfo.Limits.IsZero() {
+		base.Limits = runinfo.Limits
+	}
+	if !runinfo.Stop.IsZero() {
+		base.Stop = runinfo.Stop
+	}
+	return base
 }
 
 func (a *Agent) DestroyProcess(procID string) error {
@@ -181,6 +344,39 @@ func (a *Agent) ListProcess(procID string) (res *proc.ProcessStatus, err error)
 	return
 }
 
+func (a *Agent) ListProcessRuns(procID string) (res []proc.ProcessRunRecord, err error) {
+	res, err = a.Reg.ProcessRuns(procID)
+	if err != nil {
+		log.Errorf("List runs of specified process failed, %s, %v", procID, err)
+	}
+	return
+}
+
+// ProcessLogURL returns the URL of the logs of process served by the minion on which it's running
+func (a *Agent) ProcessLogURL(procID string) (string, error) {
+	status, err := a.Reg.Process(procID)
+	if err != nil {
+		log.Errorf("List specified process failed, %s, %v", procID, err)
+		return "", err
+	}
+	mach, err := a.Reg.Machine(status.MachID)
+	if err != nil {
+		log.Errorf("List specified machines infomation failed, %s, %v", status.MachID, err)
+		return "", err
+	}
+	if !mach.IsAlive {
+		e := fmt.Sprintf("The machine of process is not alive, procID: %s, machID: %s", procID, status.MachID)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	if mach.MachInfo.APIPort == 0 {
+		e := fmt.Sprintf("The minion on machine serves no API, machID: %s", status.MachID)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	return fmt.Sprintf("http://%s:%d/logs/%s", mach.MachInfo.PublicIP, mach.MachInfo.APIPort, procID), nil
+}
+
 func (a *Agent) ListAllMachines() (res map[string]*machine.MachineStatus, err error) {
 	res, err = a.Reg.Machines()
 	if err != nil {
@@ -200,7 +396,7 @@ func (a *Agent) ListMachine(machID string) (res *machine.MachineStatus, err erro
 func (a *Agent) BirthCry() error {
 	status := a.Mach.Status()
 	if err := a.Reg.RegisterMachine(status.MachID, status.MachInfo.HostName, status.MachInfo.HostRegion,
-		status.MachInfo.HostIDC, status.MachInfo.PublicIP); err != nil {
+		status.MachInfo.HostIDC, status.MachInfo.PublicIP, status.MachInfo.APIPort); err != nil {
 		log.Errorf("Register machine status into etcd failed, %v", err)
 		return err
 	}
@@ -214,11 +410,9 @@ func (a *Agent) ShowTiDBRealPerfermance() *service.TiDBPerfMetrics {
 		// no process exists in Ti-Cluster, or the agent just started a moment ago
 		return &service.TiDBPerfMetrics{}
 	}
-	tidbService := service.Registered[service.TiDB_SERVICE].(*service.TiDBService)
-	return tidbService.RetrieveRealPerformance(cachedProcs)
+	return service.RetrieveTiDBPerformance(cachedProcs)
 }
 
 func (a *Agent) ShowLocalTiDBRealPerfermance() *service.TiDBPerfMetrics {
-	tidbService := service.Registered[service.TiDB_SERVICE].(*service.TiDBService)
-	return tidbService.RetrieveLocalRealPerformance()
+	return service.RetrieveLocalTiDBPerformance()
 }
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+func TestOverrideRunInfo(t *testing.T) {
+	never, always := proc.RestartNever, proc.RestartAlways
+	zero, three := 0, 3
+	base := proc.ProcessRunInfo{
+		Version:     "1.0.0",
+		Command:     "bin/pd-server",
+		Args:        []string{"--name", "pd-1"},
+		Environment: map[string]string{"K": "V"},
+		Restart: proc.RestartSpec{
+			Policy:     proc.RestartOnFailure,
+			MaxRetries: 5,
+		},
+	}
+	tests := []struct {
+		name    string
+		runinfo proc.ProcessRunInfo
+		restart RestartUpdate
+		want    func(*proc.ProcessRunInfo)
+	}{
+		{
+			"nothing given",
+			proc.ProcessRunInfo{}, RestartUpdate{},
+			func(ri *proc.ProcessRunInfo) {},
+		},
+		{
+			"spec given",
+			proc.ProcessRunInfo{Version: "1.0.1", Command: "bin/pd", Args: []string{"-L", "info"}},
+			RestartUpdate{},
+			func(ri *proc.ProcessRunInfo) {
+				ri.Version, ri.Command, ri.Args = "1.0.1", "bin/pd", []string{"-L", "info"}
+			},
+		},
+		{
+			"restart policy given",
+			proc.ProcessRunInfo{}, RestartUpdate{Policy: &always, MaxRetries: &three},
+			func(ri *proc.ProcessRunInfo) {
+				ri.Restart = proc.RestartSpec{Policy: proc.RestartAlways, MaxRetries: 3}
+			},
+		},
+		{
+			"restart policy cleared",
+			proc.ProcessRunInfo{}, RestartUpdate{Policy: &never},
+			func(ri *proc.ProcessRunInfo) {
+				ri.Restart.Policy = proc.RestartNever
+			},
+		},
+		{
+			"max retries set to no limit",
+			proc.ProcessRunInfo{}, RestartUpdate{MaxRetries: &zero},
+			func(ri *proc.ProcessRunInfo) {
+				ri.Restart.MaxRetries = 0
+			},
+		},
+		{
+			"restart spec of runinfo ignored",
+			proc.ProcessRunInfo{Restart: proc.RestartSpec{Policy: proc.RestartAlways, MaxRetries: 1}}, RestartUpdate{},
+			func(ri *proc.ProcessRunInfo) {},
+		},
+	}
+	for _, tt := range tests {
+		want := base
+		want.Args = append([]string{}, base.Args...)
+		tt.want(&want)
+		runinfo := tt.runinfo
+		got := overrideRunInfo(base, &runinfo, tt.restart)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: overrideRunInfo = %+v, want %+v", tt.name, got, want)
+		}
+	}
+	if base.Restart.Policy != proc.RestartOnFailure || base.Restart.MaxRetries != 5 {
+		t.Errorf("overrideRunInfo modifies the base restart spec to %+v", base.Restart)
+	}
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+// StartCluster starts all processes in the order of service dependencies, the minions hold each process
+// until the services it depends on are ready, returns the procIDs started
+func (a *Agent) StartCluster() ([]string, error) {
+	order, err := service.StartOrder()
+	if err != nil {
+		log.Errorf("Failed to sort services by dependencies, %v", err)
+		return nil, err
+	}
+	started := []string{}
+	for _, svcName := range order {
+		procs, err := a.ListProcessesBySvcName(svcName)
+		if err != nil {
+			return started, err
+		}
+		for procID, p := range procs {
+			if p.DesiredState == proc.StateStarted {
+				continue
+			}
+			if err := a.StartProcess(procID); err != nil {
+				return started, err
+			}
+			started = append(started, procID)
+		}
+	}
+	return started, nil
+}
+
+// StopCluster stops all processes in the reverse order of service dependencies, the processes of a service
+// are not stopped until all processes of the services depending on it have been stopped, returns the procIDs stopped
+func (a *Agent) StopCluster() ([]string, error) {
+	order, err := service.StartOrder()
+	if err != nil {
+		log.Errorf("Failed to sort services by dependencies, %v", err)
+		return nil, err
+	}
+	stopped := []string{}
+	for i := len(order) - 1; i >= 0; i-- {
+		procs, err := a.ListProcessesBySvcName(order[i])
+		if err != nil {
+			return stopped, err
+		}
+		for procID, p := range procs {
+			if p.DesiredState == proc.StateStopped {
+				continue
+			}
+			if err := a.StopProcess(procID); err != nil {
+				return stopped, err
+			}
+			stopped = append(stopped, procID)
+		}
+		for procID := range procs {
+			if err := a.WaitProcessStopped(procID, processStopTimeout); err != nil {
+				return stopped, err
+			}
+		}
+		log.Infof("All processes of service %s stopped", order[i])
+	}
+	return stopped, nil
+}
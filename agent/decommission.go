@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+const (
+	// how long to wait for the process to leave the cluster of its service by default,
+	// which covers migrating all regions off a TiKV store
+	defaultDecommissionTimeout = 12 * time.Hour
+	// how often to check whether the offline store has become tombstone
+	storePollInterval = 5 * time.Second
+)
+
+type DecommissionState string
+
+const (
+	// removing the process from the cluster of its service, e.g. migrating the regions off the TiKV store
+	DecommissionLeaving = DecommissionState("leaving")
+	// stopping the process and destroying it
+	DecommissionStopping  = DecommissionState("stopping")
+	DecommissionCompleted = DecommissionState("completed")
+	// retry by decommissioning the process again
+	DecommissionFailed = DecommissionState("failed")
+)
+
+// Decommission removes the process from the cluster of its service safely before stopping and destroying it,
+// for TiKV, the store is taken offline in PD, and the process is stopped only after the store becomes tombstone,
+// for PD, the process is deleted from the members of PD cluster
+type Decommission struct {
+	ProcID  string
+	SvcName string
+	MachID  string
+	// how long to wait for the process to leave the cluster
+	Timeout time.Duration
+	State   DecommissionState
+	// the store of TiKV process in PD, 0 if not registered in PD
+	StoreID     uint64
+	StoreState  string
+	RegionCount int
+	Error       string
+	Started     time.Time
+	// zero until completed or failed
+	Finished time.Time
+}
+
+func (d *Decommission) copy() *Decommission {
+	res := *d
+	return &res
+}
+
+// StartDecommission starts to decommission the process in background, the processes of a service
+// are decommissioned one at a time, 0 timeout means 12 hours
+func (a *Agent) StartDecommission(procID string, timeout time.Duration) (*Decommission, error) {
+	d, err := a.newDecommission(procID, timeout)
+	if err != nil {
+		return nil, err
+	}
+	res := d.copy()
+	go a.runDecommission(d)
+	return res, nil
+}
+
+// Decommission returns the last decommission of the process, which is kept until another process
+// of its service is decommissioned
+func (a *Agent) Decommission(procID string) (*Decommission, error) {
+	a.decommissionMutex.Lock()
+	defer a.decommissionMutex.Unlock()
+	d, ok := a.decommissions[procID]
+	if !ok {
+		e := fmt.Sprintf("No decommission of process: %s", procID)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	return d.copy(), nil
+}
+
+// DrainProcess decommissions the process, and waits until it's destroyed
+func (a *Agent) DrainProcess(procID string) error {
+	d, err := a.newDecommission(procID, 0)
+	if err != nil {
+		return err
+	}
+	return a.runDecommission(d)
+}
+
+func (a *Agent) newDecommission(procID string, timeout time.Duration) (*Decommission, error) {
+	status, err := a.Reg.Process(procID)
+	if err != nil {
+		log.Errorf("List specified process failed, %s, %v", procID, err)
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultDecommissionTimeout
+	}
+	d := &Decommission{
+		ProcID:  procID,
+		SvcName: status.SvcName,
+		MachID:  status.MachID,
+		Timeout: timeout,
+		State:   DecommissionLeaving,
+		Started: time.Now(),
+	}
+
+	a.decommissionMutex.Lock()
+	defer a.decommissionMutex.Unlock()
+	for _, last := range a.decommissions {
+		if last.SvcName == status.SvcName && last.State != DecommissionCompleted && last.State != DecommissionFailed {
+			e := fmt.Sprintf("Process %s of service %s is being decommissioned, decommission one at a time", last.ProcID, last.SvcName)
+			log.Error(e)
+			return nil, errors.New(e)
+		}
+	}
+	// only the last decommission of each service is kept, the finished ones are replaced
+	for lastProcID, last := range a.decommissions {
+		if last.SvcName == status.SvcName {
+			delete(a.decommissions, lastProcID)
+		}
+	}
+	a.decommissions[procID] = d
+	return d, nil
+}
+
+// updateDecommission modifies the decommission with the lock held, which is read by API concurrently
+func (a *Agent) updateDecommission(f func()) {
+	a.decommissionMutex.Lock()
+	defer a.decommissionMutex.Unlock()
+	f()
+}
+
+func (a *Agent) runDecommission(d *Decommission) error {
+	log.Infof("Start to decommission process of service %s, procID: %s", d.SvcName, d.ProcID)
+	err := a.leaveCluster(d)
+	if err == nil {
+		a.updateDecommission(func() {
+			d.State = DecommissionStopping
+		})
+		if err = a.StopProcess(d.ProcID); err == nil {
+			if err = a.WaitProcessStopped(d.ProcID, processStopTimeout); err == nil {
+				err = a.DestroyProcess(d.ProcID)
+			}
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to decommission process of service %s, procID: %s, %v", d.SvcName, d.ProcID, err)
+		a.updateDecommission(func() {
+			d.State = DecommissionFailed
+			d.Error = err.Error()
+			d.Finished = time.Now()
+		})
+		return err
+	}
+	a.updateDecommission(func() {
+		d.State = DecommissionCompleted
+		d.Finished = time.Now()
+	})
+	log.Infof("Decommission of process completed, procID: %s", d.ProcID)
+	return nil
+}
+
+// NeedsDecommission tells whether the processes of service should leave the cluster before destroyed
+func NeedsDecommission(svcName string) bool {
+	return svcName == service.TiKV_SERVICE || svcName == service.PD_SERVICE
+}
+
+// leaveCluster removes the process from the cluster of its service, before it's stopped
+func (a *Agent) leaveCluster(d *Decommission) error {
+	switch d.SvcName {
+	case service.TiKV_SERVICE:
+		return a.offlineTiKVStore(d)
+	case service.PD_SERVICE:
+		return a.removePDMember(d)
+	default:
+		return nil
+	}
+}
+
+// offlineTiKVStore asks PD to take the store of TiKV process offline, and waits until the store becomes tombstone,
+// it's refused if the stores left up would be fewer than the replicas of region
+func (a *Agent) offlineTiKVStore(d *Decommission) error {
+	procs, err := a.Reg.Processes()
+	if err != nil {
+		log.Errorf("List all processes failed, %v", err)
+		return err
+	}
+	status, ok := procs[d.ProcID]
+	if !ok {
+		return errors.New(fmt.Sprintf("No process found by procID[%s]", d.ProcID))
+	}
+	pd, err := service.NewPDClient(procs)
+	if err != nil {
+		return err
+	}
+	stores, err := pd.Stores()
+	if err != nil {
+		return err
+	}
+	store := storeOfProcess(stores, status)
+	if store == nil {
+		log.Warnf("Store of TiKV process not found in PD, nothing to migrate, procID: %s", d.ProcID)
+		return nil
+	}
+	a.updateDecommission(func() {
+		d.StoreID = store.ID
+		d.StoreState = store.State
+		d.RegionCount = store.RegionCount
+	})
+
+	if store.State == service.StoreUp {
+		up := 0
+		for _, s := range stores {
+			if s.ID != store.ID && s.State == service.StoreUp {
+				up++
+			}
+		}
+		if replicas, err := pd.MaxReplicas(); err != nil {
+			log.Warnf("Failed to get max replicas from PD, the stores left up are not checked, %v", err)
+		} else if up < replicas {
+			return errors.New(fmt.Sprintf("Only %d stores would be left up, fewer than %d replicas of region", up, replicas))
+		}
+		if err := pd.DeleteStore(store.ID); err != nil {
+			return err
+		}
+		log.Infof("Store %d of TiKV process is taken offline, procID: %s, regions: %d", store.ID, d.ProcID, store.RegionCount)
+	}
+
+	deadline := time.Now().Add(d.Timeout)
+	for {
+		s, err := pd.Store(store.ID)
+		if err != nil {
+			log.Warnf("Failed to get store %d from PD, %v", store.ID, err)
+		} else {
+			a.updateDecommission(func() {
+				d.StoreState = s.State
+				d.RegionCount = s.RegionCount
+			})
+			if s.State == service.StoreTombstone {
+				return nil
+			}
+			if s.State == service.StoreUp {
+				return errors.New(fmt.Sprintf("Store %d is up again, the offline is canceled", store.ID))
+			}
+		}
+		if time.Now().After(deadline) {
+			e := fmt.Sprintf("Timed out waiting for store %d tombstone after %v, %d regions left", store.ID, d.Timeout, d.RegionCount)
+			log.Error(e)
+			return errors.New(e)
+		}
+		time.Sleep(storePollInterval)
+	}
+}
+
+// removePDMember deletes the PD process from the members of PD cluster by the other PD processes,
+// the last member is never removed
+func (a *Agent) removePDMember(d *Decommission) error {
+	procs, err := a.Reg.ProcessesOfService(service.PD_SERVICE)
+	if err != nil {
+		log.Errorf("List processes of service failed, %s, %v", service.PD_SERVICE, err)
+		return err
+	}
+	status, ok := procs[d.ProcID]
+	if !ok {
+		return errors.New(fmt.Sprintf("No process found by procID[%s]", d.ProcID))
+	}
+	name := service.PDMemberName(status)
+	if len(name) == 0 {
+		log.Warnf("PD process is not named by args, can't be removed from PD cluster, procID: %s", d.ProcID)
+		return nil
+	}
+	delete(procs, d.ProcID)
+	pd, err := service.NewPDClient(procs)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't remove the member %s of PD cluster, %v", name, err))
+	}
+	members, err := pd.Members()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, m := range members {
+		if m.Name == name {
+			found = true
+		}
+	}
+	if !found {
+		log.Warnf("PD process is not a member of PD cluster, nothing to remove, procID: %s, name: %s", d.ProcID, name)
+		return nil
+	}
+	if len(members) <= 1 {
+		return errors.New(fmt.Sprintf("PD process is the last member of PD cluster, procID: %s", d.ProcID))
+	}
+	if err := pd.DeleteMember(name); err != nil {
+		return err
+	}
+	log.Infof("PD process is removed from PD cluster, procID: %s, name: %s", d.ProcID, name)
+	return nil
+}
+
+// storeOfProcess finds the store in PD by the address which TiKV process serves
+func storeOfProcess(stores []*service.PDStore, status *proc.ProcessStatus) *service.PDStore {
+	addrs := make(map[string]struct{})
+	for _, name := range []string{"TIKV_ADVERTISE_ADDR", "TIKV_ADDR"} {
+		if ep, ok := status.RunInfo.Endpoints[name]; ok {
+			ep.Protocol = ""
+			addrs[ep.String()] = struct{}{}
+		}
+	}
+	for _, store := range stores {
+		if _, ok := addrs[store.Address]; ok {
+			return store
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/registry"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+// fakePD serves the HTTP API of PD which decommissions call, a deleted store becomes tombstone at once
+type fakePD struct {
+	mutex       sync.Mutex
+	stores      map[string]string // address to state, by ID from 1 in order of addresses
+	addrs       []string
+	members     []string
+	maxReplicas int
+	deleted     []string // the stores and members deleted, e.g. "store/1", "members/name/pd-1"
+}
+
+func (pd *fakePD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/pd/api/v1/")
+	store := func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"store":  map[string]interface{}{"id": i + 1, "address": pd.addrs[i], "state_name": pd.stores[pd.addrs[i]]},
+			"status": map[string]interface{}{"region_count": 0},
+		}
+	}
+	var resp interface{}
+	switch {
+	case r.Method == "GET" && path == "stores":
+		stores := []interface{}{}
+		for i := range pd.addrs {
+			stores = append(stores, store(i))
+		}
+		resp = map[string]interface{}{"stores": stores}
+	case r.Method == "GET" && strings.HasPrefix(path, "store/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "store/"))
+		resp = store(id - 1)
+	case r.Method == "DELETE" && strings.HasPrefix(path, "store/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "store/"))
+		pd.stores[pd.addrs[id-1]] = service.StoreTombstone
+		pd.deleted = append(pd.deleted, path)
+	case r.Method == "GET" && path == "config/replicate":
+		resp = map[string]interface{}{"max-replicas": pd.maxReplicas}
+	case r.Method == "GET" && path == "members":
+		members := []interface{}{}
+		for _, name := range pd.members {
+			members = append(members, map[string]interface{}{"name": name})
+		}
+		resp = map[string]interface{}{"members": members}
+	case r.Method == "DELETE" && strings.HasPrefix(path, "members/name/"):
+		pd.deleted = append(pd.deleted, path)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if resp != nil {
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func TestRunDecommission(t *testing.T) {
+	if err := service.RegisterDefinitions(service.DefaultDefinitions()); err != nil {
+		t.Fatalf("Failed to register services, %v", err)
+	}
+	tests := []struct {
+		name        string
+		svcName     string
+		stores      []string // the states of stores, the first is of the process decommissioned
+		maxReplicas int
+		members     []string
+		err         bool
+		deleted     []string
+	}{
+		{"offline TiKV store", service.TiKV_SERVICE, []string{"Up", "Up", "Up", "Up"}, 3, nil, false, []string{"store/1"}},
+		{"tombstone TiKV store", service.TiKV_SERVICE, []string{"Tombstone", "Up"}, 3, nil, false, nil},
+		{"too few TiKV stores left", service.TiKV_SERVICE, []string{"Up", "Up", "Up", "Offline"}, 3, nil, true, nil},
+		{"TiKV store not in PD", service.TiKV_SERVICE, nil, 3, nil, false, nil},
+		{"remove PD member", service.PD_SERVICE, nil, 3, []string{"pd-10001", "pd-10000"}, false, []string{"members/name/pd-10001"}},
+		{"PD not a member", service.PD_SERVICE, nil, 3, []string{"pd-10000"}, false, nil},
+		{"last PD member", service.PD_SERVICE, nil, 3, []string{"pd-10001"}, true, nil},
+	}
+	for _, tt := range tests {
+		pd := &fakePD{stores: make(map[string]string), members: tt.members, maxReplicas: tt.maxReplicas}
+		for i, state := range tt.stores {
+			addr := "10.0.0." + strconv.Itoa(i+1) + ":20160"
+			pd.addrs = append(pd.addrs, addr)
+			pd.stores[addr] = state
+		}
+		server := httptest.NewServer(pd)
+		host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+		pdPort, _ := strconv.Atoi(port)
+
+		reg := registry.NewMemoryRegistry("")
+		if err := reg.Bootstrap(); err != nil {
+			t.Fatalf("Bootstrap failed, %v", err)
+		}
+		// the alive PD process serving the API is 10000, the process decommissioned is 10001
+		pdID, _ := reg.NewProcess("m1", service.PD_SERVICE, &proc.ProcessRunInfo{
+			Args: []string{"--name", "pd-$PROCID"},
+			Endpoints: map[string]utils.Endpoint{
+				"PD_ADDR": utils.Endpoint{IPAddr: host, Port: utils.Port(pdPort)},
+			},
+		})
+		reg.UpdateProcessState(pdID, "m1", service.PD_SERVICE, proc.StateStarted, true, time.Minute)
+		procID, _ := reg.NewProcess("m2", tt.svcName, &proc.ProcessRunInfo{
+			Args: []string{"--name", "pd-$PROCID"},
+			Endpoints: map[string]utils.Endpoint{
+				"TIKV_ADDR": utils.Endpoint{IPAddr: "10.0.0.1", Port: utils.Port(20160)},
+			},
+		})
+
+		a := NewAgent(reg, nil, nil)
+		d, err := a.newDecommission(procID, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: newDecommission failed, %v", tt.name, err)
+		}
+		err = a.runDecommission(d)
+		server.Close()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: runDecommission returns error %v, want error %v", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(pd.deleted, tt.deleted) {
+			t.Errorf("%s: %q deleted in PD, want %q", tt.name, pd.deleted, tt.deleted)
+		}
+		d, _ = a.Decommission(procID)
+		_, err = reg.Process(procID)
+		if tt.err {
+			if d.State != DecommissionFailed || err != nil {
+				t.Errorf("%s: decommission %s, process found %v, want failed and the process kept", tt.name, d.State, err == nil)
+			}
+		} else if d.State != DecommissionCompleted || err == nil {
+			t.Errorf("%s: decommission %s, process found %v, want completed and the process destroyed", tt.name, d.State, err == nil)
+		}
+	}
+}
+
+func TestDecommissionKeptPerService(t *testing.T) {
+	reg := registry.NewMemoryRegistry("")
+	if err := reg.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap failed, %v", err)
+	}
+	a := NewAgent(reg, nil, nil)
+	procIDs := []string{}
+	for _, svcName := range []string{service.TiDB_SERVICE, service.TiDB_SERVICE, service.TiKV_SERVICE} {
+		procID, _ := reg.NewProcess("m1", svcName, &proc.ProcessRunInfo{})
+		procIDs = append(procIDs, procID)
+	}
+
+	first, err := a.newDecommission(procIDs[0], 0)
+	if err != nil {
+		t.Fatalf("newDecommission failed, %v", err)
+	}
+	if _, err := a.newDecommission(procIDs[1], 0); err == nil {
+		t.Errorf("Processes of a service are decommissioned at the same time")
+	}
+	if err := a.runDecommission(first); err != nil {
+		t.Fatalf("runDecommission failed, %v", err)
+	}
+	// the TiKV process fails to leave the cluster without PD
+	for _, procID := range procIDs[1:] {
+		d, err := a.newDecommission(procID, 0)
+		if err != nil {
+			t.Fatalf("newDecommission failed, %v", err)
+		}
+		a.runDecommission(d)
+	}
+	if _, err := a.Decommission(procIDs[0]); err == nil {
+		t.Errorf("Decommission of process %s is kept after another process of service decommissioned", procIDs[0])
+	}
+	for i, state := range []DecommissionState{DecommissionCompleted, DecommissionFailed} {
+		procID := procIDs[i+1]
+		if d, err := a.Decommission(procID); err != nil || d.State != state {
+			t.Errorf("Last decommission of process %s is %+v, %v, want %s", procID, d, err, state)
+		}
+	}
+	if len(a.decommissions) != 2 {
+		t.Errorf("%d decommissions kept, want the last one of each service", len(a.decommissions))
+	}
+}
@@ -0,0 +1,288 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+type ScaleState string
+
+const (
+	ScaleRunning   = ScaleState("running")
+	ScaleCompleted = ScaleState("completed")
+	// a step failed, the rest are left pending, scale again to retry
+	ScaleFailed = ScaleState("failed")
+)
+
+type ScaleAction string
+
+const (
+	// create a process on the machine chosen by scheduler
+	ScaleOut = ScaleAction("create")
+	// drain a surplus process and remove it
+	ScaleIn = ScaleAction("remove")
+)
+
+const (
+	StepRunning = StepState("running")
+	StepDone    = StepState("done")
+)
+
+// ScaleStep creates or removes a process of service
+type ScaleStep struct {
+	Action ScaleAction
+	// empty until the machine chosen for the process to create
+	MachID string
+	// empty until the process created
+	ProcID string
+	// why the machine is chosen, or why the process is removed
+	Reason string
+	State  StepState
+	Error  string
+}
+
+// Scale changes the number of processes of service to the replicas, driven by master,
+// which creates or removes one process at a time, and stops on the first failed step
+type Scale struct {
+	SvcName string
+	// the number of processes desired, and the number when the scale started
+	Replicas int
+	Previous int
+	// how long to wait for each created process healthy
+	HealthTimeout time.Duration
+	State         ScaleState
+	Steps         []*ScaleStep
+	Error         string
+	Started       time.Time
+	// zero until completed or failed
+	Finished time.Time
+}
+
+func (sc *Scale) copy() *Scale {
+	res := *sc
+	res.Steps = make([]*ScaleStep, 0, len(sc.Steps))
+	for _, step := range sc.Steps {
+		s := *step
+		res.Steps = append(res.Steps, &s)
+	}
+	return &res
+}
+
+// StartScale starts to create or remove processes of service in background until it has the replicas,
+// the surplus processes desired to be stopped, not alive or unhealthy are removed first.
+// A service can't be scaled while it's being scaled or upgraded, 0 healthTimeout means 5 minutes
+func (a *Agent) StartScale(svcName string, replicas int, healthTimeout time.Duration) (*Scale, error) {
+	if _, ok := service.Registered()[svcName]; !ok {
+		e := fmt.Sprintf("Unregistered service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	if replicas < 0 {
+		return nil, errors.New(fmt.Sprintf("Replicas of service %s should not be negative: %d", svcName, replicas))
+	}
+	if healthTimeout <= 0 {
+		healthTimeout = defaultHealthTimeout
+	}
+	if a.isUpgrading(svcName) {
+		e := fmt.Sprintf("Service %s is being upgraded, scale it after the upgrade finished", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	procs, err := a.Reg.ProcessesOfService(svcName)
+	if err != nil {
+		log.Errorf("List processes of service failed, %s, %v", svcName, err)
+		return nil, err
+	}
+
+	sc := &Scale{
+		SvcName:       svcName,
+		Replicas:      replicas,
+		Previous:      len(procs),
+		HealthTimeout: healthTimeout,
+		State:         ScaleRunning,
+		Steps:         []*ScaleStep{},
+		Started:       time.Now(),
+	}
+	for i := len(procs); i < replicas; i++ {
+		sc.Steps = append(sc.Steps, &ScaleStep{
+			Action: ScaleOut,
+			State:  StepPending,
+		})
+	}
+	if replicas < len(procs) {
+		for _, c := range surplusProcesses(procs, len(procs)-replicas) {
+			sc.Steps = append(sc.Steps, &ScaleStep{
+				Action: ScaleIn,
+				MachID: c.status.MachID,
+				ProcID: c.status.ProcID,
+				Reason: c.reason,
+				State:  StepPending,
+			})
+		}
+	}
+
+	a.scaleMutex.Lock()
+	if last, ok := a.scales[svcName]; ok && last.State == ScaleRunning {
+		a.scaleMutex.Unlock()
+		e := fmt.Sprintf("Service %s is being scaled to %d replicas", svcName, last.Replicas)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	if len(sc.Steps) == 0 {
+		sc.State = ScaleCompleted
+		sc.Finished = sc.Started
+	}
+	a.scales[svcName] = sc
+	res := sc.copy()
+	a.scaleMutex.Unlock()
+
+	if sc.State == ScaleRunning {
+		log.Infof("Start to scale service %s from %d to %d processes", svcName, sc.Previous, replicas)
+		go a.runScale(sc)
+	}
+	return res, nil
+}
+
+// Scale returns the last scale of service
+func (a *Agent) Scale(svcName string) (*Scale, error) {
+	a.scaleMutex.Lock()
+	defer a.scaleMutex.Unlock()
+	sc, ok := a.scales[svcName]
+	if !ok {
+		e := fmt.Sprintf("No scale of service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	return sc.copy(), nil
+}
+
+func (a *Agent) isScaling(svcName string) bool {
+	a.scaleMutex.Lock()
+	defer a.scaleMutex.Unlock()
+	sc, ok := a.scales[svcName]
+	return ok && sc.State == ScaleRunning
+}
+
+// updateScale modifies the scale with the lock held, which is read by API concurrently
+func (a *Agent) updateScale(f func()) {
+	a.scaleMutex.Lock()
+	defer a.scaleMutex.Unlock()
+	f()
+}
+
+func (a *Agent) runScale(sc *Scale) {
+	for _, step := range sc.Steps {
+		a.updateScale(func() {
+			step.State = StepRunning
+		})
+		var err error
+		if step.Action == ScaleOut {
+			err = a.scaleOut(sc, step)
+		} else {
+			err = a.DrainProcess(step.ProcID)
+		}
+		if err != nil {
+			log.Errorf("Failed to %s process of service %s, scale stopped, %v", step.Action, sc.SvcName, err)
+			a.updateScale(func() {
+				step.State = StepFailed
+				step.Error = err.Error()
+				sc.State = ScaleFailed
+				sc.Error = fmt.Sprintf("Failed to %s process", step.Action)
+				sc.Finished = time.Now()
+			})
+			return
+		}
+		a.updateScale(func() {
+			step.State = StepDone
+		})
+	}
+	a.updateScale(func() {
+		sc.State = ScaleCompleted
+		sc.Finished = time.Now()
+	})
+	log.Infof("Scale of service %s completed, %d processes", sc.SvcName, sc.Replicas)
+}
+
+// scaleOut creates a process on the machine chosen by scheduler, and waits for it healthy
+func (a *Agent) scaleOut(sc *Scale, step *ScaleStep) error {
+	placement, err := a.SchedulePlacement(sc.SvcName, PlacementConstraints{})
+	if err != nil {
+		return err
+	}
+	a.updateScale(func() {
+		step.MachID = placement.MachID
+		step.Reason = placement.Reason
+	})
+	procID, err := a.StartNewProcess(placement.MachID, sc.SvcName, &proc.ProcessRunInfo{})
+	if err != nil {
+		return err
+	}
+	a.updateScale(func() {
+		step.ProcID = procID
+	})
+	return a.WaitProcessHealthy(procID, sc.HealthTimeout)
+}
+
+// removalCandidate is a process which can be removed on scaling in, the lower rank the earlier removed
+type removalCandidate struct {
+	status *proc.ProcessStatus
+	rank   int
+	reason string
+	// the number of processes of the same service on the machine
+	onHost int
+}
+
+func (c *removalCandidate) less(o *removalCandidate) bool {
+	if c.rank != o.rank {
+		return c.rank < o.rank
+	}
+	// remove from the crowded machines first, then the newest process
+	if c.onHost != o.onHost {
+		return c.onHost > o.onHost
+	}
+	if len(c.status.ProcID) != len(o.status.ProcID) {
+		return len(c.status.ProcID) > len(o.status.ProcID)
+	}
+	return c.status.ProcID > o.status.ProcID
+}
+
+// surplusProcesses chooses n processes to remove, the stopped ones first, then the dead, unhealthy and healthy ones
+func surplusProcesses(procs map[string]*proc.ProcessStatus, n int) []*removalCandidate {
+	onHost := make(map[string]int)
+	for _, status := range procs {
+		onHost[status.MachID]++
+	}
+	candidates := make([]*removalCandidate, 0, len(procs))
+	for _, status := range procs {
+		c := &removalCandidate{
+			status: status,
+			onHost: onHost[status.MachID],
+		}
+		switch {
+		case status.DesiredState == proc.StateStopped:
+			c.rank, c.reason = 0, "desired to be stopped"
+		case !status.IsAlive || status.CurrentState == proc.StateStopped:
+			c.rank, c.reason = 1, "not running"
+		case status.RestartStatus.CrashLoop:
+			c.rank, c.reason = 2, "crash-looping"
+		case status.Health == proc.HealthUnhealthy:
+			c.rank, c.reason = 3, "unhealthy"
+		default:
+			c.rank, c.reason = 4, "surplus"
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].less(candidates[j])
+	})
+	if n < len(candidates) {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
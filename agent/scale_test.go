@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+func TestSurplusProcesses(t *testing.T) {
+	newProcess := func(procID, machID string, f func(*proc.ProcessStatus)) *proc.ProcessStatus {
+		status := &proc.ProcessStatus{
+			ProcID:       procID,
+			MachID:       machID,
+			DesiredState: proc.StateStarted,
+			CurrentState: proc.StateStarted,
+			IsAlive:      true,
+			Health:       proc.HealthHealthy,
+		}
+		f(status)
+		return status
+	}
+	healthy := func(*proc.ProcessStatus) {}
+	procs := map[string]*proc.ProcessStatus{
+		"9999":  newProcess("9999", "m1", healthy),
+		"10001": newProcess("10001", "m1", healthy),
+		"10002": newProcess("10002", "m2", healthy),
+		"10003": newProcess("10003", "m3", func(s *proc.ProcessStatus) { s.Health = proc.HealthUnhealthy }),
+		"10004": newProcess("10004", "m4", func(s *proc.ProcessStatus) { s.RestartStatus.CrashLoop = true }),
+		"10005": newProcess("10005", "m5", func(s *proc.ProcessStatus) { s.IsAlive = false }),
+		"10006": newProcess("10006", "m6", func(s *proc.ProcessStatus) { s.CurrentState = proc.StateStopped }),
+		"10007": newProcess("10007", "m7", func(s *proc.ProcessStatus) { s.DesiredState = proc.StateStopped }),
+	}
+	tests := []struct {
+		n       int
+		procIDs []string
+		reasons []string
+	}{
+		{0, []string{}, []string{}},
+		{1, []string{"10007"}, []string{"desired to be stopped"}},
+		// the newest process first among the not running ones
+		{3, []string{"10007", "10006", "10005"}, []string{"desired to be stopped", "not running", "not running"}},
+		{5, []string{"10007", "10006", "10005", "10004", "10003"},
+			[]string{"desired to be stopped", "not running", "not running", "crash-looping", "unhealthy"}},
+		// the crowded machine first, and the procIDs are compared by number
+		{7, []string{"10007", "10006", "10005", "10004", "10003", "10001", "9999"},
+			[]string{"desired to be stopped", "not running", "not running", "crash-looping", "unhealthy", "surplus", "surplus"}},
+		{10, []string{"10007", "10006", "10005", "10004", "10003", "10001", "9999", "10002"},
+			[]string{"desired to be stopped", "not running", "not running", "crash-looping", "unhealthy", "surplus", "surplus", "surplus"}},
+	}
+	for _, tt := range tests {
+		procIDs, reasons := []string{}, []string{}
+		for _, c := range surplusProcesses(procs, tt.n) {
+			procIDs = append(procIDs, c.status.ProcID)
+			reasons = append(reasons, c.reason)
+		}
+		if !reflect.DeepEqual(procIDs, tt.procIDs) || !reflect.DeepEqual(reasons, tt.reasons) {
+			t.Errorf("surplusProcesses(%d) = %v, %q, want %v, %q", tt.n, procIDs, reasons, tt.procIDs, tt.reasons)
+		}
+	}
+}
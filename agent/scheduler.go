@@ -0,0 +1,292 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/machine"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+const (
+	// machines busier than these are never chosen to place new processes
+	maxCPUUsage  = 90.0 // percent
+	maxMemUsage  = 0.9
+	maxDiskUsage = 0.9
+)
+
+// PlacementConstraints restricts the machines on which a new process can be placed, empty means any
+type PlacementConstraints struct {
+	Region string
+	IDC    string
+}
+
+// Placement is the machine chosen by scheduler, and why it's chosen
+type Placement struct {
+	MachID string
+	Reason string
+}
+
+// candidate is a machine which the new process can be placed on
+type candidate struct {
+	mach *machine.MachineStatus
+	// the number of processes of the same service in the region, IDC, and on the machine
+	inRegion int
+	inIDC    int
+	onHost   int
+	// the number of all processes on the machine
+	total int
+	// the highest usage ratio of CPU, memory and disks
+	load float64
+}
+
+func (c *candidate) less(o *candidate) bool {
+	// spread the processes of service across regions first, then IDCs, then machines
+	if c.inRegion != o.inRegion {
+		return c.inRegion < o.inRegion
+	}
+	if c.inIDC != o.inIDC {
+		return c.inIDC < o.inIDC
+	}
+	if c.onHost != o.onHost {
+		return c.onHost < o.onHost
+	}
+	if c.load != o.load {
+		return c.load < o.load
+	}
+	if c.total != o.total {
+		return c.total < o.total
+	}
+	return c.mach.MachID < o.mach.MachID
+}
+
+// SchedulePlacement chooses a machine to place a new process of service,
+// by the live stats of machines, the processes already placed, and the placement rule of service
+func (a *Agent) SchedulePlacement(svcName string, constraints PlacementConstraints) (*Placement, error) {
+	svc, ok := service.Registered()[svcName]
+	if !ok {
+		e := fmt.Sprintf("Unregistered service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	rule := svc.Status().Placement
+	machs, err := a.Reg.Machines()
+	if err != nil {
+		log.Errorf("List all machines in cluster failed, %v", err)
+		return nil, err
+	}
+	procs, err := a.Reg.Processes()
+	if err != nil {
+		log.Errorf("List all processes failed, %v", err)
+		return nil, err
+	}
+
+	regionCount := make(map[string]int)
+	idcCount := make(map[string]int)
+	hostCount := make(map[string]int)
+	totalCount := make(map[string]int)
+	peers := []*machine.MachineStatus{}
+	for _, p := range procs {
+		totalCount[p.MachID]++
+		if p.SvcName != svcName {
+			continue
+		}
+		hostCount[p.MachID]++
+		if mach, ok := machs[p.MachID]; ok {
+			peers = append(peers, mach)
+			regionCount[mach.MachInfo.HostRegion]++
+			idcCount[idcKey(mach)]++
+		}
+	}
+
+	candidates := []*candidate{}
+	rejected := []string{}
+	for machID, mach := range machs {
+		if reason := unplaceable(mach, constraints); len(reason) > 0 {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", machID, reason))
+			continue
+		}
+		if rule != nil {
+			if violations := rule.Check(mach, peers); len(violations) > 0 {
+				rejected = append(rejected, fmt.Sprintf("%s: %s", machID, strings.Join(violations, ", ")))
+				continue
+			}
+		}
+		candidates = append(candidates, &candidate{
+			mach:     mach,
+			inRegion: regionCount[mach.MachInfo.HostRegion],
+			inIDC:    idcCount[idcKey(mach)],
+			onHost:   hostCount[machID],
+			total:    totalCount[machID],
+			load:     machineLoad(mach.MachStat),
+		})
+	}
+	if len(candidates) == 0 {
+		sort.Strings(rejected)
+		e := fmt.Sprintf("No machine available to place process of service %s, rejected: [%s]",
+			svcName, strings.Join(rejected, "; "))
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].less(candidates[j])
+	})
+
+	best := candidates[0]
+	reason := fmt.Sprintf("Chosen from %d candidates (%d rejected) for the fewest %s processes in region %s (%d), "+
+		"IDC %s (%d) and on the machine (%d), load %.0f%%, %d processes on the machine",
+		len(candidates), len(rejected), svcName, best.mach.MachInfo.HostRegion, best.inRegion,
+		best.mach.MachInfo.HostIDC, best.inIDC, best.onHost, best.load*100, best.total)
+	log.Infof("Placed new process of service %s on machine %s, %s", svcName, best.mach.MachID, reason)
+	return &Placement{
+		MachID: best.mach.MachID,
+		Reason: reason,
+	}, nil
+}
+
+// unplaceable returns why no new process can be placed on the machine, empty if it can be
+func unplaceable(mach *machine.MachineStatus, constraints PlacementConstraints) string {
+	if !mach.IsAlive {
+		return "offline"
+	}
+	if len(constraints.Region) > 0 && mach.MachInfo.HostRegion != constraints.Region {
+		return fmt.Sprintf("not in region %s", constraints.Region)
+	}
+	if len(constraints.IDC) > 0 && mach.MachInfo.HostIDC != constraints.IDC {
+		return fmt.Sprintf("not in IDC %s", constraints.IDC)
+	}
+	stat := mach.MachStat
+	if stat.UsageOfCPU > maxCPUUsage {
+		return fmt.Sprintf("CPU usage %.0f%% too high", stat.UsageOfCPU)
+	}
+	if ratio(stat.UsedMem, stat.TotalMem) > maxMemUsage {
+		return fmt.Sprintf("memory usage %.0f%% too high", ratio(stat.UsedMem, stat.TotalMem)*100)
+	}
+	for _, disk := range stat.UsageOfDisk {
+		if ratio(disk.UsedSize, disk.TotalSize) > maxDiskUsage {
+			return fmt.Sprintf("disk usage of %s %.0f%% too high", disk.Mount, ratio(disk.UsedSize, disk.TotalSize)*100)
+		}
+	}
+	return ""
+}
+
+// machineLoad is the highest usage ratio among CPU, memory and disks of machine
+func machineLoad(stat machine.MachineStat) float64 {
+	load := stat.UsageOfCPU / 100
+	if r := ratio(stat.UsedMem, stat.TotalMem); r > load {
+		load = r
+	}
+	for _, disk := range stat.UsageOfDisk {
+		if r := ratio(disk.UsedSize, disk.TotalSize); r > load {
+			load = r
+		}
+	}
+	return load
+}
+
+func ratio(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total)
+}
+
+// idcKey identifies the IDC across regions, which may share the names of IDCs
+func idcKey(mach *machine.MachineStatus) string {
+	return mach.MachInfo.HostRegion + "/" + mach.MachInfo.HostIDC
+}
+
+// PlacementViolation is a process placed against the placement rule of its service
+type PlacementViolation struct {
+	ProcID     string
+	MachID     string
+	SvcName    string
+	Violations []string
+}
+
+// PlacementViolations checks the processes of service placed already, returns those violating the placement rule
+func (a *Agent) PlacementViolations(svcName string) ([]*PlacementViolation, error) {
+	svc, ok := service.Registered()[svcName]
+	if !ok {
+		e := fmt.Sprintf("Unregistered service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	res := []*PlacementViolation{}
+	rule := svc.Status().Placement
+	if rule == nil {
+		return res, nil
+	}
+	machs, err := a.Reg.Machines()
+	if err != nil {
+		log.Errorf("List all machines in cluster failed, %v", err)
+		return nil, err
+	}
+	procs, err := a.Reg.ProcessesOfService(svcName)
+	if err != nil {
+		log.Errorf("List processes of specified service, %s, %v", svcName, err)
+		return nil, err
+	}
+	for procID, p := range procs {
+		mach, ok := machs[p.MachID]
+		if !ok {
+			// the machine has never registered, nothing to check against
+			continue
+		}
+		if violations := rule.Check(mach, servicePeers(procs, machs, procID)); len(violations) > 0 {
+			res = append(res, &PlacementViolation{
+				ProcID:     procID,
+				MachID:     p.MachID,
+				SvcName:    svcName,
+				Violations: violations,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ProcID < res[j].ProcID
+	})
+	return res, nil
+}
+
+// checkPlacement returns error if a new process of service placed on the machine violates the placement rule
+func (a *Agent) checkPlacement(svcName string, rule *service.PlacementRule, mach *machine.MachineStatus) error {
+	if rule == nil {
+		return nil
+	}
+	machs, err := a.Reg.Machines()
+	if err != nil {
+		log.Errorf("List all machines in cluster failed, %v", err)
+		return err
+	}
+	procs, err := a.Reg.ProcessesOfService(svcName)
+	if err != nil {
+		log.Errorf("List processes of specified service, %s, %v", svcName, err)
+		return err
+	}
+	if violations := rule.Check(mach, servicePeers(procs, machs, "")); len(violations) > 0 {
+		e := fmt.Sprintf("Placing process of service %s on machine %s violates the placement rule: %s",
+			svcName, mach.MachID, strings.Join(violations, "; "))
+		log.Error(e)
+		return errors.New(e)
+	}
+	return nil
+}
+
+// servicePeers returns the machines the processes placed on, except the one of procID, once for each process
+func servicePeers(procs map[string]*proc.ProcessStatus, machs map[string]*machine.MachineStatus,
+	procID string) []*machine.MachineStatus {
+	peers := []*machine.MachineStatus{}
+	for id, p := range procs {
+		if id == procID {
+			continue
+		}
+		if mach, ok := machs[p.MachID]; ok {
+			peers = append(peers, mach)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/registry"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+func TestCheckPlacement(t *testing.T) {
+	reg := registry.NewMemoryRegistry("")
+	if err := reg.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap failed, %v", err)
+	}
+	machines := []struct {
+		machID, hostName, region, idc string
+	}{
+		{"m1", "host1", "bj", "idc1"},
+		{"m2", "host2", "bj", "idc1"},
+		{"m3", "host3", "bj", "idc2"},
+		{"m4", "host4", "sh", "idc3"},
+	}
+	for _, m := range machines {
+		if err := reg.RegisterMachine(m.machID, m.hostName, m.region, m.idc, "127.0.0.1", 9000); err != nil {
+			t.Fatalf("RegisterMachine(%s) failed, %v", m.machID, err)
+		}
+	}
+	// one PD process on m1 and m2 each, and a TiKV process on m3
+	for _, p := range []struct{ machID, svcName string }{
+		{"m1", service.PD_SERVICE},
+		{"m2", service.PD_SERVICE},
+		{"m3", service.TiKV_SERVICE},
+	} {
+		if _, err := reg.NewProcess(p.machID, p.svcName, &proc.ProcessRunInfo{}); err != nil {
+			t.Fatalf("NewProcess(%s, %s) failed, %v", p.machID, p.svcName, err)
+		}
+	}
+
+	a := NewAgent(reg, nil, nil)
+	tests := []struct {
+		name   string
+		rule   *service.PlacementRule
+		machID string
+		err    bool
+	}{
+		{"no rule", nil, "m1", false},
+		{"no limit", &service.PlacementRule{}, "m1", false},
+		{"host taken", &service.PlacementRule{MaxPerHost: 1}, "m1", true},
+		{"host taken by another service", &service.PlacementRule{MaxPerHost: 1}, "m3", false},
+		{"host limit not reached", &service.PlacementRule{MaxPerHost: 2}, "m1", false},
+		{"another IDC", &service.PlacementRule{MaxPerIDC: 2}, "m3", false},
+		{"IDC full", &service.PlacementRule{MaxPerIDC: 2}, "m2", true},
+		{"region full", &service.PlacementRule{MaxPerRegion: 2}, "m3", true},
+		{"another region", &service.PlacementRule{MaxPerRegion: 2}, "m4", false},
+		{"label matched", &service.PlacementRule{RequiredLabels: map[string]string{service.LabelRegion: "sh"}}, "m4", false},
+		{"label not matched", &service.PlacementRule{RequiredLabels: map[string]string{service.LabelRegion: "sh"}}, "m3", true},
+	}
+	for _, tt := range tests {
+		mach, err := reg.Machine(tt.machID)
+		if err != nil {
+			t.Fatalf("Machine(%s) failed, %v", tt.machID, err)
+		}
+		err = a.checkPlacement(service.PD_SERVICE, tt.rule, mach)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: checkPlacement of PD on %s returns error %v, want error %v", tt.name, tt.machID, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+// CreateService saves the definition of new service in registry, and registers it at once,
+// other masters and minions register it on their next reload
+func (a *Agent) CreateService(def *service.Definition) error {
+	if _, ok := service.Registered()[def.SvcName]; ok {
+		e := fmt.Sprintf("Service already exists, svcName: %s", def.SvcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	defs := registeredDefinitions()
+	defs[def.SvcName] = def
+	if _, err := service.NewServices(defs); err != nil {
+		log.Errorf("Invalid definition of service %s, %v", def.SvcName, err)
+		return err
+	}
+	if err := a.Reg.CreateService(def); err != nil {
+		return err
+	}
+	return service.RegisterDefinitions(defs)
+}
+
+// UpdateService replaces the definition of service, which takes effect on the processes created afterwards
+func (a *Agent) UpdateService(def *service.Definition) error {
+	if _, ok := service.Registered()[def.SvcName]; !ok {
+		e := fmt.Sprintf("Unregistered service: %s", def.SvcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	defs := registeredDefinitions()
+	defs[def.SvcName] = def
+	if _, err := service.NewServices(defs); err != nil {
+		log.Errorf("Invalid definition of service %s, %v", def.SvcName, err)
+		return err
+	}
+	if err := a.Reg.UpdateService(def); err != nil {
+		return err
+	}
+	return service.RegisterDefinitions(defs)
+}
+
+// DeleteService removes the definition of service, which must have no process and no service depending on it
+func (a *Agent) DeleteService(svcName string) error {
+	if _, ok := service.Registered()[svcName]; !ok {
+		e := fmt.Sprintf("Unregistered service: %s", svcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	procs, err := a.Reg.ProcessesOfService(svcName)
+	if err != nil {
+		log.Errorf("List processes of service failed, %s, %v", svcName, err)
+		return err
+	}
+	if len(procs) > 0 {
+		e := fmt.Sprintf("Service %s still has %d processes, destroy them first", svcName, len(procs))
+		log.Error(e)
+		return errors.New(e)
+	}
+	defs := registeredDefinitions()
+	delete(defs, svcName)
+	if _, err := service.NewServices(defs); err != nil {
+		log.Errorf("Failed to delete service %s, %v", svcName, err)
+		return err
+	}
+	if err := a.Reg.DeleteService(svcName); err != nil {
+		return err
+	}
+	return service.RegisterDefinitions(defs)
+}
+
+func registeredDefinitions() map[string]*service.Definition {
+	defs := make(map[string]*service.Definition)
+	for name, svc := range service.Registered() {
+		defs[name] = svc.Definition()
+	}
+	return defs
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+	"github.com/qiuyesuifeng/tidb-demo/topology"
+)
+
+const (
+	// how long to wait for a process stopped by its minion, which covers the longest grace period of services
+	processStopTimeout = 10 * time.Minute
+	// how often to check whether the process has been stopped
+	processStopPollInterval = time.Second
+)
+
+// ApplyTopology diffs the topology against the processes in registry, and applies the actions to converge
+// unless dryRun, stops at the first failed action, whose error is set in the returned actions.
+// The placement rules overridden by topology are updated before the actions applied
+func (a *Agent) ApplyTopology(topo *topology.Topology, dryRun bool) ([]*topology.Action, error) {
+	procs, err := a.Reg.Processes()
+	if err != nil {
+		log.Errorf("List all processes failed, %v", err)
+		return nil, err
+	}
+	actions, err := topology.Diff(topo, procs)
+	if err != nil {
+		log.Errorf("Failed to diff topology, %v", err)
+		return nil, err
+	}
+	if dryRun {
+		return actions, nil
+	}
+	if err := a.applyPlacementRules(topo); err != nil {
+		return nil, err
+	}
+	for _, act := range actions {
+		if err := a.applyAction(act); err != nil {
+			act.Error = err.Error()
+			log.Errorf("Failed to apply topology, %s process of service %s, procID: %s, %v",
+				act.Type, act.SvcName, act.ProcID, err)
+			return actions, err
+		}
+		log.Infof("Applied topology, %s process of service %s, procID: %s, machID: %s, %s",
+			act.Type, act.SvcName, act.ProcID, act.MachID, act.Reason)
+	}
+	return actions, nil
+}
+
+// applyPlacementRules updates the placement rules of services overridden by topology,
+// before any process created by topology
+func (a *Agent) applyPlacementRules(topo *topology.Topology) error {
+	for _, spec := range topo.Services {
+		if spec.Placement == nil {
+			continue
+		}
+		svc, ok := service.Registered()[spec.Name]
+		if !ok {
+			e := fmt.Sprintf("Unregistered service: %s", spec.Name)
+			log.Error(e)
+			return errors.New(e)
+		}
+		rule := spec.Placement.PlacementRule()
+		if reflect.DeepEqual(rule, svc.Status().Placement) {
+			continue
+		}
+		def := *svc.Definition()
+		def.Placement = rule
+		if err := a.UpdateService(&def); err != nil {
+			log.Errorf("Failed to update placement rule of service %s, %v", spec.Name, err)
+			return err
+		}
+		log.Infof("Applied topology, placement rule of service %s updated, %+v", spec.Name, rule)
+	}
+	return nil
+}
+
+func (a *Agent) applyAction(act *topology.Action) error {
+	switch act.Type {
+	case topology.ActionCreate:
+		if len(act.MachID) == 0 {
+			placement, err := a.SchedulePlacement(act.SvcName, PlacementConstraints{
+				Region: act.Spec.Region,
+				IDC:    act.Spec.IDC,
+			})
+			if err != nil {
+				return err
+			}
+			act.MachID = placement.MachID
+			act.Reason = fmt.Sprintf("%s, %s", act.Reason, placement.Reason)
+		}
+		_, err := a.StartNewProcess(act.MachID, act.SvcName, act.Spec.RunInfo())
+		return err
+	case topology.ActionDestroy:
+		return a.DrainProcess(act.ProcID)
+	case topology.ActionUpdate:
+		// the run spec is updated in place, so that the process keeps its procID, run history and data,
+		// the settings not described in topology are kept from the ones given by the creator of process
+		spec := act.Spec.RunInfo()
+		runinfo := &proc.ProcessRunInfo{
+			Version:     act.Current.RunInfo.Version,
+			Executor:    act.Current.RunInfo.Executor,
+			Command:     act.Current.RunInfo.Command,
+			Args:        spec.Args,
+			Environment: spec.Environment,
+			LogRotation: act.Current.RunInfo.LogRotation,
+			Limits:      act.Current.RunInfo.Limits,
+			Stop:        act.Current.RunInfo.Stop,
+		}
+		var restart RestartUpdate
+		if len(spec.Restart.Policy) > 0 {
+			restart.Policy = &spec.Restart.Policy
+		}
+		if spec.Restart.MaxRetries > 0 {
+			restart.MaxRetries = &spec.Restart.MaxRetries
+		}
+		if _, err := a.UpdateProcess(act.ProcID, act.Current.RunInfo.Generation, runinfo, restart, false); err != nil {
+			return err
+		}
+		if act.Current.DesiredState != proc.StateStarted {
+			return a.StartProcess(act.ProcID)
+		}
+		return nil
+	case topology.ActionStart:
+		return a.StartProcess(act.ProcID)
+	default:
+		return errors.New(fmt.Sprintf("Unknown action of topology: %s", act.Type))
+	}
+}
+
+// WaitProcessStopped waits until the minion reports the process stopped, or the minion is gone
+func (a *Agent) WaitProcessStopped(procID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := a.Reg.Process(procID)
+		if err != nil {
+			log.Errorf("List specified process failed, %s, %v", procID, err)
+			return err
+		}
+		if status == nil || status.CurrentState == proc.StateStopped || !status.IsAlive {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			e := fmt.Sprintf("Timed out waiting for process stopped after %v, procID: %s", timeout, procID)
+			log.Error(e)
+			return errors.New(e)
+		}
+		time.Sleep(processStopPollInterval)
+	}
+}
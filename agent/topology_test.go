@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/registry"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+	"github.com/qiuyesuifeng/tidb-demo/topology"
+)
+
+func TestApplyTopologyUpdate(t *testing.T) {
+	if err := service.RegisterDefinitions(service.DefaultDefinitions()); err != nil {
+		t.Fatalf("Failed to register services, %v", err)
+	}
+	reg := registry.NewMemoryRegistry("")
+	if err := reg.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap failed, %v", err)
+	}
+	procID, err := reg.NewProcess("m1", service.PD_SERVICE, &proc.ProcessRunInfo{
+		Command: "bin/pd-server",
+		Args:    []string{"--name", "pd-old", "--join", "http://10.0.0.2:1234"},
+	})
+	if err != nil {
+		t.Fatalf("NewProcess failed, %v", err)
+	}
+	if err := reg.UpdateProcessDesiredState(procID, proc.StateStopped); err != nil {
+		t.Fatalf("UpdateProcessDesiredState failed, %v", err)
+	}
+
+	a := NewAgent(reg, nil, nil)
+	topo := &topology.Topology{Services: []*topology.ServiceSpec{
+		{Name: service.PD_SERVICE, Hosts: []string{"m1"}, Args: []string{"--name", "pd-$PROCID"}, RestartPolicy: "always"},
+	}}
+	actions, err := a.ApplyTopology(topo, false)
+	if err != nil {
+		t.Fatalf("ApplyTopology failed, %v", err)
+	}
+	if len(actions) != 1 || actions[0].Type != topology.ActionUpdate {
+		t.Fatalf("ApplyTopology applies %+v, want an update", actions)
+	}
+
+	procs, _ := reg.Processes()
+	if len(procs) != 1 {
+		t.Fatalf("%d processes after the update, want the process updated in place", len(procs))
+	}
+	status, err := reg.Process(procID)
+	if err != nil {
+		t.Fatalf("Process %s is gone after the update, %v", procID, err)
+	}
+	want := []string{"--name", "pd-$PROCID", "--join", "http://10.0.0.2:1234"}
+	if !reflect.DeepEqual(status.RunInfo.Args, want) {
+		t.Errorf("Process has args %q after the update, want %q", status.RunInfo.Args, want)
+	}
+	if status.RunInfo.Generation != 1 || status.RunInfo.Command != "bin/pd-server" ||
+		status.RunInfo.Restart.Policy != proc.RestartAlways {
+		t.Errorf("Process has run info %+v after the update", status.RunInfo)
+	}
+	if status.DesiredState != proc.StateStarted {
+		t.Errorf("Process is desired to be %s after the update, want started", status.DesiredState)
+	}
+}
@@ -0,0 +1,425 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+const (
+	// how long to wait for the upgraded process healthy by default
+	defaultHealthTimeout = 5 * time.Minute
+	// how often to check whether the upgraded process is healthy
+	healthPollInterval = time.Second
+)
+
+type UpgradeState string
+
+const (
+	UpgradeRunning = UpgradeState("running")
+	// a step failed, the upgrade waits to be resumed or rolled back
+	UpgradePaused    = UpgradeState("paused")
+	UpgradeCompleted = UpgradeState("completed")
+	// the upgraded processes are being moved back to the previous run info, one at a time
+	UpgradeRollingBack = UpgradeState("rolling-back")
+	UpgradeRolledBack  = UpgradeState("rolled-back")
+)
+
+type StepState string
+
+const (
+	StepPending     = StepState("pending")
+	StepUpgrading   = StepState("upgrading")
+	StepUpgraded    = StepState("upgraded")
+	StepFailed      = StepState("failed")
+	StepRollingBack = StepState("rolling-back")
+	StepRolledBack  = StepState("rolled-back")
+)
+
+// UpgradeSpec is the new settings of service, the empty ones are left as they are
+type UpgradeSpec struct {
+	Version      string
+	Executor     []string
+	Command      string
+	Args         []string
+	Environments map[string]string
+	// how long to wait for each upgraded process healthy, 0 means 5 minutes
+	HealthTimeout time.Duration
+}
+
+// UpgradeStep moves a process of service to the new settings, by recreating it on the same machine
+type UpgradeStep struct {
+	MachID string
+	// the process running on the machine, which changes on each step, empty if destroyed and not recreated yet
+	ProcID string
+	// whether the process was desired to be stopped, it's stopped again after recreated
+	Stopped bool
+	// the run info of process before upgraded, which is restored on rollback
+	Previous proc.ProcessRunInfo
+	State    StepState
+	Error    string
+	// the procID of process before upgraded, which the recreated processes are still identified by
+	origProcID string
+}
+
+// Upgrade is a rolling upgrade of service driven by master, which upgrades one process at a time and waits
+// for it healthy before the next, the upgrade pauses on the first failed step
+type Upgrade struct {
+	SvcName string
+	Spec    UpgradeSpec
+	State   UpgradeState
+	Steps   []*UpgradeStep
+	Error   string
+	Started time.Time
+	// zero until completed or rolled back
+	Finished time.Time
+	// the definition of service before upgraded, which is updated with the spec on completion
+	previousDef *service.Definition
+}
+
+func (up *Upgrade) copy() *Upgrade {
+	res := *up
+	res.Steps = make([]*UpgradeStep, 0, len(up.Steps))
+	for _, step := range up.Steps {
+		s := *step
+		res.Steps = append(res.Steps, &s)
+	}
+	return &res
+}
+
+// StartUpgrade starts to upgrade all processes of service to the spec in background,
+// one upgrade of a service at a time unless the last one completed or rolled back
+func (a *Agent) StartUpgrade(svcName string, spec UpgradeSpec) (*Upgrade, error) {
+	svc, ok := service.Registered()[svcName]
+	if !ok {
+		e := fmt.Sprintf("Unregistered service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	if len(spec.Version) == 0 && len(spec.Executor) == 0 && len(spec.Command) == 0 &&
+		len(spec.Args) == 0 && len(spec.Environments) == 0 {
+		return nil, errors.New(fmt.Sprintf("Nothing to upgrade for service %s", svcName))
+	}
+	if spec.HealthTimeout <= 0 {
+		spec.HealthTimeout = defaultHealthTimeout
+	}
+	if a.isScaling(svcName) {
+		e := fmt.Sprintf("Service %s is being scaled, upgrade it after the scale finished", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	procs, err := a.Reg.ProcessesOfService(svcName)
+	if err != nil {
+		log.Errorf("List processes of service failed, %s, %v", svcName, err)
+		return nil, err
+	}
+	procIDs := make([]string, 0, len(procs))
+	for procID := range procs {
+		procIDs = append(procIDs, procID)
+	}
+	sort.Strings(procIDs)
+
+	up := &Upgrade{
+		SvcName:     svcName,
+		Spec:        spec,
+		State:       UpgradeRunning,
+		Steps:       []*UpgradeStep{},
+		Started:     time.Now(),
+		previousDef: svc.Definition(),
+	}
+	for _, procID := range procIDs {
+		status := procs[procID]
+		previous := status.RunInfo
+		previous.Args = pinProcID(previous.Args, procID)
+		up.Steps = append(up.Steps, &UpgradeStep{
+			MachID:     status.MachID,
+			ProcID:     procID,
+			Stopped:    status.DesiredState == proc.StateStopped,
+			Previous:   previous,
+			State:      StepPending,
+			origProcID: procID,
+		})
+	}
+
+	a.upgradeMutex.Lock()
+	if last, ok := a.upgrades[svcName]; ok && last.State != UpgradeCompleted && last.State != UpgradeRolledBack {
+		a.upgradeMutex.Unlock()
+		e := fmt.Sprintf("Service %s is being upgraded, the upgrade is %s", svcName, last.State)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	a.upgrades[svcName] = up
+	res := up.copy()
+	a.upgradeMutex.Unlock()
+
+	log.Infof("Start to upgrade service %s, %d processes", svcName, len(up.Steps))
+	go a.runUpgrade(up)
+	return res, nil
+}
+
+// Upgrade returns the last upgrade of service
+func (a *Agent) Upgrade(svcName string) (*Upgrade, error) {
+	a.upgradeMutex.Lock()
+	defer a.upgradeMutex.Unlock()
+	up, ok := a.upgrades[svcName]
+	if !ok {
+		e := fmt.Sprintf("No upgrade of service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	return up.copy(), nil
+}
+
+// isUpgrading tells whether the last upgrade of service is not completed or rolled back
+func (a *Agent) isUpgrading(svcName string) bool {
+	a.upgradeMutex.Lock()
+	defer a.upgradeMutex.Unlock()
+	up, ok := a.upgrades[svcName]
+	return ok && up.State != UpgradeCompleted && up.State != UpgradeRolledBack
+}
+
+// ResumeUpgrade retries the failed step of paused upgrade, and goes on with the rest
+func (a *Agent) ResumeUpgrade(svcName string) (*Upgrade, error) {
+	return a.switchUpgrade(svcName, []UpgradeState{UpgradePaused}, UpgradeRunning, a.runUpgrade)
+}
+
+// RollbackUpgrade moves the upgraded processes back to their previous run info, in the reverse order,
+// and restores the definition of service, the upgrade must be paused or completed
+func (a *Agent) RollbackUpgrade(svcName string) (*Upgrade, error) {
+	return a.switchUpgrade(svcName, []UpgradeState{UpgradePaused, UpgradeCompleted}, UpgradeRollingBack, a.runRollback)
+}
+
+func (a *Agent) switchUpgrade(svcName string, from []UpgradeState, to UpgradeState, run func(*Upgrade)) (*Upgrade, error) {
+	a.upgradeMutex.Lock()
+	up, ok := a.upgrades[svcName]
+	if !ok {
+		a.upgradeMutex.Unlock()
+		e := fmt.Sprintf("No upgrade of service: %s", svcName)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	allowed := false
+	for _, state := range from {
+		if up.State == state {
+			allowed = true
+		}
+	}
+	if !allowed {
+		a.upgradeMutex.Unlock()
+		e := fmt.Sprintf("The upgrade of service %s is %s, can't be %s", svcName, up.State, to)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	up.State = to
+	up.Error = ""
+	up.Finished = time.Time{}
+	res := up.copy()
+	a.upgradeMutex.Unlock()
+
+	go run(up)
+	return res, nil
+}
+
+// updateUpgrade modifies the upgrade with the lock held, which is read by API concurrently
+func (a *Agent) updateUpgrade(f func()) {
+	a.upgradeMutex.Lock()
+	defer a.upgradeMutex.Unlock()
+	f()
+}
+
+func (a *Agent) runUpgrade(up *Upgrade) {
+	for _, step := range up.Steps {
+		if step.State == StepUpgraded {
+			continue
+		}
+		a.updateUpgrade(func() {
+			step.State = StepUpgrading
+			step.Error = ""
+		})
+		if err := a.moveProcess(up, step, up.upgradedRunInfo(step)); err != nil {
+			log.Errorf("Failed to upgrade process of service %s on machine %s, upgrade paused, %v", up.SvcName, step.MachID, err)
+			a.updateUpgrade(func() {
+				step.State = StepFailed
+				step.Error = err.Error()
+				up.State = UpgradePaused
+				up.Error = fmt.Sprintf("Failed to upgrade process on machine %s", step.MachID)
+			})
+			return
+		}
+		a.updateUpgrade(func() {
+			step.State = StepUpgraded
+		})
+	}
+
+	// the processes created afterwards run the new settings as well
+	def := *up.previousDef
+	if len(up.Spec.Version) > 0 {
+		def.Version = up.Spec.Version
+	}
+	if len(up.Spec.Executor) > 0 {
+		def.Executor = up.Spec.Executor
+	}
+	if len(up.Spec.Command) > 0 {
+		def.Command = up.Spec.Command
+	}
+	if len(up.Spec.Args) > 0 {
+		def.Args = up.Spec.Args
+	}
+	if len(up.Spec.Environments) > 0 {
+		def.Environments = up.Spec.Environments
+	}
+	if err := a.UpdateService(&def); err != nil {
+		a.updateUpgrade(func() {
+			up.State = UpgradePaused
+			up.Error = fmt.Sprintf("All processes upgraded, but failed to update service, %v", err)
+		})
+		return
+	}
+	a.updateUpgrade(func() {
+		up.State = UpgradeCompleted
+		up.Finished = time.Now()
+	})
+	log.Infof("Service %s upgraded, %d processes", up.SvcName, len(up.Steps))
+}
+
+func (a *Agent) runRollback(up *Upgrade) {
+	// restore the service first, in case the upgrade has completed
+	def := *up.previousDef
+	if err := a.UpdateService(&def); err != nil {
+		a.updateUpgrade(func() {
+			up.State = UpgradePaused
+			up.Error = fmt.Sprintf("Failed to restore service, %v", err)
+		})
+		return
+	}
+	for i := len(up.Steps) - 1; i >= 0; i-- {
+		step := up.Steps[i]
+		if step.State == StepPending || step.State == StepRolledBack {
+			continue
+		}
+		a.updateUpgrade(func() {
+			step.State = StepRollingBack
+			step.Error = ""
+		})
+		previous := step.Previous
+		if err := a.moveProcess(up, step, &previous); err != nil {
+			log.Errorf("Failed to roll back process of service %s on machine %s, rollback paused, %v", up.SvcName, step.MachID, err)
+			a.updateUpgrade(func() {
+				step.State = StepFailed
+				step.Error = err.Error()
+				up.State = UpgradePaused
+				up.Error = fmt.Sprintf("Failed to roll back process on machine %s", step.MachID)
+			})
+			return
+		}
+		a.updateUpgrade(func() {
+			step.State = StepRolledBack
+		})
+	}
+	a.updateUpgrade(func() {
+		up.State = UpgradeRolledBack
+		up.Finished = time.Now()
+	})
+	log.Infof("Upgrade of service %s rolled back", up.SvcName)
+}
+
+// upgradedRunInfo returns the run info of process with the new settings
+func (up *Upgrade) upgradedRunInfo(step *UpgradeStep) *proc.ProcessRunInfo {
+	runinfo := step.Previous
+	if len(up.Spec.Version) > 0 {
+		runinfo.Version = up.Spec.Version
+	}
+	if len(up.Spec.Executor) > 0 {
+		runinfo.Executor = up.Spec.Executor
+	}
+	if len(up.Spec.Command) > 0 {
+		runinfo.Command = up.Spec.Command
+	}
+	if len(up.Spec.Args) > 0 {
+		runinfo.Args = pinProcID(up.Spec.Args, step.origProcID)
+	}
+	if len(up.Spec.Environments) > 0 {
+		runinfo.Environment = up.Spec.Environments
+	}
+	return &runinfo
+}
+
+// pinProcID replaces $PROCID in args with the procID, so that the process recreated on the same machine keeps
+// the identity of the replaced one, e.g. the member name and data dir of PD, otherwise a new member would join
+// the PD cluster on each step, with the one replaced left behind, and the quorum would be lost
+func pinProcID(args []string, procID string) []string {
+	res := make([]string, 0, len(args))
+	for _, arg := range args {
+		res = append(res, strings.Replace(arg, "$PROCID", procID, -1))
+	}
+	return res
+}
+
+// moveProcess recreates the process of step on its machine with the run info, and waits for it healthy,
+// the process is stopped and destroyed first, to release its ports and not to violate the placement rule.
+// The process is not decommissioned, the recreated one takes its place in the cluster by the same data dir
+func (a *Agent) moveProcess(up *Upgrade, step *UpgradeStep, runinfo *proc.ProcessRunInfo) error {
+	if len(step.ProcID) > 0 {
+		if err := a.StopProcess(step.ProcID); err != nil {
+			return err
+		}
+		if err := a.WaitProcessStopped(step.ProcID, processStopTimeout); err != nil {
+			return err
+		}
+		if err := a.DestroyProcess(step.ProcID); err != nil {
+			return err
+		}
+		a.updateUpgrade(func() {
+			step.ProcID = ""
+		})
+	}
+	procID, err := a.StartNewProcess(step.MachID, up.SvcName, runinfo)
+	if err != nil {
+		return err
+	}
+	a.updateUpgrade(func() {
+		step.ProcID = procID
+	})
+	if step.Stopped {
+		return a.StopProcess(procID)
+	}
+	return a.WaitProcessHealthy(procID, up.Spec.HealthTimeout)
+}
+
+// WaitProcessHealthy waits until the process is started and healthy, or just started if the service can't be probed,
+// fails at once if the process is crash-looping
+func (a *Agent) WaitProcessHealthy(procID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := a.Reg.Process(procID)
+		if err != nil {
+			log.Errorf("List specified process failed, %s, %v", procID, err)
+			return err
+		}
+		if status.RestartStatus.CrashLoop {
+			e := fmt.Sprintf("The process is crash-looping, procID: %s, last exit code: %d", procID, status.RestartStatus.LastExitCode)
+			log.Error(e)
+			return errors.New(e)
+		}
+		probed := false
+		if svc, ok := service.Registered()[status.SvcName]; ok {
+			probed = svc.Status().HealthProbe != nil
+		}
+		if status.IsAlive && status.CurrentState == proc.StateStarted && (!probed || status.Health == proc.HealthHealthy) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			e := fmt.Sprintf("Timed out waiting for process healthy after %v, procID: %s, state: %s, health: %s",
+				timeout, procID, status.CurrentState, status.Health)
+			log.Error(e)
+			return errors.New(e)
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
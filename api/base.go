@@ -2,9 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/astaxie/beego"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
 	"github.com/qiuyesuifeng/tidb-demo/schema"
 )
 
@@ -45,3 +48,52 @@ func transformEnvironmentsToMap(envs []schema.Environment) map[string]string {
 	}
 	return res
 }
+
+func transformLogRotation(lr schema.LogRotation) (proc.LogRotation, error) {
+	res := proc.LogRotation{
+		MaxSize:  int64(lr.MaxSizeMB) * 1024 * 1024,
+		MaxFiles: int(lr.MaxFiles),
+		Compress: lr.Compress,
+		MaxRuns:  int(lr.MaxRuns),
+	}
+	if lr.MaxSizeMB < 0 || lr.MaxFiles < 0 || lr.MaxRuns < 0 {
+		return res, errors.New("Request parameters of 'logRotation' should not be negative")
+	}
+	if len(lr.MaxAge) > 0 {
+		maxAge, err := time.ParseDuration(lr.MaxAge)
+		if err != nil || maxAge < 0 {
+			return res, errors.New(fmt.Sprintf("Illegal request parameter 'logRotation.maxAge': %s", lr.MaxAge))
+		}
+		res.MaxAge = maxAge
+	}
+	return res, nil
+}
+
+func buildLogRotationModel(lr proc.LogRotation) schema.LogRotation {
+	res := schema.LogRotation{
+		MaxSizeMB: int32(lr.MaxSize / 1024 / 1024),
+		MaxFiles:  int32(lr.MaxFiles),
+		Compress:  lr.Compress,
+		MaxRuns:   int32(lr.MaxRuns),
+	}
+	if lr.MaxAge > 0 {
+		res.MaxAge = lr.MaxAge.String()
+	}
+	return res
+}
+
+func transformResourceLimits(rl schema.ResourceLimits) proc.ResourceLimits {
+	return proc.ResourceLimits{
+		CPUQuota:    rl.CPUQuota,
+		MemoryLimit: int64(rl.MemoryLimitMB) * 1024 * 1024,
+		IOWeight:    int(rl.IOWeight),
+	}
+}
+
+func buildResourceLimitsModel(rl proc.ResourceLimits) schema.ResourceLimits {
+	return schema.ResourceLimits{
+		CPUQuota:      rl.CPUQuota,
+		MemoryLimitMB: int32(rl.MemoryLimit / 1024 / 1024),
+		IOWeight:      int32(rl.IOWeight),
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"github.com/qiuyesuifeng/tidb-demo/master"
+)
+
+type ClusterController struct {
+	baseController
+}
+
+// StartCluster starts all processes in the order of service dependencies, responds the procIDs started
+func (c *ClusterController) StartCluster() {
+	started, err := master.Agent.StartCluster()
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = started
+	c.ServeJSON()
+}
+
+// StopCluster stops all processes in the reverse order of service dependencies, responds the procIDs stopped
+func (c *ClusterController) StopCluster() {
+	stopped, err := master.Agent.StopCluster()
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = stopped
+	c.ServeJSON()
+}
@@ -2,7 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
 
+	"github.com/qiuyesuifeng/tidb-demo/agent"
 	"github.com/qiuyesuifeng/tidb-demo/master"
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
 	"github.com/qiuyesuifeng/tidb-demo/proc"
@@ -32,18 +38,57 @@ func (c *ProcessController) StartNewProcess() {
 	if err != nil {
 		c.ServeError(500, err.Error())
 	}
-	if len(body.SvcName) == 0 || len(body.MachID) == 0 {
-		c.ServeError(500, "Request parameters 'svcName' or 'MachID' is necessary")
+	if len(body.SvcName) == 0 {
+		c.ServeError(500, "Request parameter 'svcName' is necessary")
+	}
+	// the restart policy of service is taken if not given
+	var policy proc.RestartPolicy
+	if len(body.RestartPolicy) > 0 {
+		if policy, err = proc.ParseRestartPolicy(body.RestartPolicy); err != nil {
+			c.ServeError(500, err.Error())
+		}
+	}
+	if body.MaxRetries < 0 {
+		c.ServeError(500, "Request parameter 'maxRetries' should not be negative")
+	}
+	logRotation, err := transformLogRotation(body.LogRotation)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	limits := transformResourceLimits(body.Limits)
+	if err := limits.Validate(); err != nil {
+		c.ServeError(500, err.Error())
 	}
 	runinfo := &proc.ProcessRunInfo{
+		Version:     body.Version,
 		Executor:    body.Executor,
 		Command:     body.Command,
 		Args:        body.Args,
 		Environment: transformEnvironmentsToMap(body.Environments),
+		Restart: proc.RestartSpec{
+			Policy:     policy,
+			MaxRetries: int(body.MaxRetries),
+		},
+		LogRotation: logRotation,
+		Limits:      limits,
+	}
+	// let the scheduler choose a machine if not specified, within the region and IDC if specified
+	if len(body.MachID) == 0 {
+		placement, err := master.Agent.SchedulePlacement(body.SvcName, agent.PlacementConstraints{
+			Region: body.HostMeta.Region,
+			IDC:    body.HostMeta.Datacenter,
+		})
+		if err != nil {
+			c.ServeError(500, err.Error())
+		}
+		body.MachID = placement.MachID
+		body.Placement = placement.Reason
 	}
-	if err := master.Agent.StartNewProcess(body.MachID, body.SvcName, runinfo); err != nil {
+	procID, err := master.Agent.StartNewProcess(body.MachID, body.SvcName, runinfo)
+	if err != nil {
 		c.ServeError(500, err.Error())
 	}
+	body.ProcID = procID
 	// TODO: return the last status of process
 	c.Data["json"] = body
 	c.ServeJSON()
@@ -96,12 +141,80 @@ func (c *ProcessController) FindProcess() {
 	c.ServeJSON()
 }
 
+func (c *ProcessController) FindProcessRuns() {
+	procID := c.Ctx.Input.Param(":procID")
+	if len(procID) == 0 {
+		c.Abort("400")
+	}
+	records, err := master.Agent.ListProcessRuns(procID)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	runs := []*schema.ProcessRun{}
+	for _, r := range records {
+		runs = append(runs, &schema.ProcessRun{
+			RunID:       int32(r.ID),
+			PID:         int32(r.PID),
+			Commandline: r.Commandline,
+			StartedTime: r.Started,
+			StoppedTime: r.Stopped,
+			ExitCode:    int32(r.ExitCode),
+			Signal:      r.Signal,
+			StopStage:   r.StopStage.String(),
+			Error:       r.Error,
+		})
+	}
+	c.Data["json"] = runs
+	c.ServeJSON()
+}
+
+// ProcessLogs proxies the request to the minion on which the process is running,
+// the query parameters are passed through, and the followed logs are flushed as soon as received
+func (c *ProcessController) ProcessLogs() {
+	procID := c.Ctx.Input.Param(":procID")
+	if len(procID) == 0 {
+		c.Abort("400")
+	}
+	logURL, err := master.Agent.ProcessLogURL(procID)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	target, err := url.Parse(logURL)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = target.Path
+			req.Host = target.Host
+		},
+		FlushInterval: 100 * time.Millisecond,
+	}
+	c.EnableRender = false
+	proxy.ServeHTTP(c.Ctx.ResponseWriter, c.Ctx.Request)
+}
+
+// DestroyProcess kills the process and removes it at once, which loses the data of TiKV store or leaves a dead member
+// in PD cluster unless it's decommissioned, so destroying a TiKV or PD process is refused unless forced
 func (c *ProcessController) DestroyProcess() {
 	procID := c.Ctx.Input.Param(":procID")
 	if len(procID) == 0 {
 		c.Abort("400")
 	}
-	err := master.Agent.DestroyProcess(procID)
+	force, err := c.GetBool("force", false)
+	if err != nil {
+		c.ServeError(500, "Illegal request parameter 'force'")
+	}
+	s, err := master.Agent.ListProcess(procID)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	if agent.NeedsDecommission(s.SvcName) && !force {
+		c.ServeError(500, fmt.Sprintf("%s process should be decommissioned to leave the cluster first, or destroyed by 'force=true'", s.SvcName))
+	}
+	err = master.Agent.DestroyProcess(procID)
 	if err != nil {
 		c.ServeError(500, err.Error())
 	}
@@ -111,6 +224,111 @@ func (c *ProcessController) DestroyProcess() {
 	c.ServeJSON()
 }
 
+// UpdateProcess replaces the run spec of process by PUT, or merges the non-empty fields into it by PATCH,
+// the update is rejected if 'generation' is given and the process has been updated since then
+func (c *ProcessController) UpdateProcess() {
+	procID := c.Ctx.Input.Param(":procID")
+	if len(procID) == 0 {
+		c.Abort("400")
+	}
+	var body schema.Process
+	err := json.Unmarshal(c.Ctx.Input.RequestBody, &body)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	// the fields given in request, to tell the zero values apart from the absent ones
+	var given map[string]json.RawMessage
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &given); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	if body.Generation < 0 {
+		c.ServeError(500, "Request parameter 'generation' should not be negative")
+	}
+	if body.MaxRetries < 0 {
+		c.ServeError(500, "Request parameter 'maxRetries' should not be negative")
+	}
+	logRotation, err := transformLogRotation(body.LogRotation)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	limits := transformResourceLimits(body.Limits)
+	if err := limits.Validate(); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	runinfo := &proc.ProcessRunInfo{
+		Version:     body.Version,
+		Executor:    body.Executor,
+		Command:     body.Command,
+		Args:        body.Args,
+		Environment: transformEnvironmentsToMap(body.Environments),
+		LogRotation: logRotation,
+		Limits:      limits,
+	}
+	// the restart spec is kept or reset to the one of service if not given
+	var restart agent.RestartUpdate
+	if _, ok := given["restartPolicy"]; ok {
+		policy, err := proc.ParseRestartPolicy(body.RestartPolicy)
+		if err != nil {
+			c.ServeError(500, err.Error())
+		}
+		restart.Policy = &policy
+	}
+	if _, ok := given["maxRetries"]; ok {
+		maxRetries := int(body.MaxRetries)
+		restart.MaxRetries = &maxRetries
+	}
+	merge := c.Ctx.Input.Method() == "PATCH"
+	s, err := master.Agent.UpdateProcess(procID, body.Generation, runinfo, restart, merge)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildProcessModel(s)
+	c.ServeJSON()
+}
+
+// StartDecommission removes the process from the cluster of its service in background, then stops and destroys it,
+// responds the decommission just started
+func (c *ProcessController) StartDecommission() {
+	procID := c.Ctx.Input.Param(":procID")
+	if len(procID) == 0 {
+		c.Abort("400")
+	}
+	var body schema.DecommissionSpec
+	if len(c.Ctx.Input.RequestBody) > 0 {
+		if err := json.Unmarshal(c.Ctx.Input.RequestBody, &body); err != nil {
+			c.ServeError(500, err.Error())
+		}
+	}
+	var timeout time.Duration
+	if len(body.Timeout) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(body.Timeout)
+		if err != nil || timeout < 0 {
+			c.ServeError(500, fmt.Sprintf("Illegal request parameter 'timeout': %s", body.Timeout))
+		}
+	}
+	d, err := master.Agent.StartDecommission(procID, timeout)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildDecommissionModel(d)
+	c.ServeJSON()
+}
+
+// Decommission reports the progress of the last decommission of process
+func (c *ProcessController) Decommission() {
+	procID := c.Ctx.Input.Param(":procID")
+	if len(procID) == 0 {
+		c.Abort("400")
+	}
+	d, err := master.Agent.Decommission(procID)
+	if err != nil {
+		c.Abort("404")
+	}
+	c.Data["json"] = buildDecommissionModel(d)
+	c.ServeJSON()
+}
+
 func (c *ProcessController) StartProcess() {
 	procID := c.Ctx.Input.Param(":procID")
 	if len(procID) == 0 {
@@ -145,19 +363,35 @@ func (c *ProcessController) StopProcess() {
 
 func buildProcessModel(s *proc.ProcessStatus) *schema.Process {
 	p := &schema.Process{
-		ProcID:       s.ProcID,
-		SvcName:      s.SvcName,
-		MachID:       s.MachID,
-		DesiredState: s.DesiredState.String(),
-		CurrentState: s.CurrentState.String(),
-		IsAlive:      s.IsAlive,
-		Endpoints:    utils.EndpointsToStrings(s.RunInfo.Endpoints),
-		Executor:     s.RunInfo.Executor,
-		Command:      s.RunInfo.Command,
-		Args:         s.RunInfo.Args,
-		Environments: transformMapToEnvironments(s.RunInfo.Environment),
-		PublicIP:     s.RunInfo.HostIP,
-		HostName:     s.RunInfo.HostName,
+		ProcID:        s.ProcID,
+		SvcName:       s.SvcName,
+		MachID:        s.MachID,
+		DesiredState:  s.DesiredState.String(),
+		CurrentState:  s.CurrentState.String(),
+		IsAlive:       s.IsAlive,
+		Health:        proc.HealthUnknown.String(),
+		Restarts:      int32(s.RestartStatus.Restarts),
+		LastExitCode:  int32(s.RestartStatus.LastExitCode),
+		BackingOff:    s.RestartStatus.IsBackingOff(),
+		CrashLoop:     s.RestartStatus.CrashLoop,
+		Endpoints:     utils.EndpointsToStrings(s.RunInfo.Endpoints),
+		Version:       s.RunInfo.Version,
+		Generation:    s.RunInfo.Generation,
+		Executor:      s.RunInfo.Executor,
+		Command:       s.RunInfo.Command,
+		Args:          s.RunInfo.Args,
+		Environments:  transformMapToEnvironments(s.RunInfo.Environment),
+		RestartPolicy: s.RunInfo.Restart.Policy.String(),
+		MaxRetries:    int32(s.RunInfo.Restart.MaxRetries),
+		LogRotation:   buildLogRotationModel(s.RunInfo.LogRotation),
+		Limits:        buildResourceLimitsModel(s.RunInfo.Limits),
+		Usage: schema.ResourceUsage{
+			CPUSeconds:  s.ResourceUsage.CPUTime.Seconds(),
+			MemoryUsage: s.ResourceUsage.MemoryUsage,
+			OOMKills:    int32(s.ResourceUsage.OOMKills),
+		},
+		PublicIP: s.RunInfo.HostIP,
+		HostName: s.RunInfo.HostName,
 		HostMeta: schema.HostMeta{
 			Region:     s.RunInfo.HostRegion,
 			Datacenter: s.RunInfo.HostIDC,
@@ -165,5 +399,25 @@ func buildProcessModel(s *proc.ProcessStatus) *schema.Process {
 		Port:     0,
 		Protocol: "",
 	}
+	// the health published before the process died is kept until it expires, which is stale
+	if s.IsAlive && len(s.Health) > 0 {
+		p.Health = s.Health.String()
+	}
 	return p
 }
+
+func buildDecommissionModel(d *agent.Decommission) *schema.Decommission {
+	return &schema.Decommission{
+		ProcID:       d.ProcID,
+		SvcName:      d.SvcName,
+		MachID:       d.MachID,
+		Timeout:      d.Timeout.String(),
+		State:        string(d.State),
+		StoreID:      d.StoreID,
+		StoreState:   d.StoreState,
+		RegionCount:  int32(d.RegionCount),
+		Error:        d.Error,
+		StartedTime:  d.Started,
+		FinishedTime: d.Finished,
+	}
+}
@@ -11,15 +11,33 @@ func beegoRouter() error {
 		beego.NSRouter("/hosts/:machID", &HostController{}, "get:FindHost"),
 		beego.NSRouter("/hosts/:machID/meta", &HostController{}, "put:SetHostMetaInfo"),
 		beego.NSRouter("/services", &ServiceController{}, "get:AllServices"),
+		beego.NSRouter("/services", &ServiceController{}, "post:CreateService"),
 		beego.NSRouter("/services/:svcName", &ServiceController{}, "get:Service"),
+		beego.NSRouter("/services/:svcName", &ServiceController{}, "put:UpdateService"),
+		beego.NSRouter("/services/:svcName", &ServiceController{}, "delete:DeleteService"),
+		beego.NSRouter("/services/:svcName/violations", &ServiceController{}, "get:PlacementViolations"),
+		beego.NSRouter("/services/:svcName/upgrade", &UpgradeController{}, "get:Upgrade"),
+		beego.NSRouter("/services/:svcName/upgrade", &UpgradeController{}, "post:StartUpgrade"),
+		beego.NSRouter("/services/:svcName/upgrade/resume", &UpgradeController{}, "get:ResumeUpgrade"),
+		beego.NSRouter("/services/:svcName/upgrade/rollback", &UpgradeController{}, "get:RollbackUpgrade"),
+		beego.NSRouter("/services/:svcName/scale", &ScaleController{}, "get:Scale"),
+		beego.NSRouter("/services/:svcName/scale", &ScaleController{}, "post:StartScale"),
 		beego.NSRouter("/processes", &ProcessController{}, "get:FindAllProcesses"),
 		beego.NSRouter("/processes", &ProcessController{}, "post:StartNewProcess"),
 		beego.NSRouter("/processes/findByHost", &ProcessController{}, "get:FindByHost"),
 		beego.NSRouter("/processes/findByService", &ProcessController{}, "get:FindByService"),
 		beego.NSRouter("/processes/:procID", &ProcessController{}, "get:FindProcess"),
 		beego.NSRouter("/processes/:procID", &ProcessController{}, "delete:DestroyProcess"),
+		beego.NSRouter("/processes/:procID", &ProcessController{}, "put,patch:UpdateProcess"),
 		beego.NSRouter("/processes/:procID/start", &ProcessController{}, "get:StartProcess"),
 		beego.NSRouter("/processes/:procID/stop", &ProcessController{}, "get:StopProcess"),
+		beego.NSRouter("/processes/:procID/runs", &ProcessController{}, "get:FindProcessRuns"),
+		beego.NSRouter("/processes/:procID/logs", &ProcessController{}, "get:ProcessLogs"),
+		beego.NSRouter("/processes/:procID/decommission", &ProcessController{}, "get:Decommission"),
+		beego.NSRouter("/processes/:procID/decommission", &ProcessController{}, "post:StartDecommission"),
+		beego.NSRouter("/cluster/start", &ClusterController{}, "get:StartCluster"),
+		beego.NSRouter("/cluster/stop", &ClusterController{}, "get:StopCluster"),
+		beego.NSRouter("/topology", &TopologyController{}, "post:ApplyTopology"),
 		beego.NSRouter("/monitor/real/tidb_perf", &MonitorController{}, "get:TiDBPerformanceMetrics"),
 		beego.NSRouter("/monitor/real/tikv_storage", &MonitorController{}, "get:TiKVStorageMetrics"),
 	)
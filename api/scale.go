@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/qiuyesuifeng/tidb-demo/agent"
+	"github.com/qiuyesuifeng/tidb-demo/master"
+	"github.com/qiuyesuifeng/tidb-demo/schema"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+type ScaleController struct {
+	baseController
+}
+
+// StartScale starts to scale the service to the replicas in background, responds the scale just started
+func (c *ScaleController) StartScale() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	if _, ok := service.Registered()[svcName]; !ok {
+		c.Abort("404")
+	}
+	var body schema.ScaleSpec
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &body); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	if body.Replicas < 0 {
+		c.ServeError(500, "Request parameter 'replicas' should not be negative")
+	}
+	var timeout time.Duration
+	if len(body.HealthTimeout) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(body.HealthTimeout)
+		if err != nil || timeout < 0 {
+			c.ServeError(500, fmt.Sprintf("Illegal request parameter 'healthTimeout': %s", body.HealthTimeout))
+		}
+	}
+	sc, err := master.Agent.StartScale(svcName, int(body.Replicas), timeout)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildScaleModel(sc)
+	c.ServeJSON()
+}
+
+// Scale reports the progress of the last scale of service
+func (c *ScaleController) Scale() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	sc, err := master.Agent.Scale(svcName)
+	if err != nil {
+		c.Abort("404")
+	}
+	c.Data["json"] = buildScaleModel(sc)
+	c.ServeJSON()
+}
+
+func buildScaleModel(sc *agent.Scale) *schema.Scale {
+	res := &schema.Scale{
+		SvcName:       sc.SvcName,
+		Replicas:      int32(sc.Replicas),
+		Previous:      int32(sc.Previous),
+		HealthTimeout: sc.HealthTimeout.String(),
+		State:         string(sc.State),
+		Error:         sc.Error,
+		StartedTime:   sc.Started,
+		FinishedTime:  sc.Finished,
+		Steps:         []schema.ScaleStep{},
+	}
+	for _, step := range sc.Steps {
+		res.Steps = append(res.Steps, schema.ScaleStep{
+			Action: string(step.Action),
+			MachID: step.MachID,
+			ProcID: step.ProcID,
+			Reason: step.Reason,
+			State:  string(step.State),
+			Error:  step.Error,
+		})
+	}
+	return res
+}
@@ -1,7 +1,16 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/qiuyesuifeng/tidb-demo/master"
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
 	"github.com/qiuyesuifeng/tidb-demo/schema"
 	"github.com/qiuyesuifeng/tidb-demo/service"
 )
@@ -12,18 +21,8 @@ type ServiceController struct {
 
 func (c *ServiceController) AllServices() {
 	res := []*schema.Service{}
-	for _, svc := range service.Registered {
-		status := svc.Status()
-		s := &schema.Service{
-			SvcName:      status.SvcName,
-			Version:      status.Version,
-			Executor:     status.Executor,
-			Command:      status.Command,
-			Args:         status.Args,
-			Environments: transformMapToEnvironments(status.Environments),
-			Endpoints:    utils.EndpointsToStrings(status.Endpoints),
-		}
-		res = append(res, s)
+	for _, svc := range service.Registered() {
+		res = append(res, buildServiceModel(svc))
 	}
 	c.Data["json"] = res
 	c.ServeJSON()
@@ -34,19 +33,263 @@ func (c *ServiceController) Service() {
 	if len(svcName) == 0 {
 		c.Abort("400")
 	}
-	if svc, ok := service.Registered[svcName]; ok {
-		status := svc.Status()
-		c.Data["json"] = &schema.Service{
-			SvcName:      status.SvcName,
-			Version:      status.Version,
-			Executor:     status.Executor,
-			Command:      status.Command,
-			Args:         status.Args,
-			Environments: transformMapToEnvironments(status.Environments),
-			Endpoints:    utils.EndpointsToStrings(status.Endpoints),
-		}
+	if svc, ok := service.Registered()[svcName]; ok {
+		c.Data["json"] = buildServiceModel(svc)
 		c.ServeJSON()
 	} else {
 		c.Abort("404")
 	}
 }
+
+func (c *ServiceController) CreateService() {
+	var body schema.Service
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &body); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	def, err := transformServiceDefinition(body)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	if err := master.Agent.CreateService(def); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildServiceModel(service.NewService(def))
+	c.ServeJSON()
+}
+
+func (c *ServiceController) UpdateService() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	if _, ok := service.Registered()[svcName]; !ok {
+		c.Abort("404")
+	}
+	var body schema.Service
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &body); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	if len(body.SvcName) == 0 {
+		body.SvcName = svcName
+	} else if body.SvcName != svcName {
+		c.ServeError(500, "Request parameter 'svcName' should not be changed")
+	}
+	def, err := transformServiceDefinition(body)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	if err := master.Agent.UpdateService(def); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildServiceModel(service.NewService(def))
+	c.ServeJSON()
+}
+
+func (c *ServiceController) DeleteService() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	svc, ok := service.Registered()[svcName]
+	if !ok {
+		c.Abort("404")
+	}
+	if err := master.Agent.DeleteService(svcName); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildServiceModel(svc)
+	c.ServeJSON()
+}
+
+func (c *ServiceController) PlacementViolations() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	if _, ok := service.Registered()[svcName]; !ok {
+		c.Abort("404")
+	}
+	violations, err := master.Agent.PlacementViolations(svcName)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	res := []*schema.PlacementViolation{}
+	for _, v := range violations {
+		res = append(res, &schema.PlacementViolation{
+			ProcID:     v.ProcID,
+			MachID:     v.MachID,
+			SvcName:    v.SvcName,
+			Violations: v.Violations,
+		})
+	}
+	c.Data["json"] = res
+	c.ServeJSON()
+}
+
+func buildPlacementRuleModel(rule *service.PlacementRule) schema.PlacementRule {
+	res := schema.PlacementRule{
+		RequiredLabels: map[string]string{},
+	}
+	if rule == nil {
+		return res
+	}
+	res.MaxPerHost = int32(rule.MaxPerHost)
+	res.MaxPerIDC = int32(rule.MaxPerIDC)
+	res.MaxPerRegion = int32(rule.MaxPerRegion)
+	for k, v := range rule.RequiredLabels {
+		res.RequiredLabels[k] = v
+	}
+	return res
+}
+
+func buildServiceModel(svc service.Service) *schema.Service {
+	status := svc.Status()
+	def := svc.Definition()
+	res := &schema.Service{
+		SvcName:             status.SvcName,
+		Version:             status.Version,
+		Executor:            status.Executor,
+		Command:             status.Command,
+		Args:                status.Args,
+		Environments:        transformMapToEnvironments(status.Environments),
+		Endpoints:           utils.EndpointsToStrings(status.Endpoints),
+		EndpointDefinitions: []schema.EndpointDefinition{},
+		ConfigFlag:          def.ConfigFlag,
+		LogRotation:         buildLogRotationModel(status.LogRotation),
+		Stop: schema.StopSpec{
+			Signal: int32(status.Stop.Signal),
+		},
+		RestartPolicy: status.Restart.Policy.String(),
+		MaxRetries:    int32(status.Restart.MaxRetries),
+		Placement:     buildPlacementRuleModel(status.Placement),
+		Dependencies:  status.Dependencies,
+	}
+	if status.Stop.GracePeriod > 0 {
+		res.Stop.GracePeriod = status.Stop.GracePeriod.String()
+	}
+	names := make([]string, 0, len(def.Endpoints))
+	for name := range def.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ed := def.Endpoints[name]
+		res.EndpointDefinitions = append(res.EndpointDefinitions, schema.EndpointDefinition{
+			Name:      name,
+			Protocol:  ed.Protocol.String(),
+			Port:      ed.Port.Value(),
+			Flag:      ed.Flag,
+			ConfigKey: ed.ConfigKey,
+			Format:    string(ed.Format),
+			WithIP:    ed.WithIP,
+		})
+	}
+	if hp := status.HealthProbe; hp != nil {
+		res.HealthProbe = &schema.HealthProbe{
+			Type:             string(hp.Type),
+			Endpoint:         hp.Endpoint,
+			Path:             hp.Path,
+			Command:          hp.Command,
+			InitialDelay:     hp.InitialDelay.String(),
+			Interval:         hp.Interval.String(),
+			Timeout:          hp.Timeout.String(),
+			FailureThreshold: int32(hp.FailureThreshold),
+		}
+	}
+	return res
+}
+
+func transformServiceDefinition(s schema.Service) (*service.Definition, error) {
+	logRotation, err := transformLogRotation(s.LogRotation)
+	if err != nil {
+		return nil, err
+	}
+	def := &service.Definition{
+		SvcName:      s.SvcName,
+		Version:      s.Version,
+		Executor:     s.Executor,
+		Command:      s.Command,
+		Args:         s.Args,
+		Environments: transformEnvironmentsToMap(s.Environments),
+		Endpoints:    make(map[string]service.EndpointDefinition),
+		ConfigFlag:   s.ConfigFlag,
+		LogRotation:  logRotation,
+		Stop: proc.StopSpec{
+			Signal: syscall.Signal(s.Stop.Signal),
+		},
+		Dependencies: s.Dependencies,
+	}
+	if s.Stop.Signal < 0 {
+		return nil, errors.New("Request parameter 'stop.signal' should not be negative")
+	}
+	if def.Restart.Policy, err = proc.ParseRestartPolicy(s.RestartPolicy); err != nil {
+		return nil, err
+	}
+	if s.MaxRetries < 0 {
+		return nil, errors.New("Request parameter 'maxRetries' should not be negative")
+	}
+	def.Restart.MaxRetries = int(s.MaxRetries)
+	if len(s.Stop.GracePeriod) > 0 {
+		if def.Stop.GracePeriod, err = time.ParseDuration(s.Stop.GracePeriod); err != nil || def.Stop.GracePeriod < 0 {
+			return nil, errors.New(fmt.Sprintf("Illegal request parameter 'stop.gracePeriod': %s", s.Stop.GracePeriod))
+		}
+	}
+	for _, ed := range s.EndpointDefinitions {
+		if _, ok := def.Endpoints[ed.Name]; ok {
+			return nil, errors.New(fmt.Sprintf("Endpoint defined more than once: %s", ed.Name))
+		}
+		def.Endpoints[ed.Name] = service.EndpointDefinition{
+			Protocol:  utils.Protocol(ed.Protocol),
+			Port:      utils.Port(ed.Port),
+			Flag:      ed.Flag,
+			ConfigKey: ed.ConfigKey,
+			Format:    service.AddrFormat(ed.Format),
+			WithIP:    ed.WithIP,
+		}
+	}
+	if hp := s.HealthProbe; hp != nil {
+		probe := &service.HealthProbe{
+			Type:             service.ProbeType(hp.Type),
+			Endpoint:         hp.Endpoint,
+			Path:             hp.Path,
+			Command:          hp.Command,
+			FailureThreshold: int(hp.FailureThreshold),
+		}
+		switch probe.Type {
+		case service.ProbeTCP, service.ProbeHTTP, service.ProbeExec:
+		default:
+			return nil, errors.New(fmt.Sprintf("Illegal request parameter 'healthProbe.type': %s", hp.Type))
+		}
+		durations := []struct {
+			name  string
+			value string
+			res   *time.Duration
+		}{
+			{"initialDelay", hp.InitialDelay, &probe.InitialDelay},
+			{"interval", hp.Interval, &probe.Interval},
+			{"timeout", hp.Timeout, &probe.Timeout},
+		}
+		for _, d := range durations {
+			if len(d.value) == 0 {
+				continue
+			}
+			if *d.res, err = time.ParseDuration(d.value); err != nil || *d.res < 0 {
+				return nil, errors.New(fmt.Sprintf("Illegal request parameter 'healthProbe.%s': %s", d.name, d.value))
+			}
+		}
+		def.HealthProbe = probe
+	}
+	rule := s.Placement
+	if rule.MaxPerHost > 0 || rule.MaxPerIDC > 0 || rule.MaxPerRegion > 0 || len(rule.RequiredLabels) > 0 {
+		def.Placement = &service.PlacementRule{
+			MaxPerHost:     int(rule.MaxPerHost),
+			MaxPerIDC:      int(rule.MaxPerIDC),
+			MaxPerRegion:   int(rule.MaxPerRegion),
+			RequiredLabels: rule.RequiredLabels,
+		}
+	}
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"github.com/qiuyesuifeng/tidb-demo/master"
+	"github.com/qiuyesuifeng/tidb-demo/schema"
+	"github.com/qiuyesuifeng/tidb-demo/topology"
+)
+
+type TopologyController struct {
+	baseController
+}
+
+// ApplyTopology diffs the topology document in TOML against the processes in registry,
+// and converges them unless in dry-run mode
+func (c *TopologyController) ApplyTopology() {
+	topo, err := topology.Parse(c.Ctx.Input.RequestBody)
+	if err != nil {
+		c.ServeError(400, err.Error())
+	}
+	dryRun, err := c.GetBool("dryRun", false)
+	if err != nil {
+		c.ServeError(400, err.Error())
+	}
+	actions, err := master.Agent.ApplyTopology(topo, dryRun)
+	if actions == nil && err != nil {
+		c.ServeError(500, err.Error())
+	}
+	plan := &schema.TopologyPlan{
+		DryRun:  dryRun,
+		Applied: !dryRun && err == nil,
+		Actions: []*schema.TopologyAction{},
+	}
+	for _, act := range actions {
+		plan.Actions = append(plan.Actions, &schema.TopologyAction{
+			Type:    string(act.Type),
+			SvcName: act.SvcName,
+			ProcID:  act.ProcID,
+			MachID:  act.MachID,
+			Reason:  act.Reason,
+			Error:   act.Error,
+		})
+	}
+	if err != nil {
+		// the actions applied before the failed one are reported along with the error
+		c.Ctx.Output.SetStatus(500)
+	}
+	c.Data["json"] = plan
+	c.ServeJSON()
+}
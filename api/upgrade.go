@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qiuyesuifeng/tidb-demo/agent"
+	"github.com/qiuyesuifeng/tidb-demo/master"
+	"github.com/qiuyesuifeng/tidb-demo/schema"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+type UpgradeController struct {
+	baseController
+}
+
+// StartUpgrade starts a rolling upgrade of service in background, responds the upgrade just started
+func (c *UpgradeController) StartUpgrade() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	if _, ok := service.Registered()[svcName]; !ok {
+		c.Abort("404")
+	}
+	var body schema.UpgradeSpec
+	if err := json.Unmarshal(c.Ctx.Input.RequestBody, &body); err != nil {
+		c.ServeError(500, err.Error())
+	}
+	spec, err := transformUpgradeSpec(body)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	up, err := master.Agent.StartUpgrade(svcName, spec)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildUpgradeModel(up)
+	c.ServeJSON()
+}
+
+func (c *UpgradeController) Upgrade() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	up, err := master.Agent.Upgrade(svcName)
+	if err != nil {
+		c.Abort("404")
+	}
+	c.Data["json"] = buildUpgradeModel(up)
+	c.ServeJSON()
+}
+
+// ResumeUpgrade retries the failed step of the paused upgrade and goes on
+func (c *UpgradeController) ResumeUpgrade() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	up, err := master.Agent.ResumeUpgrade(svcName)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildUpgradeModel(up)
+	c.ServeJSON()
+}
+
+// RollbackUpgrade moves the upgraded processes back to their previous run info
+func (c *UpgradeController) RollbackUpgrade() {
+	svcName := c.Ctx.Input.Param(":svcName")
+	if len(svcName) == 0 {
+		c.Abort("400")
+	}
+	up, err := master.Agent.RollbackUpgrade(svcName)
+	if err != nil {
+		c.ServeError(500, err.Error())
+	}
+	c.Data["json"] = buildUpgradeModel(up)
+	c.ServeJSON()
+}
+
+func transformUpgradeSpec(s schema.UpgradeSpec) (agent.UpgradeSpec, error) {
+	res := agent.UpgradeSpec{
+		Version:      s.Version,
+		Executor:     s.Executor,
+		Command:      s.Command,
+		Args:         s.Args,
+		Environments: transformEnvironmentsToMap(s.Environments),
+	}
+	if len(s.HealthTimeout) > 0 {
+		timeout, err := time.ParseDuration(s.HealthTimeout)
+		if err != nil || timeout < 0 {
+			return res, errors.New(fmt.Sprintf("Illegal request parameter 'healthTimeout': %s", s.HealthTimeout))
+		}
+		res.HealthTimeout = timeout
+	}
+	return res, nil
+}
+
+func buildUpgradeModel(up *agent.Upgrade) *schema.Upgrade {
+	res := &schema.Upgrade{
+		SvcName: up.SvcName,
+		Spec: schema.UpgradeSpec{
+			Version:       up.Spec.Version,
+			Executor:      up.Spec.Executor,
+			Command:       up.Spec.Command,
+			Args:          up.Spec.Args,
+			Environments:  transformMapToEnvironments(up.Spec.Environments),
+			HealthTimeout: up.Spec.HealthTimeout.String(),
+		},
+		State:        string(up.State),
+		Error:        up.Error,
+		StartedTime:  up.Started,
+		FinishedTime: up.Finished,
+		Steps:        []schema.UpgradeStep{},
+	}
+	for _, step := range up.Steps {
+		res.Steps = append(res.Steps, schema.UpgradeStep{
+			MachID:          step.MachID,
+			ProcID:          step.ProcID,
+			State:           string(step.State),
+			Error:           step.Error,
+			PreviousVersion: step.Previous.Version,
+			PreviousCommand: step.Previous.Command,
+			PreviousArgs:    step.Previous.Args,
+		})
+	}
+	return res
+}
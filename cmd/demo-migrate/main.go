@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/registry"
+)
+
+const defaultKeyPrefix = "/_pingcap.com/tidemo"
+
+// tidemo-migrate copies the tidemo registry stored by the etcd v2 API into the etcd v3 keyspace,
+// run it once before switching master and minions to '-registry etcdv3'
+func main() {
+	fromServers := flag.String("from", "http://127.0.0.1:2379", "List of etcd endpoints to read the v2 tree from")
+	toServers := flag.String("to", "", "List of etcd endpoints to write the v3 keys to, default the same as 'from'")
+	etcdKeyPrefix := flag.String("etcd-prefix", defaultKeyPrefix, "Namespace for tidemo registry in etcd")
+	etcdRequestTimeout := flag.Int("etcd-timeout", 2500, "Amount of time in milliseconds to allow a single etcd request before considering it failed.")
+	force := flag.Bool("force", false, "Overwrite the v3 keys even if the v3 registry is already bootstrapped")
+	logLevel := flag.String("log-level", "info", "Log level: info, debug, warn, error, fatal")
+	flag.Parse()
+
+	log.SetLevelByString(*logLevel)
+	if len(*toServers) == 0 {
+		toServers = fromServers
+	}
+	etcdTimeout := time.Duration(*etcdRequestTimeout) * time.Millisecond
+
+	v2Client, err := etcd.New(etcd.Config{
+		Endpoints: utils.NewStringSlice(*fromServers),
+		Transport: etcd.DefaultTransport,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create etcd v2 client, %v", err)
+	}
+	v3Client, err := clientv3.New(clientv3.Config{
+		Endpoints:   utils.NewStringSlice(*toServers),
+		DialTimeout: etcdTimeout,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create etcd v3 client, %v", err)
+	}
+	defer v3Client.Close()
+
+	count, err := registry.MigrateV2ToV3(etcd.NewKeysAPI(v2Client), v3Client, *etcdKeyPrefix, etcdTimeout, *force)
+	if err != nil {
+		log.Fatalf("Migration failed after %d keys copied, %v", count, err)
+	}
+	log.Infof("Migration completed, %d keys copied from v2 to v3 under %s", count, *etcdKeyPrefix)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/api"
+	"github.com/qiuyesuifeng/tidb-demo/master"
+	"github.com/qiuyesuifeng/tidb-demo/minion"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/registry"
+)
+
+// tidemo-standalone runs master and minion in one process sharing an in-memory registry,
+// so that a single-node demo cluster can be brought up without etcd
+func main() {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	etcdServers := flag.String("etcd", "http://127.0.0.1:2379", "List of etcd endpoints which are passed to processes as $ETCD_ADDR")
+	apiPort := flag.Int("api-port", 8080, "Http port for web UI and REST API")
+	minionAPIPort := flag.Int("minion-api-port", minion.DefaultAPIPort, "Http port for the API serving logs of local processes")
+	tokenLimit := flag.Int("limit", 100, "Maximum number of entries per page returned from API requests")
+	monitorInterval := flag.Int("interval", 2000, "Interval at which the monitor should check and report the cluster status periodically.")
+	hostIP := flag.String("ip", "", "IP address which this host advertises")
+	hostName := flag.String("name", "", "The identifier of this machine in cluster")
+	hostRegion := flag.String("region", "", "Geographical region where this machine located")
+	hostIDC := flag.String("idc", "", "The IDC which this machine placed physically")
+	agentTTL := flag.String("ttl", minion.DefaultTTL, "TTL in seconds of machine state in registry")
+	logLevel := flag.String("log-level", "debug", "Log level: info, debug, warn, error, fatal")
+	dataDir := flag.String("data-dir", "", "The path of data directory in which program's logs and storage data will be placed")
+	flag.Parse()
+
+	log.SetLevelByString(*logLevel)
+	utils.SetDataDir(*dataDir)
+
+	masterCfg := &master.Config{
+		Registry:    registry.MemoryBackend,
+		EtcdServers: utils.NewStringSlice(*etcdServers),
+		TokenLimit:  *tokenLimit,
+		APIPort:     *apiPort,
+	}
+	minionCfg := &minion.Config{
+		Registry:        registry.MemoryBackend,
+		EtcdServers:     utils.NewStringSlice(*etcdServers),
+		MonitorInterval: *monitorInterval,
+		HostIP:          *hostIP,
+		HostName:        *hostName,
+		HostRegion:      *hostRegion,
+		HostIDC:         *hostIDC,
+		AgentTTL:        *agentTTL,
+		APIPort:         *minionAPIPort,
+	}
+
+	start := func() {
+		if err := master.Init(masterCfg); err != nil {
+			log.Fatalf("Failed to initializing tidemo master, %v", err)
+		}
+		if err := minion.Init(minionCfg); err != nil {
+			log.Fatalf("Failed to initializing tidemo minion, %v", err)
+		}
+		if err := master.Run(masterCfg); err != nil {
+			log.Fatalf("Failed to run tidemo master, %v", err)
+		}
+		if err := minion.Run(minionCfg); err != nil {
+			log.Fatalf("Failed to run tidemo minion, %v", err)
+		}
+	}
+	// the memory registry survives restarts but is lost on exit, so the processes are only left running
+	// to be adopted on restart, otherwise their procIDs would be reused by the new processes after exited
+	stop := func(exiting bool) {
+		minion.Kill()
+		if exiting {
+			minion.StopProcesses()
+		} else {
+			minion.Purge()
+		}
+		master.Kill()
+		master.Purge()
+	}
+
+	start()
+
+	// Start HTTP server for a set of REST APIs
+	go api.ServeHttp(masterCfg.APIPort)
+	log.Infof("API server listening at port: %d", masterCfg.APIPort)
+
+	shutdown := func() {
+		log.Infof("Gracefully shutting down")
+		stop(true)
+		os.Exit(0)
+	}
+
+	restart := func() {
+		log.Infof("Restarting server now")
+		stop(false)
+		start()
+	}
+
+	signals := map[os.Signal]func(){
+		syscall.SIGHUP:  restart,
+		syscall.SIGTERM: shutdown,
+		syscall.SIGINT:  shutdown,
+	}
+	sigchan := make(chan os.Signal, 1)
+	for k := range signals {
+		signal.Notify(sigchan, k)
+	}
+
+	for true {
+		sig := <-sigchan
+		if handler, ok := signals[sig]; ok {
+			handler()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/schema"
+)
+
+// tidemo-topology sends the topology document to master, which diffs it against the processes in registry
+// and converges them, prints the actions planned or applied
+func main() {
+	masterAddr := flag.String("master", "http://127.0.0.1:8080", "Address of the REST API of master")
+	file := flag.String("f", "topology.toml", "The topology document in TOML")
+	dryRun := flag.Bool("dry-run", false, "Only print the actions to converge, without applying them")
+	flag.Parse()
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("Failed to read topology file, %s, %v", *file, err)
+	}
+	query := url.Values{}
+	query.Set("dryRun", strconv.FormatBool(*dryRun))
+	addr := strings.TrimSuffix(*masterAddr, "/") + "/api/v1/topology?" + query.Encode()
+	res, err := http.Post(addr, "application/toml", bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("Failed to send topology to master, %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Fatalf("Failed to read response of master, %v", err)
+	}
+
+	var plan schema.TopologyPlan
+	if err := json.Unmarshal(body, &plan); err != nil || plan.Actions == nil {
+		// not a plan, but an error of master
+		log.Fatalf("Master responded %s, %s", res.Status, string(body))
+	}
+	if len(plan.Actions) == 0 {
+		fmt.Println("Nothing to do, the cluster matches the topology")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tSERVICE\tPROCID\tMACHID\tREASON\tERROR")
+	for _, act := range plan.Actions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", act.Type, act.SvcName, act.ProcID, act.MachID, act.Reason, act.Error)
+	}
+	w.Flush()
+	switch {
+	case plan.DryRun:
+		fmt.Printf("%d actions planned, run without -dry-run to apply\n", len(plan.Actions))
+	case plan.Applied:
+		fmt.Printf("%d actions applied\n", len(plan.Actions))
+	default:
+		fmt.Println("Failed to apply the topology, the actions after the failed one are not applied")
+		os.Exit(1)
+	}
+}
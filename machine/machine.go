@@ -33,11 +33,12 @@ type machine struct {
 	hostRegion string
 	hostIDC    string
 	publicIP   string
+	apiPort    int
 	stat       *MachineStat
 	rwMutex    sync.RWMutex
 }
 
-func NewMachine(hostip, hostname, hostregion, hostidc string) (Machine, error) {
+func NewMachine(hostip, hostname, hostregion, hostidc string, apiport int) (Machine, error) {
 	machID, err := readLocalMachineID()
 	if err != nil {
 		log.Errorf("Read local machine ID error, %v", err)
@@ -70,6 +71,7 @@ func NewMachine(hostip, hostname, hostregion, hostidc string) (Machine, error) {
 		hostRegion: hostregion,
 		hostIDC:    hostidc,
 		publicIP:   publicIP,
+		apiPort:    apiport,
 		stat: &MachineStat{
 			LoadAvg:     []float64{},
 			UsageOfDisk: []DiskUsage{},
@@ -153,6 +155,7 @@ func (m *machine) Status() *MachineStatus {
 			HostRegion: m.hostRegion,
 			HostIDC:    m.hostIDC,
 			PublicIP:   m.publicIP,
+			APIPort:    m.apiPort,
 		},
 		MachStat: m.getMachineStat(),
 	}
@@ -12,6 +12,7 @@ type MachineInfo struct {
 	HostRegion string
 	HostIDC    string
 	PublicIP   string
+	APIPort    int // port of the HTTP API of minion, serving logs of local processes
 }
 
 type MachineStat struct {
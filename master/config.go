@@ -23,6 +23,7 @@ const (
 )
 
 type Config struct {
+	Registry           string
 	EtcdServers        []string
 	EtcdKeyPrefix      string
 	EtcdRequestTimeout int
@@ -31,6 +32,7 @@ type Config struct {
 }
 
 func ParseFlag() (*Config, error) {
+	registryBackend := flag.String("registry", "etcd", "Backend of tidemo registry: etcd, etcdv3, memory")
 	etcdServers := flag.String("etcd", "http://127.0.0.1:2379,http://127.0.0.1:4001", "List of etcd endpoints, default 'http://127.0.0.1:2379'")
 	etcdKeyPrefix := flag.String("etcd-prefix", DefaultKeyPrefix, "Namespace for tidemo registry in etcd")
 	etcdRequestTimeout := flag.Int("etcd-timeout", 2500, "Amount of time in milliseconds to allow a single etcd request before considering it failed.")
@@ -51,6 +53,7 @@ func ParseFlag() (*Config, error) {
 	log.SetLevelByString(*logLevel)
 
 	cfg := &Config{
+		Registry:           *registryBackend,
 		EtcdServers:        utils.NewStringSlice(*etcdServers),
 		EtcdKeyPrefix:      *etcdKeyPrefix,
 		EtcdRequestTimeout: *etcdRequestTimeout,
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/ngaut/log"
 	"github.com/qiuyesuifeng/tidb-demo/agent"
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
@@ -17,6 +18,8 @@ import (
 
 const (
 	shutdownTimeout = time.Minute
+	// how often to reload services from registry
+	serviceReloadInterval = 5 * time.Second
 )
 
 var (
@@ -24,7 +27,8 @@ var (
 	wg      sync.WaitGroup // used to co-ordinate shutdown
 	running bool           = false
 
-	Agent *agent.Agent
+	Agent    *agent.Agent
+	Services *svc.Poller
 )
 
 func Init(cfg *Config) error {
@@ -32,31 +36,41 @@ func Init(cfg *Config) error {
 		return errors.New("Not allowed to initialize a running server")
 	}
 
-	// init registry driver of etcd
-	etcdAddrs := strings.Join(utils.TrimAddrs(cfg.EtcdServers), ",")
-	etcdTimeout := time.Duration(cfg.EtcdRequestTimeout) * time.Millisecond
-	etcdPrefix := cfg.EtcdKeyPrefix
-	etcdCfg := etcd.Config{
-		Endpoints: cfg.EtcdServers,
-		Transport: etcd.DefaultTransport,
-	}
-	etcdClient, err := etcd.New(etcdCfg)
+	// init registry driver
+	reg, err := newRegistry(cfg)
 	if err != nil {
 		return err
 	}
-	kAPI := etcd.NewKeysAPI(etcdClient)
-	reg := registry.NewEtcdRegistry(kAPI, etcdPrefix, etcdTimeout, etcdAddrs)
 
 	// check whether or not the registry is bootstrapped
 	if ok := reg.IsBootstrapped(); !ok {
 		if err := reg.Bootstrap(); err != nil {
 			log.Fatalf("Bootstarp failed, error: %v", err)
 		}
-		log.Info("Etcd registry bootstrapped successfully")
+		log.Infof("Registry bootstrapped successfully, backend: %s", cfg.Registry)
+	}
+	// upgrade the process nodes written by old versions before anything else touching them
+	if upgraded, err := reg.Upgrade(); err != nil {
+		return errors.New(fmt.Sprintf("Failed to upgrade registry, %v", err))
+	} else if upgraded > 0 {
+		log.Infof("Process nodes upgraded to the current format, count: %d", upgraded)
+	}
+	// move malformed process nodes, e.g. left by an interrupted creation, out of the way
+	if quarantined, err := reg.RepairProcesses(); err != nil {
+		log.Warnf("Failed to repair process nodes in registry, %v", err)
+	} else if len(quarantined) > 0 {
+		log.Warnf("Malformed process nodes quarantined, %v", quarantined)
+	}
+	// the index may be missing if processes were created by an old version
+	if err := reg.RebuildProcessIndex(); err != nil {
+		log.Warnf("Failed to rebuild index of processes in registry, %v", err)
 	}
 
-	// register services in cluster
-	svc.RegisterServices()
+	// register services defined in registry, and keep them up to date
+	if err := svc.LoadServices(reg); err != nil {
+		return err
+	}
+	Services = svc.NewPoller(reg, serviceReloadInterval)
 	// create agent
 	Agent = agent.NewAgent(reg, nil, nil)
 
@@ -64,12 +78,54 @@ func Init(cfg *Config) error {
 	return nil
 }
 
+func newRegistry(cfg *Config) (registry.Registry, error) {
+	etcdAddrs := strings.Join(utils.TrimAddrs(cfg.EtcdServers), ",")
+	switch cfg.Registry {
+	case registry.MemoryBackend:
+		// the memory registry is shared with minion running in the same binary
+		return registry.SharedMemoryRegistry(etcdAddrs), nil
+	case registry.EtcdBackend:
+		etcdTimeout := time.Duration(cfg.EtcdRequestTimeout) * time.Millisecond
+		etcdPrefix := cfg.EtcdKeyPrefix
+		etcdCfg := etcd.Config{
+			Endpoints: cfg.EtcdServers,
+			Transport: etcd.DefaultTransport,
+		}
+		etcdClient, err := etcd.New(etcdCfg)
+		if err != nil {
+			return nil, err
+		}
+		kAPI := etcd.NewKeysAPI(etcdClient)
+		return registry.NewEtcdRegistry(kAPI, etcdPrefix, etcdTimeout, etcdAddrs), nil
+	case registry.EtcdV3Backend:
+		etcdTimeout := time.Duration(cfg.EtcdRequestTimeout) * time.Millisecond
+		etcdClient, err := clientv3.New(clientv3.Config{
+			Endpoints:   cfg.EtcdServers,
+			DialTimeout: etcdTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return registry.NewEtcdV3Registry(etcdClient, cfg.EtcdKeyPrefix, etcdTimeout, etcdAddrs), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown registry backend: %s", cfg.Registry))
+	}
+}
+
 func Run(cfg *Config) (err error) {
 	if IsRunning() {
 		err = errors.New("Server is already running, cannot call to run repeatly")
 		return
 	}
 
+	stopc = make(chan struct{})
+	wg = sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		Services.Run(stopc)
+		wg.Done()
+	}()
+
 	log.Infof("Server started successfully")
 	switchStateToRunning()
 	return
@@ -81,7 +137,20 @@ func Kill() (err error) {
 		return
 	}
 
-	log.Infof("Tidemo minion stopped")
+	close(stopc)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		err = errors.New("Timed out waiting for server to shutdown")
+		return
+	}
+
+	log.Infof("Tidemo master stopped")
 	switchStateToStopped()
 	return
 }
@@ -0,0 +1,157 @@
+package minion
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+const (
+	// the number of lines returned by default if neither tail nor offset given
+	defaultTailLines = 100
+	// how often to check the log file for new content while following
+	followInterval = 500 * time.Millisecond
+)
+
+// serveAPI serves the HTTP API of minion until stopc closed, which is proxied by master
+func serveAPI(port int, stopc <-chan struct{}) {
+	// The API serving logs of local processes, the last lines are returned if no offset given:
+	//   GET /logs/{procID}?stream=stdout|stderr&run={run}&tail={lines}&offset={offset}&length={length}&follow=true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs/", handleLogs)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+	go func() {
+		<-stopc
+		srv.Close()
+	}()
+	log.Infof("Minion API server listening at port: %d", port)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("Minion API server stopped unexpectedly, %v", err)
+	}
+}
+
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	procID := strings.TrimPrefix(r.URL.Path, "/logs/")
+	if len(procID) == 0 {
+		http.Error(w, "The procID is required", http.StatusBadRequest)
+		return
+	}
+	process := Agent.ProcMgr.FindByProcID(procID)
+	if process == nil {
+		http.Error(w, fmt.Sprintf("Process not found on this machine, procID: %s", procID), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	run, err := intParam(query.Get("run"), -1)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Illegal parameter 'run', %v", err), http.StatusBadRequest)
+		return
+	}
+	tail, err := intParam(query.Get("tail"), defaultTailLines)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Illegal parameter 'tail', %v", err), http.StatusBadRequest)
+		return
+	}
+	offset, err := intParam(query.Get("offset"), -1)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Illegal parameter 'offset', %v", err), http.StatusBadRequest)
+		return
+	}
+	length, err := intParam(query.Get("length"), -1)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Illegal parameter 'length', %v", err), http.StatusBadRequest)
+		return
+	}
+	follow := query.Get("follow") == "true"
+
+	filename, err := process.LogFile(int(run), query.Get("stream"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the byte range is read if offset given, otherwise the last lines
+	var data []byte
+	if offset >= 0 {
+		data, err = proc.ReadLog(filename, offset, length)
+	} else {
+		data, offset, err = proc.TailLog(filename, int(tail))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("Log file not exists, %s", filename), http.StatusNotFound)
+			return
+		}
+		log.Errorf("Failed to read log file, %s, %v", filename, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Log-File", filename)
+	w.Header().Set("X-Log-Offset", strconv.FormatInt(offset, 10))
+	if _, err := w.Write(data); err != nil || !follow {
+		return
+	}
+	followLog(w, r, filename, offset+int64(len(data)))
+}
+
+// followLog writes the content appended to the log file in chunks, until the client gone
+func followLog(w http.ResponseWriter, r *http.Request, filename string, offset int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	flusher.Flush()
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		if fi.Size() < offset {
+			// the log file was truncated, start over
+			offset = 0
+		}
+		if fi.Size() == offset {
+			continue
+		}
+		data, err := proc.ReadLog(filename, offset, -1)
+		if err != nil {
+			log.Warnf("Failed to read log file while following, %s, %v", filename, err)
+			return
+		}
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+		flusher.Flush()
+		offset += int64(len(data))
+	}
+}
+
+func intParam(value string, def int64) (int64, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -20,9 +20,11 @@ const (
 	DefaultConfigFile = "minion.conf"
 	DefaultConfigDir  = "/etc/tidemo"
 	DefaultKeyPrefix  = "/_pingcap.com/tidemo"
+	DefaultAPIPort    = 9090
 )
 
 type Config struct {
+	Registry           string
 	EtcdServers        []string
 	EtcdKeyPrefix      string
 	EtcdRequestTimeout int
@@ -32,9 +34,11 @@ type Config struct {
 	HostRegion         string
 	HostIDC            string
 	AgentTTL           string
+	APIPort            int
 }
 
 func ParseFlag() (*Config, error) {
+	registryBackend := flag.String("registry", "etcd", "Backend of tidemo registry: etcd, etcdv3, memory")
 	etcdServers := flag.String("etcd", "http://127.0.0.1:2379,http://127.0.0.1:4001", "List of etcd endpoints, default 'http://127.0.0.1:2379'")
 	etcdKeyPrefix := flag.String("etcd-prefix", DefaultKeyPrefix, "Namespace for tidemo registry in etcd")
 	etcdRequestTimeout := flag.Int("etcd-timeout", 2500, "Amount of time in milliseconds to allow a single etcd request before considering it failed.")
@@ -44,6 +48,7 @@ func ParseFlag() (*Config, error) {
 	hostRegion := flag.String("region", "", "Geographical region where this machine located")
 	hostIDC := flag.String("idc", "", "The IDC which this machine placed physically")
 	agentTTL := flag.String("ttl", DefaultTTL, "TTL in seconds of machine state in etcd")
+	apiPort := flag.Int("api-port", DefaultAPIPort, "Http port for the API serving logs of local processes")
 	logLevel := flag.String("log-level", "debug", "Log level: info, debug, warn, error, fatal")
 	dataDir := flag.String("data-dir", "", "The path of data directory in which program's logs and storage data will be placed")
 
@@ -61,6 +66,7 @@ func ParseFlag() (*Config, error) {
 	utils.SetDataDir(*dataDir)
 
 	cfg := &Config{
+		Registry:           *registryBackend,
 		EtcdServers:        utils.NewStringSlice(*etcdServers),
 		EtcdKeyPrefix:      *etcdKeyPrefix,
 		EtcdRequestTimeout: *etcdRequestTimeout,
@@ -70,6 +76,7 @@ func ParseFlag() (*Config, error) {
 		HostRegion:         *hostRegion,
 		HostIDC:            *hostIDC,
 		AgentTTL:           *agentTTL,
+		APIPort:            *apiPort,
 	}
 	return cfg, nil
 }
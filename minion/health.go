@@ -0,0 +1,121 @@
+package minion
+
+import (
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/agent"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/registry"
+	svc "github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+// how often to check whether any local process is due to be probed
+const healthCheckTick = time.Second
+
+func NewHealthChecker(reg registry.Registry, ag *agent.Agent, ttl time.Duration) *HealthChecker {
+	return &HealthChecker{
+		reg:    reg,
+		agent:  ag,
+		clock:  clockwork.NewRealClock(),
+		ttl:    ttl,
+		states: make(map[string]*healthState),
+	}
+}
+
+// HealthChecker probes the local processes by the health probes of their services,
+// and publishes the results into registry, which expire along with the minion
+type HealthChecker struct {
+	reg    registry.Registry
+	agent  *agent.Agent
+	clock  clockwork.Clock
+	ttl    time.Duration
+	states map[string]*healthState
+}
+
+type healthState struct {
+	health      proc.HealthState
+	failures    int       // the number of consecutive failed probes
+	probedAt    time.Time // when the process was probed last time
+	publishedAt time.Time // when the health was published last time
+	published   bool
+}
+
+func (hc *HealthChecker) Run(stopc <-chan struct{}) {
+	for {
+		select {
+		case <-stopc:
+			log.Debug("HealthChecker is exiting due to stop signal")
+			return
+		case <-hc.clock.After(healthCheckTick):
+			hc.checkAll()
+		}
+	}
+}
+
+func (hc *HealthChecker) checkAll() {
+	processes := hc.agent.ProcMgr.AllProcess()
+	for procID := range hc.states {
+		if _, ok := processes[procID]; !ok {
+			delete(hc.states, procID)
+		}
+	}
+	cached := hc.agent.GetProcsFomeCache()
+	for procID, process := range processes {
+		state, ok := hc.states[procID]
+		if !ok {
+			state = &healthState{health: proc.HealthUnknown}
+			hc.states[procID] = state
+		}
+		health := hc.check(process, cached[procID], state)
+		now := hc.clock.Now()
+		if state.published && health == state.health && now.Sub(state.publishedAt) < hc.ttl/2 {
+			continue
+		}
+		if health != state.health {
+			log.Infof("Health of process changed, procID: %s, %s -> %s", procID, state.health, health)
+		}
+		state.health = health
+		if err := hc.reg.UpdateProcessHealth(procID, health, hc.ttl); err != nil {
+			log.Errorf("Failed to publish health of process, procID: %s, %v", procID, err)
+			continue
+		}
+		state.published = true
+		state.publishedAt = now
+	}
+}
+
+// check probes the process if it's due, returns the health of process
+func (hc *HealthChecker) check(process proc.Proc, status *proc.ProcessStatus, state *healthState) proc.HealthState {
+	service, ok := svc.Registered()[process.GetSvcName()]
+	if !ok || status == nil || !process.IsActive() {
+		state.failures = 0
+		return proc.HealthUnknown
+	}
+	probe := service.Status().HealthProbe
+	if probe == nil {
+		return proc.HealthUnknown
+	}
+	active := process.Active()
+	if active == nil || active.Uptime() < probe.InitialDelay {
+		state.failures = 0
+		return proc.HealthUnknown
+	}
+	now := hc.clock.Now()
+	if now.Sub(state.probedAt) < probe.Interval {
+		return state.health
+	}
+	state.probedAt = now
+	if err := probe.Probe(status.RunInfo.Endpoints); err != nil {
+		state.failures++
+		log.Debugf("Health probe of process failed, procID: %s, failures: %d, %v",
+			process.GetProcID(), state.failures, err)
+		if state.failures >= probe.FailureThreshold {
+			return proc.HealthUnhealthy
+		}
+		return state.health
+	}
+	state.failures = 0
+	return proc.HealthHealthy
+}
@@ -6,15 +6,17 @@ import (
 	"github.com/jonboulle/clockwork"
 	"github.com/ngaut/log"
 	"github.com/qiuyesuifeng/tidb-demo/agent"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
 	"github.com/qiuyesuifeng/tidb-demo/registry"
 )
 
 func NewProcessStatePublisher(reg registry.Registry, ag *agent.Agent, ttl time.Duration) *ProcessStatePublisher {
 	return &ProcessStatePublisher{
-		reg:   reg,
-		agent: ag,
-		clock: clockwork.NewRealClock(),
-		ttl:   ttl,
+		reg:       reg,
+		agent:     ag,
+		clock:     clockwork.NewRealClock(),
+		ttl:       ttl,
+		published: make(map[string]*publishedState),
 	}
 }
 
@@ -23,6 +25,23 @@ type ProcessStatePublisher struct {
 	agent *agent.Agent
 	clock clockwork.Clock
 	ttl   time.Duration
+	// the states published last time, to avoid writing etcd if nothing changed
+	published map[string]*publishedState
+}
+
+const (
+	// the number of the last runs of each process kept in registry
+	runHistoryLimit = 10
+	// how often to publish the resource usage of process, unless it's killed by OOM
+	resourceUsageInterval = 10 * time.Second
+)
+
+type publishedState struct {
+	restart  proc.RestartStatus
+	lastRun  proc.ProcessRunRecord
+	previous []proc.ProcessRunRecord // runs recorded in registry before minion started
+	usage    proc.ResourceUsage
+	usedAt   time.Time // when the usage was published
 }
 
 func (p *ProcessStatePublisher) Run(stopc <-chan struct{}) {
@@ -56,17 +75,101 @@ func (p *ProcessStatePublisher) doPublish(pub []string) error {
 			process.IsActive(), p.ttl); err != nil {
 			return err
 		}
+		if err := p.publishHistory(procID, process); err != nil {
+			return err
+		}
+		if err := p.publishUsage(procID, process); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (p *ProcessStatePublisher) doPublishAll() error {
-	for procID, process := range p.agent.ProcMgr.AllProcess() {
+	all := p.agent.ProcMgr.AllProcess()
+	for procID := range p.published {
+		if _, ok := all[procID]; !ok {
+			delete(p.published, procID)
+		}
+	}
+	for procID, process := range all {
 		log.Debugf("Publish local process's state to etcd, procID: %s", procID)
 		if err := p.reg.UpdateProcessState(procID, p.agent.Mach.ID(), process.GetSvcName(), process.State(),
 			process.IsActive(), p.ttl); err != nil {
 			return err
 		}
+		if err := p.publishHistory(procID, process); err != nil {
+			return err
+		}
+		if err := p.publishUsage(procID, process); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishHistory publishes the restart status and the last runs of process if they changed
+func (p *ProcessStatePublisher) publishHistory(procID string, process proc.Proc) error {
+	state, ok := p.published[procID]
+	if !ok {
+		// keep the runs recorded by the previous minion
+		previous, err := p.reg.ProcessRuns(procID)
+		if err != nil {
+			// the process may have been destroyed, try again next time
+			log.Warnf("Failed to read runs of process from registry, procID: %s, %v", procID, err)
+			return nil
+		}
+		state = &publishedState{previous: previous}
+		p.published[procID] = state
+	}
+
+	restart := process.RestartStatus()
+	if !ok || state.restart != restart {
+		if err := p.reg.UpdateProcessRestartStatus(procID, restart); err != nil {
+			return err
+		}
+		state.restart = restart
+	}
+
+	runs := process.History(runHistoryLimit)
+	if len(runs) == 0 || runs[len(runs)-1] == state.lastRun {
+		return nil
+	}
+	all := append(append([]proc.ProcessRunRecord{}, state.previous...), runs...)
+	if len(all) > runHistoryLimit {
+		all = all[len(all)-runHistoryLimit:]
+	}
+	if err := p.reg.UpdateProcessRuns(procID, all); err != nil {
+		return err
+	}
+	state.lastRun = runs[len(runs)-1]
+	return nil
+}
+
+// publishUsage publishes the resource usage of process periodically, and immediately after it's killed by OOM
+func (p *ProcessStatePublisher) publishUsage(procID string, process proc.Proc) error {
+	state, ok := p.published[procID]
+	if !ok {
+		return nil
+	}
+	usage, err := process.ResourceUsage()
+	if err != nil {
+		log.Debugf("Failed to read resource usage of process, procID: %s, %v", procID, err)
+		return nil
+	}
+	if usage == state.usage {
+		return nil
+	}
+	if usage.OOMKills == state.usage.OOMKills && p.clock.Since(state.usedAt) < resourceUsageInterval {
+		return nil
+	}
+	if usage.OOMKills > state.usage.OOMKills && !state.usedAt.IsZero() {
+		log.Warnf("Process was killed by OOM, procID: %s, OOM kills: %d", procID, usage.OOMKills)
+	}
+	if err := p.reg.UpdateProcessResourceUsage(procID, usage); err != nil {
+		return err
 	}
+	state.usage = usage
+	state.usedAt = p.clock.Now()
 	return nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
 	"github.com/qiuyesuifeng/tidb-demo/proc"
 	"github.com/qiuyesuifeng/tidb-demo/registry"
+	svc "github.com/qiuyesuifeng/tidb-demo/service"
 )
 
 const (
@@ -48,16 +49,49 @@ func (ar *AgentReconciler) Run(stopc <-chan struct{}) {
 		case event := <-ar.eStream.Next(reconcileInterval):
 			if event.None() {
 				log.Debug("Reconciling is triggered by tick")
+				if err := ar.reconcile(); err != nil {
+					log.Errorf("Failed to reconcile, %v", err)
+				}
 			} else {
 				log.Debugf("Reconciling is triggered by event, %v", event)
+				if err := ar.reconcileEvent(event); err != nil {
+					log.Errorf("Failed to reconcile, %v", err)
+				}
 			}
-			if err := ar.reconcile(); err != nil {
-				log.Errorf("Failed to reconcile, %v", err)
-			}
 		}
 	}
 }
 
+// reconcileEvent only reconciles the process touched by the event,
+// falls back to reconcile all processes if the event carries no procID
+func (ar *AgentReconciler) reconcileEvent(event utils.Event) error {
+	localMachID := ar.agent.Mach.ID()
+	switch event.Type {
+	case registry.ProcessTargetStateChangeEvent:
+		if len(event.ProcID) == 0 {
+			return ar.reconcile()
+		}
+		if len(event.MachID) > 0 && event.MachID != localMachID {
+			log.Debugf("Ignore event of process on other machine, %v", event)
+			return nil
+		}
+		start := time.Now()
+		toPublish, err := ar.doReconcileProcess(event.ProcID)
+		if err != nil {
+			return err
+		}
+		ar.agent.Subscribe(toPublish)
+		log.Debugf("Reconciling process %s completed in %s", event.ProcID, time.Now().Sub(start))
+		return nil
+	case registry.MachineStateChangeEvent:
+		if len(event.MachID) > 0 && event.MachID != localMachID {
+			log.Debugf("Ignore event of other machine, %v", event)
+			return nil
+		}
+	}
+	return ar.reconcile()
+}
+
 func (ar *AgentReconciler) reconcile() error {
 	start := time.Now()
 	toPublish, err := ar.doReconcile()
@@ -77,7 +111,7 @@ func (ar *AgentReconciler) reconcile() error {
 
 func (ar *AgentReconciler) doReconcile() ([]string, error) {
 	toPublish := make([]string, 0)
-	allProcesses, err := ar.reg.Processes()
+	allProcesses, err := ar.relatedProcesses(ar.agent.Mach.ID())
 	if err != nil {
 		return nil, err
 	}
@@ -91,28 +125,12 @@ func (ar *AgentReconciler) doReconcile() ([]string, error) {
 		process, ok := currentProcesses[procID]
 		if ok {
 			checked[procID] = struct{}{}
-			if procStatus.DesiredState == proc.StateStarted && process.State() == proc.StateStopped {
-				if err := ar.agent.ProcMgr.StartProcess(procID, endpoints); err != nil {
-					log.Errorf("Failed to start local process, procID: %s", procID)
-					return nil, err
-				}
-				toPublish = append(toPublish, procID)
-			}
-			if procStatus.DesiredState == proc.StateStopped && process.State() == proc.StateStarted {
-				if err := ar.agent.ProcMgr.StopProcess(procID); err != nil {
-					log.Errorf("Failed to stop local process, procID: %s", procID)
-					return nil, err
-				}
-				toPublish = append(toPublish, procID)
-			}
-		} else {
-			// local process not exists, create one
-			proc, err := ar.agent.ProcMgr.CreateProcess(procStatus, endpoints)
-			if err != nil {
-				log.Errorf("Failed to create new local process, %v", procStatus)
-				return nil, err
-			}
-			log.Infof("Create local process successfully, procID: %s, with state: %v", proc.GetProcID(), proc.State())
+		}
+		changed, err := ar.reconcileProcess(procStatus, process, endpoints)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
 			toPublish = append(toPublish, procID)
 		}
 	}
@@ -129,6 +147,143 @@ func (ar *AgentReconciler) doReconcile() ([]string, error) {
 	return toPublish, nil
 }
 
+// relatedProcesses returns the processes on the machine, along with the processes of the services they depend on,
+// which provide the endpoints and the readiness the local processes wait for, other processes are never read
+func (ar *AgentReconciler) relatedProcesses(machID string) (map[string]*proc.ProcessStatus, error) {
+	procs, err := ar.reg.ProcessesOnMachine(machID)
+	if err != nil {
+		return nil, err
+	}
+	svcs := svc.Registered()
+	deps := make(map[string]bool)
+	for _, p := range procs {
+		if s, ok := svcs[p.SvcName]; ok {
+			for _, dep := range s.Status().Dependencies {
+				deps[dep] = true
+			}
+		}
+	}
+	res := make(map[string]*proc.ProcessStatus, len(procs))
+	for procID, p := range procs {
+		res[procID] = p
+	}
+	for dep := range deps {
+		depProcs, err := ar.reg.ProcessesOfService(dep)
+		if err != nil {
+			return nil, err
+		}
+		for procID, p := range depProcs {
+			res[procID] = p
+		}
+	}
+	return res, nil
+}
+
+// doReconcileProcess drives the single local process towards its desired state,
+// endpoints of other processes are taken from the cache which refreshed by the last full reconciling,
+// all related processes are read again for a local process not cached yet
+func (ar *AgentReconciler) doReconcileProcess(procID string) ([]string, error) {
+	toPublish := make([]string, 0)
+	procStatus, err := ar.reg.Process(procID)
+	if err != nil {
+		// the process may have been destroyed, check all processes to find out
+		log.Debugf("Process not found in registry, reconcile all processes, procID: %s, %v", procID, err)
+		return ar.doReconcile()
+	}
+
+	// refresh the changed process in cache
+	cachedProcesses := ar.agent.GetProcsFomeCache()
+	if _, ok := cachedProcesses[procID]; !ok && procStatus.MachID == ar.agent.Mach.ID() {
+		// a new local process, whose dependencies may not be cached yet
+		return ar.doReconcile()
+	}
+	allProcesses := make(map[string]*proc.ProcessStatus, len(cachedProcesses)+1)
+	for k, v := range cachedProcesses {
+		allProcesses[k] = v
+	}
+	allProcesses[procID] = procStatus
+	ar.agent.SaveProcsToCache(allProcesses)
+
+	if procStatus.MachID != ar.agent.Mach.ID() {
+		return toPublish, nil
+	}
+	_, endpoints := prepareProcesses(allProcesses, ar.agent.Mach.ID())
+	endpoints["ETCD_ADDR"] = ar.reg.GetEtcdAddrs()
+	changed, err := ar.reconcileProcess(procStatus, ar.agent.ProcMgr.FindByProcID(procID), endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		toPublish = append(toPublish, procID)
+	}
+	return toPublish, nil
+}
+
+// reconcileProcess starts, stops or creates the local process according to the target status,
+// process is nil if it not exists locally, returns whether the local process is changed.
+// The process is not started until the services it depends on are ready, which is checked again on next reconciling.
+func (ar *AgentReconciler) reconcileProcess(procStatus *proc.ProcessStatus, process proc.Proc, endpoints map[string]string) (bool, error) {
+	procID := procStatus.ProcID
+	depsReady := true
+	created := false
+	if process != nil && process.RunGeneration() != procStatus.RunInfo.Generation {
+		// the run spec has been updated, destroy the local process and create it again with the new spec
+		log.Infof("Run spec of local process changed from generation %d to %d, restart it, procID: %s",
+			process.RunGeneration(), procStatus.RunInfo.Generation, procID)
+		if err := ar.agent.ProcMgr.DestroyProcess(procID); err != nil {
+			log.Errorf("Failed to destroy local process, procID: %s", procID)
+			return false, err
+		}
+		process = nil
+		created = true
+	}
+	if procStatus.DesiredState == proc.StateStarted && (process == nil || process.State() == proc.StateStopped) {
+		if err := svc.DependenciesReady(procStatus.SvcName, ar.agent.GetProcsFomeCache()); err != nil {
+			log.Infof("Postpone starting local process until dependencies ready, procID: %s, %v", procID, err)
+			depsReady = false
+		}
+	}
+	if process == nil {
+		// local process not exists, create one, which is left stopped if the dependencies not ready
+		target := procStatus
+		if !depsReady {
+			stopped := *procStatus
+			stopped.DesiredState = proc.StateStopped
+			target = &stopped
+		}
+		proc, err := ar.agent.ProcMgr.CreateProcess(target, endpoints)
+		if err != nil {
+			log.Errorf("Failed to create new local process, %v", procStatus)
+			return false, err
+		}
+		log.Infof("Create local process successfully, procID: %s, with state: %v", proc.GetProcID(), proc.State())
+		if procStatus.DesiredState == proc.State() {
+			return true, nil
+		}
+		// an adopted process may be running while it's desired to be stopped
+		process = proc
+		created = true
+	}
+	if procStatus.DesiredState == proc.StateStarted && process.State() == proc.StateStopped {
+		if !depsReady {
+			return created, nil
+		}
+		if err := ar.agent.ProcMgr.StartProcess(procID, endpoints); err != nil {
+			log.Errorf("Failed to start local process, procID: %s", procID)
+			return false, err
+		}
+		return true, nil
+	}
+	if procStatus.DesiredState == proc.StateStopped && process.State() == proc.StateStarted {
+		if err := ar.agent.ProcMgr.StopProcess(procID); err != nil {
+			log.Errorf("Failed to stop local process, procID: %s", procID)
+			return false, err
+		}
+		return true, nil
+	}
+	return created, nil
+}
+
 func prepareProcesses(allProcs map[string]*proc.ProcessStatus, machID string) (map[string]*proc.ProcessStatus, map[string]string) {
 	procsOnMach := make(map[string]*proc.ProcessStatus)
 	temp := make(map[string][]string)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/ngaut/log"
 	"github.com/qiuyesuifeng/tidb-demo/agent"
 	"github.com/qiuyesuifeng/tidb-demo/machine"
@@ -19,17 +20,23 @@ import (
 
 const (
 	shutdownTimeout = time.Minute
+	// how often to reload services from registry
+	serviceReloadInterval = 5 * time.Second
 )
 
 var (
 	stopc   chan struct{}  // used to terminate all other goroutines
 	wg      sync.WaitGroup // used to co-ordinate shutdown
 	running bool           = false
+	// stops watching the events of registry on shutdown
+	cancelEvents func()
 
 	Agent      *agent.Agent
 	Reconciler *AgentReconciler
 	Publisher  *ProcessStatePublisher
 	Heartbeat  *AgentHeartbeat
+	Health     *HealthChecker
+	Services   *svc.Poller
 )
 
 func Init(cfg *Config) error {
@@ -41,36 +48,30 @@ func Init(cfg *Config) error {
 		return err
 	}
 
-	// init registry driver of etcd
-	etcdAddrs := strings.Join(utils.TrimAddrs(cfg.EtcdServers), ",")
-	etcdTimeout := time.Duration(cfg.EtcdRequestTimeout) * time.Millisecond
-	etcdPrefix := cfg.EtcdKeyPrefix
-	etcdCfg := etcd.Config{
-		Endpoints: cfg.EtcdServers,
-		Transport: etcd.DefaultTransport,
-	}
-	etcdClient, err := etcd.New(etcdCfg)
+	// init registry driver and the event stream of it
+	reg, es, cancel, err := newRegistry(cfg)
 	if err != nil {
 		return err
 	}
-	kAPI := etcd.NewKeysAPI(etcdClient)
-	reg := registry.NewEtcdRegistry(kAPI, etcdPrefix, etcdTimeout, etcdAddrs)
-	es := registry.NewEtcdEventStream(kAPI, etcdPrefix)
+	cancelEvents = cancel
 
 	// check whether or not the registry is bootstrapped
 	if ok := reg.IsBootstrapped(); !ok {
 		if err := reg.Bootstrap(); err != nil {
 			log.Fatalf("Bootstarp failed, error: %v", err)
 		}
-		log.Info("Etcd registry bootstrapped successfully")
+		log.Infof("Registry bootstrapped successfully, backend: %s", cfg.Registry)
 	}
 
-	// register services in cluster
-	svc.RegisterServices()
+	// register services defined in registry, and keep them up to date
+	if err := svc.LoadServices(reg); err != nil {
+		return err
+	}
+	Services = svc.NewPoller(reg, serviceReloadInterval)
 	// init local processes manager
 	procMgr := proc.NewProcessManager()
 	// init this machine
-	mach, err := machine.NewMachine(cfg.HostIP, cfg.HostName, cfg.HostRegion, cfg.HostIDC)
+	mach, err := machine.NewMachine(cfg.HostIP, cfg.HostName, cfg.HostRegion, cfg.HostIDC, cfg.APIPort)
 	if err != nil {
 		return err
 	}
@@ -82,11 +83,51 @@ func Init(cfg *Config) error {
 	Reconciler = NewReconciler(reg, es, Agent)
 	Publisher = NewProcessStatePublisher(reg, Agent, agentTTL)
 	Heartbeat = NewAgentHeartbeat(reg, Agent, agentTTL)
+	Health = NewHealthChecker(reg, Agent, agentTTL)
 
 	log.Infof("Server initialized successfully")
 	return nil
 }
 
+func newRegistry(cfg *Config) (registry.Registry, utils.EventStream, func(), error) {
+	etcdAddrs := strings.Join(utils.TrimAddrs(cfg.EtcdServers), ",")
+	switch cfg.Registry {
+	case registry.MemoryBackend:
+		// the memory registry is shared with master running in the same binary
+		reg := registry.SharedMemoryRegistry(etcdAddrs)
+		es, cancel := registry.NewMemoryEventStream(reg)
+		return reg, es, cancel, nil
+	case registry.EtcdBackend:
+		etcdTimeout := time.Duration(cfg.EtcdRequestTimeout) * time.Millisecond
+		etcdPrefix := cfg.EtcdKeyPrefix
+		etcdCfg := etcd.Config{
+			Endpoints: cfg.EtcdServers,
+			Transport: etcd.DefaultTransport,
+		}
+		etcdClient, err := etcd.New(etcdCfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		kAPI := etcd.NewKeysAPI(etcdClient)
+		reg := registry.NewEtcdRegistry(kAPI, etcdPrefix, etcdTimeout, etcdAddrs)
+		return reg, registry.NewEtcdEventStream(kAPI, etcdPrefix), func() {}, nil
+	case registry.EtcdV3Backend:
+		etcdTimeout := time.Duration(cfg.EtcdRequestTimeout) * time.Millisecond
+		etcdPrefix := cfg.EtcdKeyPrefix
+		etcdClient, err := clientv3.New(clientv3.Config{
+			Endpoints:   cfg.EtcdServers,
+			DialTimeout: etcdTimeout,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		reg := registry.NewEtcdV3Registry(etcdClient, etcdPrefix, etcdTimeout, etcdAddrs)
+		return reg, registry.NewEtcdV3EventStream(etcdClient, etcdPrefix), func() {}, nil
+	default:
+		return nil, nil, nil, errors.New(fmt.Sprintf("Unknown registry backend: %s", cfg.Registry))
+	}
+}
+
 func Run(cfg *Config) (err error) {
 	if IsRunning() {
 		err = errors.New("Server is already running, cannot call to run repeatly")
@@ -103,7 +144,10 @@ func Run(cfg *Config) (err error) {
 		func() { Reconciler.Run(stopc) },
 		func() { Publisher.Run(stopc) },
 		func() { Heartbeat.Run(stopc) },
+		func() { Health.Run(stopc) },
+		func() { Services.Run(stopc) },
 		func() { Agent.Mach.Monitor(stopc) },
+		func() { serveAPI(cfg.APIPort, stopc) },
 	}
 
 	for _, f := range components {
@@ -127,6 +171,10 @@ func Kill() (err error) {
 	}
 
 	close(stopc)
+	if cancelEvents != nil {
+		cancelEvents()
+		cancelEvents = nil
+	}
 	done := make(chan struct{})
 	go func() {
 		wg.Wait()
@@ -144,7 +192,31 @@ func Kill() (err error) {
 	return
 }
 
+// Purge detaches the local processes, which are kept running and will be adopted after the minion restarted
 func Purge() {
+	if Agent != nil && Agent.ProcMgr != nil {
+		Agent.ProcMgr.Release()
+	}
+}
+
+// StopProcesses stops and destroys the local processes, for those which would never be adopted after the minion exited,
+// e.g. the procIDs kept in memory registry are lost and reused by other processes
+func StopProcesses() {
+	if Agent == nil || Agent.ProcMgr == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for procID := range Agent.ProcMgr.AllProcess() {
+		procID := procID
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Agent.ProcMgr.DestroyProcess(procID); err != nil {
+				log.Errorf("Failed to destroy local process, procID: %s, %v", procID, err)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 func Dump(cfg *Config) (dumpinfo []byte, err error) {
@@ -141,10 +141,11 @@ func (e Endpoint) String() string {
 	if len(e.IPAddr) > 0 {
 		ip = e.IPAddr
 	}
+	addr := net.JoinHostPort(ip, strconv.Itoa(int(e.Port.Value())))
 	if len(e.Protocol) > 0 {
-		return fmt.Sprintf("%s://%s:%d", e.Protocol.String(), ip, e.Port.Value())
+		return fmt.Sprintf("%s://%s", e.Protocol.String(), addr)
 	} else {
-		return fmt.Sprintf("%s:%d", ip, e.Port.Value())
+		return addr
 	}
 }
 
@@ -163,30 +164,21 @@ func TrimAddrs(addrs []string) []string {
 
 func ParseEndpoint(str string) (Endpoint, error) {
 	var res Endpoint
-	parts := strings.Split(str, "://")
-	if len(parts) == 2 {
-		sparts := strings.Split(parts[1], ":")
-		if len(sparts) < 2 {
-			return res, errors.New(fmt.Sprintf("Illegal endpoint string: %s", str))
-		}
+	addr := str
+	if parts := strings.SplitN(str, "://", 2); len(parts) == 2 {
 		res.Protocol = Protocol(parts[0])
-		res.IPAddr = sparts[0]
-		if port, err := strconv.Atoi(sparts[1]); err != nil {
-			return res, errors.New(fmt.Sprintf("Illegal endpoint string: %s", str))
-		} else {
-			res.Port = Port(port)
-		}
+		addr = parts[1]
+	}
+	// the IPv6 address is enclosed in square brackets, e.g. [::1]:2379
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return res, errors.New(fmt.Sprintf("Illegal endpoint string: %s", str))
+	}
+	res.IPAddr = host
+	if p, err := strconv.Atoi(port); err != nil {
+		return res, errors.New(fmt.Sprintf("Illegal endpoint string: %s", str))
 	} else {
-		sparts := strings.Split(parts[0], ":")
-		if len(sparts) < 2 {
-			return res, errors.New(fmt.Sprintf("Illegal endpoint string: %s", str))
-		}
-		res.IPAddr = sparts[0]
-		if port, err := strconv.Atoi(sparts[1]); err != nil {
-			return res, errors.New(fmt.Sprintf("Illegal endpoint string: %s", str))
-		} else {
-			res.Port = Port(port)
-		}
+		res.Port = Port(p)
 	}
 	return res, nil
 }
@@ -211,11 +203,18 @@ func EndpointsToStrings(endpoints map[string]Endpoint) []string {
 	return res
 }
 
-type Event string
+type EventType string
+
+// Event is emitted by EventStream, carrying the identifiers of the affected process or machine
+type Event struct {
+	Type   EventType
+	ProcID string `json:",omitempty"`
+	MachID string `json:",omitempty"`
+}
 
 // None event produced by a watcher timeout
 func (e Event) None() bool {
-	if string(e) == "" {
+	if string(e.Type) == "" {
 		return true
 	}
 	return false
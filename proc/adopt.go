@@ -0,0 +1,317 @@
+package proc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+const (
+	// the directory under the working directory of processes, where the states of running processes are kept
+	runStateDir = "pids"
+	// how often to check whether an adopted process is still running
+	adoptedPollInterval = time.Second
+	// the exit status reported for adopted runs, since the exit status of a process not our child can't be known,
+	// exit code 255 is reported and it's treated as failure by restart policies
+	unknownExitStatus = syscall.WaitStatus(0xff << 8)
+)
+
+// RunState is persisted when a process is started, so that a restarted minion can find the running process
+// and adopt it instead of starting a duplicate one
+type RunState struct {
+	ProcID      string
+	SvcName     string
+	RunID       int
+	Generation  int64 // the generation of run spec which the process is started with
+	Command     string
+	Args        []string // the args before variables replaced, compared with the spec of process on adopting
+	PID         int
+	StartTime   uint64 // start time of the OS process in clock ticks since boot, tells a reused PID apart
+	Started     time.Time
+	Commandline string
+}
+
+func runStatePath(pwd, procID string) string {
+	return filepath.Join(pwd, runStateDir, procID+".json")
+}
+
+func saveRunState(pwd string, state *RunState) error {
+	dir := filepath.Join(pwd, runStateDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it, so that the state file is never half written
+	path := runStatePath(pwd, state.ProcID)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadRunState returns nil if no state saved for the process
+func loadRunState(pwd, procID string) (*RunState, error) {
+	b, err := ioutil.ReadFile(runStatePath(pwd, procID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	state := &RunState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// removeRunState removes the state of process only if it's saved for the run with the same PID,
+// the state of a newer run must be kept
+func removeRunState(pwd, procID string, pid int) {
+	state, err := loadRunState(pwd, procID)
+	if err != nil || state == nil || state.PID != pid {
+		return
+	}
+	if err := os.Remove(runStatePath(pwd, procID)); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove run state of process, procID: %s, %v", procID, err)
+	}
+}
+
+// processStartTime reads the start time of process from /proc/{pid}/stat,
+// returns an error if the process not exists or is a zombie
+func processStartTime(pid int) (uint64, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name in parentheses may contain spaces, so parse the fields after it
+	stat := string(b)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return 0, errors.New(fmt.Sprintf("Unexpected format of /proc/%d/stat", pid))
+	}
+	fields := strings.Fields(stat[i+1:])
+	// fields start from the 3rd one, state, and the 22nd one is starttime
+	if len(fields) < 20 {
+		return 0, errors.New(fmt.Sprintf("Unexpected format of /proc/%d/stat", pid))
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return 0, errors.New(fmt.Sprintf("Process %d is dead", pid))
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// isSameProcess tells whether the process started at startTime is still running with the PID
+func isSameProcess(pid int, startTime uint64) bool {
+	t, err := processStartTime(pid)
+	return err == nil && t == startTime
+}
+
+// adoptedRun is a run of process started by the previous minion, which is not a child of us,
+// so that it can only be watched by polling
+type adoptedRun struct {
+	state      *RunState
+	stopc      chan struct{}
+	stopped    time.Time
+	stopStage  StopStage
+	stageMutex sync.Mutex
+}
+
+func newAdoptedRun(state *RunState) *adoptedRun {
+	ar := &adoptedRun{
+		state: state,
+		stopc: make(chan struct{}),
+	}
+	go func() {
+		for isSameProcess(state.PID, state.StartTime) {
+			time.Sleep(adoptedPollInterval)
+		}
+		log.Infof("Adopted process %s[%s], PID: %d exited", state.SvcName, state.ProcID, state.PID)
+		ar.stopped = time.Now()
+		close(ar.stopc)
+	}()
+	return ar
+}
+
+func (ar *adoptedRun) Start() error {
+	return errors.New("An adopted process can not be started again")
+}
+
+func (ar *adoptedRun) Signal(sig syscall.Signal) error {
+	if !isSameProcess(ar.state.PID, ar.state.StartTime) {
+		return errors.New("Process not running")
+	}
+	return syscall.Kill(ar.state.PID, sig)
+}
+
+func (ar *adoptedRun) Kill() error {
+	return ar.Signal(syscall.SIGKILL)
+}
+
+func (ar *adoptedRun) WaitingStopped() {
+	<-ar.stopc
+}
+
+func (ar *adoptedRun) WaitingStoppedInMillisecond(timeout time.Duration) bool {
+	select {
+	case <-ar.stopc:
+		return true
+	case <-time.After(timeout * time.Millisecond):
+		return false
+	}
+}
+
+func (ar *adoptedRun) ExitStatus() syscall.WaitStatus {
+	return unknownExitStatus
+}
+
+func (ar *adoptedRun) Uptime() time.Duration {
+	select {
+	case <-ar.stopc:
+		return ar.stopped.Sub(ar.state.Started)
+	default:
+		return time.Since(ar.state.Started)
+	}
+}
+
+func (ar *adoptedRun) Record() ProcessRunRecord {
+	record := ProcessRunRecord{
+		ID:          ar.state.RunID,
+		PID:         ar.state.PID,
+		Commandline: ar.state.Commandline,
+		Started:     ar.state.Started,
+	}
+	select {
+	case <-ar.stopc:
+		record.Stopped = ar.stopped
+		record.ExitCode = unknownExitStatus.ExitStatus()
+		record.Error = "Exit status unknown, the process was adopted after minion restarted"
+	default:
+	}
+	ar.stageMutex.Lock()
+	record.StopStage = ar.stopStage
+	ar.stageMutex.Unlock()
+	return record
+}
+
+func (ar *adoptedRun) SetStopStage(stage StopStage) {
+	ar.stageMutex.Lock()
+	defer ar.stageMutex.Unlock()
+	ar.stopStage = stage
+}
+
+// Adopt takes over the process left running by the previous minion, if any,
+// returns false if there is nothing to adopt
+func (p *Process) Adopt(endpoints map[string]string) (bool, error) {
+	if p.IsActive() {
+		return false, errors.New("Process maybe already started")
+	}
+	state, err := loadRunState(p.Pwd, p.ProcID)
+	if err != nil {
+		log.Warnf("Failed to load run state of process, procID: %s, %v", p.ProcID, err)
+		return false, nil
+	}
+	if state == nil {
+		return false, nil
+	}
+	if !isSameProcess(state.PID, state.StartTime) {
+		log.Debugf("Process recorded in run state is not running, procID: %s, PID: %d", p.ProcID, state.PID)
+		removeRunState(p.Pwd, p.ProcID, state.PID)
+		return false, nil
+	}
+	// the procID may be reused by another process, e.g. by the memory registry after restarted
+	if !p.isSameSpec(state) {
+		log.Warnf("Process recorded in run state is not the one of procID %s, it's left running, service: %s, PID: %d, commandline: %s",
+			p.ProcID, state.SvcName, state.PID, state.Commandline)
+		removeRunState(p.Pwd, p.ProcID, state.PID)
+		return false, nil
+	}
+
+	pr := newAdoptedRun(state)
+	p.rwMutex.Lock()
+	p.endpoints = endpoints
+	p.runBase = state.RunID
+	p.runGen = state.Generation
+	p.procRuns = append(p.procRuns, pr)
+	p.active = pr
+	p.state = StateStarted
+	p.rwMutex.Unlock()
+	go p.watch(pr)
+	go p.rotateLogs(pr)
+	log.Infof("Adopt running process %s[%s], PID: %d, commandline: %s", p.SvcName, p.ProcID, state.PID, state.Commandline)
+	return true, nil
+}
+
+// isSameSpec tells whether the run state is saved by the run of the same service, command, args and generation
+func (p *Process) isSameSpec(state *RunState) bool {
+	if state.SvcName != p.SvcName || state.Generation != p.Generation {
+		return false
+	}
+	// the command and args are not saved by the old versions, which are only checked by the service
+	if len(state.Command) == 0 {
+		return true
+	}
+	if state.Command != p.Command || len(state.Args) != len(p.Args) {
+		return false
+	}
+	for i := range state.Args {
+		if state.Args[i] != p.Args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Release detaches the process from us without stopping it,
+// it will never be restarted by us, and is left to be adopted by a new process manager
+func (p *Process) Release() {
+	p.rwMutex.Lock()
+	defer p.rwMutex.Unlock()
+	p.cancelRestart()
+	p.released = true
+}
+
+func (p *Process) isReleased() bool {
+	p.rwMutex.RLock()
+	defer p.rwMutex.RUnlock()
+	return p.released
+}
+
+// saveRun persists the state of the run just started
+func (p *Process) saveRun(pr ProcRun) {
+	record := pr.Record()
+	state := &RunState{
+		ProcID:      p.ProcID,
+		SvcName:     p.SvcName,
+		RunID:       record.ID,
+		Generation:  p.Generation,
+		Command:     p.Command,
+		Args:        p.Args,
+		PID:         record.PID,
+		Started:     record.Started,
+		Commandline: record.Commandline,
+	}
+	startTime, err := processStartTime(record.PID)
+	if err != nil {
+		log.Warnf("Failed to read start time of process, procID: %s, PID: %d, %v", p.ProcID, record.PID, err)
+		return
+	}
+	state.StartTime = startTime
+	if err := saveRunState(p.Pwd, state); err != nil {
+		log.Warnf("Failed to save run state of process, procID: %s, %v", p.ProcID, err)
+	}
+}
@@ -0,0 +1,282 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// all processes are placed in cgroups under this one, named by procID
+	cgroupParent = "tidemo"
+	// the period of CPU bandwidth control in microseconds
+	cpuPeriod = 100000
+)
+
+// ResourceLimits restricts the resources used by process through cgroups, the zero value means no limit
+type ResourceLimits struct {
+	// the number of CPU cores the process can use, e.g. 1.5, 0 means no limit
+	CPUQuota float64
+	// the max memory in bytes the process can use before killed by OOM, 0 means no limit
+	MemoryLimit int64
+	// the relative weight of IO in [10, 1000], 0 means the default weight
+	IOWeight int
+}
+
+func (rl ResourceLimits) IsZero() bool {
+	return rl == ResourceLimits{}
+}
+
+func (rl ResourceLimits) Validate() error {
+	if rl.CPUQuota < 0 || rl.MemoryLimit < 0 {
+		return errors.New("The resource limits should not be negative")
+	}
+	if rl.IOWeight != 0 && (rl.IOWeight < 10 || rl.IOWeight > 1000) {
+		return errors.New(fmt.Sprintf("The IO weight should be in [10, 1000], got %d", rl.IOWeight))
+	}
+	return nil
+}
+
+// ResourceUsage is the resources used by process, read from its cgroup,
+// which is kept across the runs of process, so the numbers are accumulated since the first run
+type ResourceUsage struct {
+	CPUTime     time.Duration
+	MemoryUsage int64
+	OOMKills    int
+}
+
+// cgroup is the control group of process, whichever version of cgroups the host mounted
+type cgroup interface {
+	// apply moves the process into cgroup, and sets the limits
+	apply(pid int, limits ResourceLimits) error
+	usage() (ResourceUsage, error)
+	remove() error
+}
+
+func newCgroup(procID string) cgroup {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return &cgroupV2{dir: filepath.Join(cgroupRoot, cgroupParent, procID)}
+	}
+	return &cgroupV1{procID: procID}
+}
+
+// cgroupV2 is a cgroup in the unified hierarchy
+type cgroupV2 struct {
+	// the directory of cgroup:
+	//   /sys/fs/cgroup/tidemo/{procID}
+	dir string
+}
+
+func (cg *cgroupV2) apply(pid int, limits ResourceLimits) error {
+	// the controllers must be enabled in all ancestors to be used by the leaf cgroup
+	parent := filepath.Dir(cg.dir)
+	if err := os.MkdirAll(cg.dir, 0755); err != nil {
+		return err
+	}
+	for _, dir := range []string{cgroupRoot, parent} {
+		for _, controller := range []string{"cpu", "memory", "io"} {
+			if err := writeCgroupFile(dir, "cgroup.subtree_control", "+"+controller); err != nil {
+				log.Warnf("Failed to enable cgroup controller %s in %s, %v", controller, dir, err)
+			}
+		}
+	}
+
+	cpuMax := "max"
+	if limits.CPUQuota > 0 {
+		cpuMax = strconv.Itoa(int(limits.CPUQuota * cpuPeriod))
+	}
+	if err := writeCgroupFile(cg.dir, "cpu.max", fmt.Sprintf("%s %d", cpuMax, cpuPeriod)); err != nil {
+		return err
+	}
+	memoryMax := "max"
+	if limits.MemoryLimit > 0 {
+		memoryMax = strconv.FormatInt(limits.MemoryLimit, 10)
+	}
+	if err := writeCgroupFile(cg.dir, "memory.max", memoryMax); err != nil {
+		return err
+	}
+	if limits.IOWeight > 0 {
+		if err := writeCgroupFile(cg.dir, "io.weight", fmt.Sprintf("default %d", limits.IOWeight)); err != nil {
+			return err
+		}
+	}
+	return writeCgroupFile(cg.dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+func (cg *cgroupV2) usage() (ResourceUsage, error) {
+	var usage ResourceUsage
+	stat, err := readCgroupKeyedFile(cg.dir, "cpu.stat")
+	if err != nil {
+		return usage, err
+	}
+	usage.CPUTime = time.Duration(stat["usage_usec"]) * time.Microsecond
+	if usage.MemoryUsage, err = readCgroupInt(cg.dir, "memory.current"); err != nil {
+		return usage, err
+	}
+	events, err := readCgroupKeyedFile(cg.dir, "memory.events")
+	if err != nil {
+		return usage, err
+	}
+	usage.OOMKills = int(events["oom_kill"])
+	return usage, nil
+}
+
+func (cg *cgroupV2) remove() error {
+	return removeCgroupDir(cg.dir)
+}
+
+// cgroupV1 is a cgroup in each hierarchy of controllers
+type cgroupV1 struct {
+	// the directories of cgroup:
+	//   /sys/fs/cgroup/{cpu,cpuacct,memory,blkio}/tidemo/{procID}
+	procID string
+}
+
+func (cg *cgroupV1) dir(controller string) string {
+	return filepath.Join(cgroupRoot, controller, cgroupParent, cg.procID)
+}
+
+func (cg *cgroupV1) apply(pid int, limits ResourceLimits) error {
+	for _, controller := range []string{"cpu", "cpuacct", "memory", "blkio"} {
+		dir := cg.dir(controller)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		switch controller {
+		case "cpu":
+			quota := "-1"
+			if limits.CPUQuota > 0 {
+				quota = strconv.Itoa(int(limits.CPUQuota * cpuPeriod))
+			}
+			if err := writeCgroupFile(dir, "cpu.cfs_period_us", strconv.Itoa(cpuPeriod)); err != nil {
+				return err
+			}
+			if err := writeCgroupFile(dir, "cpu.cfs_quota_us", quota); err != nil {
+				return err
+			}
+		case "memory":
+			limit := "-1"
+			if limits.MemoryLimit > 0 {
+				limit = strconv.FormatInt(limits.MemoryLimit, 10)
+			}
+			if err := writeCgroupFile(dir, "memory.limit_in_bytes", limit); err != nil {
+				return err
+			}
+		case "blkio":
+			if limits.IOWeight > 0 {
+				if err := writeCgroupFile(dir, "blkio.weight", strconv.Itoa(limits.IOWeight)); err != nil {
+					// the weight is not supported by the multi-queue IO schedulers
+					log.Warnf("Failed to set IO weight of cgroup, %s, %v", dir, err)
+				}
+			}
+		}
+		if err := writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cg *cgroupV1) usage() (ResourceUsage, error) {
+	var usage ResourceUsage
+	cpu, err := readCgroupInt(cg.dir("cpuacct"), "cpuacct.usage")
+	if err != nil {
+		return usage, err
+	}
+	usage.CPUTime = time.Duration(cpu)
+	if usage.MemoryUsage, err = readCgroupInt(cg.dir("memory"), "memory.usage_in_bytes"); err != nil {
+		return usage, err
+	}
+	// the counter of OOM kills is only reported by kernel 4.13 and later
+	if oom, err := readCgroupKeyedFile(cg.dir("memory"), "memory.oom_control"); err == nil {
+		usage.OOMKills = int(oom["oom_kill"])
+	}
+	return usage, nil
+}
+
+func (cg *cgroupV1) remove() error {
+	for _, controller := range []string{"cpu", "cpuacct", "memory", "blkio"} {
+		if err := removeCgroupDir(cg.dir(controller)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
+
+func readCgroupInt(dir, file string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// readCgroupKeyedFile reads the file of lines in format of "key value"
+func readCgroupKeyedFile(dir, file string) (map[string]int64, error) {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			res[fields[0]] = v
+		}
+	}
+	return res, scanner.Err()
+}
+
+// removeCgroupDir removes the cgroup, which is only allowed after all processes in it exited
+func removeCgroupDir(dir string) error {
+	if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// limitRun moves the run just started into the cgroup of process, the process runs a moment without limits
+// before moved, failing to apply the limits is not fatal, the process is kept running without them
+func (p *Process) limitRun(pr ProcRun) {
+	if p.Limits.IsZero() {
+		return
+	}
+	pid := pr.Record().PID
+	if err := newCgroup(p.ProcID).apply(pid, p.Limits); err != nil {
+		log.Errorf("Failed to apply resource limits to process, procID: %s, PID: %d, limits: %+v, %v",
+			p.ProcID, pid, p.Limits, err)
+	}
+}
+
+// ResourceUsage returns the resources used by process, zero if the process is not limited
+func (p *Process) ResourceUsage() (ResourceUsage, error) {
+	if p.Limits.IsZero() {
+		return ResourceUsage{}, nil
+	}
+	return newCgroup(p.ProcID).usage()
+}
+
+// removeCgroup removes the cgroup of process after it's destroyed
+func removeCgroup(procID string) {
+	if err := newCgroup(procID).remove(); err != nil {
+		log.Warnf("Failed to remove cgroup of process, procID: %s, %v", procID, err)
+	}
+}
@@ -0,0 +1,120 @@
+package proc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/juju/errors"
+)
+
+const (
+	LogStdout = "stdout"
+	LogStderr = "stderr"
+
+	// the size of chunk read backwards from the end of file when tailing
+	tailChunkSize = 4096
+)
+
+// LogFile returns the path of log file of the run, the latest run if run is negative,
+// stream is either stdout or stderr
+func (p *Process) LogFile(run int, stream string) (string, error) {
+	var pattern string
+	switch stream {
+	case "", LogStdout:
+		pattern = p.StdoutFile
+	case LogStderr:
+		pattern = p.StderrFile
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown log stream: %s", stream))
+	}
+	if len(pattern) == 0 {
+		return "", errors.New(fmt.Sprintf("The %s of process is not logged to file, procID: %s", stream, p.ProcID))
+	}
+
+	p.rwMutex.RLock()
+	first, next := p.runBase, p.runBase+len(p.procRuns)
+	p.rwMutex.RUnlock()
+	if run < 0 {
+		if next == first {
+			return "", errors.New(fmt.Sprintf("Process has never been started, procID: %s", p.ProcID))
+		}
+		run = next - 1
+	}
+	// the logs of runs before the process adopted are still on disk, only the future runs are unknown
+	if run >= next {
+		return "", errors.New(fmt.Sprintf("Run %d of process not exists, procID: %s", run, p.ProcID))
+	}
+	return ReplaceVars(pattern, p.runVars(run, nil)), nil
+}
+
+// TailLog reads the last n lines of the log file, and returns the offset where the lines start
+func TailLog(filename string, n int) ([]byte, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+	if n <= 0 {
+		return []byte{}, size, nil
+	}
+
+	// read chunks backwards until enough newlines found, the trailing newline of the last line not counted
+	offset := size
+	lines := 0
+	buf := []byte{}
+	for offset > 0 && lines <= n {
+		chunk := int64(tailChunkSize)
+		if chunk > offset {
+			chunk = offset
+		}
+		offset -= chunk
+		b := make([]byte, chunk)
+		if _, err := f.ReadAt(b, offset); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf = append(b, buf...)
+		lines = bytes.Count(buf, []byte{'\n'})
+		if len(buf) > 0 && buf[len(buf)-1] == '\n' {
+			lines--
+		}
+	}
+	// drop the lines more than wanted
+	for lines > n-1 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		buf = buf[i+1:]
+		offset += int64(i + 1)
+		lines--
+	}
+	return buf, offset, nil
+}
+
+// ReadLog reads at most length bytes of the log file from offset, until the end of file if length is negative
+func ReadLog(filename string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -20,7 +20,8 @@ type FileLogWriter struct {
 }
 
 func NewFileLogWriter(file string) (*FileLogWriter, error) {
-	f, err := os.Create(file)
+	// opened in append mode, so that the writes follow the end of file after it's truncated by rotation
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -19,6 +19,7 @@ type ProcMgr interface {
 	TotalActiveProcess() int
 	FindByProcID(string) Proc
 	FindBySvcName(string) map[string]Proc
+	Release()
 }
 
 type processManager struct {
@@ -46,13 +47,21 @@ func (pm *processManager) CreateProcess(target *ProcessStatus, endpoints map[str
 	meta := buildProcessMeta(target)
 	// TODO: stdout and stderr filepath should be assigned from client
 	proc, err := NewProcess(target.ProcID, target.SvcName, target.RunInfo.Executor, target.RunInfo.Command, target.RunInfo.Args,
-		"$SERVICE_$PROCID_$RUN.out", "$SERVICE_$PROCID_$RUN.err", target.RunInfo.Environment, meta, utils.GetDataDir())
+		"$SERVICE_$PROCID_$RUN.out", "$SERVICE_$PROCID_$RUN.err", target.RunInfo.Environment, meta, utils.GetDataDir(), target.RunInfo.Restart, target.RunInfo.LogRotation,
+		target.RunInfo.Limits, target.RunInfo.Stop, target.RunInfo.Generation)
 	if err != nil {
 		log.Errorf("Failed to create new local process, procID: %s, error: %v", target.ProcID, err)
 		return nil, err
 	}
+	// adopt the process left running by the previous minion whatever the desired state is,
+	// it will be stopped by reconciling if it's not desired to run
+	adopted, err := proc.Adopt(endpoints)
+	if err != nil {
+		log.Errorf("Failed to adopt local process, procID: %s, error: %v", target.ProcID, err)
+		return nil, err
+	}
 	// if process's desiredstate is 'started', then start it
-	if target.DesiredState == StateStarted {
+	if !adopted && target.DesiredState == StateStarted {
 		if err := proc.Start(endpoints); err != nil {
 			log.Errorf("Failed to start local process, procID: %s, error: %v", target.ProcID, err)
 			return nil, err
@@ -82,6 +91,7 @@ func (pm *processManager) DestroyProcess(procID string) (err error) {
 	pm.rwMutex.Lock()
 	delete(pm.procs, procID)
 	pm.rwMutex.Unlock()
+	removeCgroup(procID)
 	return nil
 }
 
@@ -182,3 +192,13 @@ func (pm *processManager) FindBySvcName(svcName string) map[string]Proc {
 	}
 	return res
 }
+
+// Release detaches all local processes without stopping them, so that a new process manager can adopt them
+func (pm *processManager) Release() {
+	pm.rwMutex.Lock()
+	defer pm.rwMutex.Unlock()
+	for _, proc := range pm.procs {
+		proc.Release()
+	}
+	pm.procs = make(map[string]Proc)
+}
@@ -24,9 +24,16 @@ type Proc interface {
 	Active() ProcRun
 	ProcRuns() []ProcRun
 	NumOfProcRuns() int
+	History(int) []ProcessRunRecord
 	State() ProcessState
+	RestartStatus() RestartStatus
+	ResourceUsage() (ResourceUsage, error)
 	Start(map[string]string) error
+	Adopt(map[string]string) (bool, error)
 	Stop() error
+	Release()
+	LogFile(int, string) (string, error)
+	RunGeneration() int64
 }
 
 type ProcRun interface {
@@ -35,23 +42,40 @@ type ProcRun interface {
 	Kill() error
 	WaitingStopped()
 	WaitingStoppedInMillisecond(time.Duration) bool
+	ExitStatus() syscall.WaitStatus
+	Uptime() time.Duration
+	Record() ProcessRunRecord
+	SetStopStage(StopStage)
 }
 
 type Process struct {
-	ProcID      string
-	SvcName     string
-	Executor    []string
-	Command     string
-	Args        []string
-	StdoutFile  string
-	StderrFile  string
-	Environment map[string]string
-	Metadata    map[string]string
-	Pwd         string
-	procRuns    []ProcRun
-	active      ProcRun
-	state       ProcessState // current run state assigned by process manager
-	rwMutex     sync.RWMutex // guard of active
+	ProcID       string
+	SvcName      string
+	Executor     []string
+	Command      string
+	Args         []string
+	StdoutFile   string
+	StderrFile   string
+	Environment  map[string]string
+	Metadata     map[string]string
+	Pwd          string
+	Restart      RestartSpec
+	LogRotation  LogRotation
+	Limits       ResourceLimits
+	StopSpec     StopSpec
+	Generation   int64 // the generation of run spec which the process is created with
+	procRuns     []ProcRun
+	active       ProcRun
+	state        ProcessState // current run state assigned by process manager
+	endpoints    map[string]string
+	stopping     bool // the active run is being stopped on purpose, never restart it
+	failures     int  // the number of consecutive restarts, reset after a stable run
+	restart      RestartStatus
+	restartTimer *time.Timer
+	released     bool         // detached from the process manager, left to be adopted by a new one
+	runBase      int          // the ID of the first run held, non-zero if the process was adopted
+	runGen       int64        // the generation of run spec which the active run is started with
+	rwMutex      sync.RWMutex // guard of active
 }
 
 type ProcessRun struct {
@@ -72,6 +96,8 @@ type ProcessRun struct {
 	WaitStatus  syscall.WaitStatus
 	Pwd         string
 	Stopc       chan struct{}
+	stopStage   StopStage
+	stageMutex  sync.Mutex // guard of stopStage, which is set after the run exited
 }
 
 func (pr *ProcessRun) String() string {
@@ -112,7 +138,8 @@ type Event struct {
 }
 
 func NewProcess(procID string, svcName string, executor []string, command string, args []string, stdoutFile string,
-	stderrFile string, environment map[string]string, metadata map[string]string, pwd string) (Proc, error) {
+	stderrFile string, environment map[string]string, metadata map[string]string, pwd string, restart RestartSpec,
+	logRotation LogRotation, limits ResourceLimits, stop StopSpec, generation int64) (Proc, error) {
 	var root = utils.GetRootDir()
 	var cmd = filepath.Join(root, command)
 	if _, err := utils.CheckFileExist(cmd); err != nil {
@@ -150,6 +177,11 @@ func NewProcess(procID string, svcName string, executor []string, command string
 		Environment: environment,
 		Metadata:    metadata,
 		Pwd:         pwd,
+		Restart:     restart,
+		LogRotation: logRotation,
+		Limits:      limits,
+		StopSpec:    stop,
+		Generation:  generation,
 		procRuns:    make([]ProcRun, 0),
 		state:       StateStopped,
 	}, nil
@@ -199,63 +231,164 @@ func (p *Process) setState(state ProcessState) {
 	p.state = state
 }
 
+// RunGeneration returns the generation of run spec which the running process is started with,
+// it's older than the generation of process only if the process adopted is started by an older spec
+func (p *Process) RunGeneration() int64 {
+	p.rwMutex.RLock()
+	defer p.rwMutex.RUnlock()
+	if p.active == nil {
+		return p.Generation
+	}
+	return p.runGen
+}
+
+func (p *Process) RestartStatus() RestartStatus {
+	p.rwMutex.RLock()
+	defer p.rwMutex.RUnlock()
+	return p.restart
+}
+
 func (p *Process) Start(endpoints map[string]string) error {
 	if p.IsActive() {
 		return errors.New("Process maybe already started")
 	}
+	// started on purpose, forget the restarting history
+	p.rwMutex.Lock()
+	p.cancelRestart()
+	p.endpoints = endpoints
+	p.failures = 0
+	p.restart = RestartStatus{}
+	p.rwMutex.Unlock()
+
 	pr := p.NewProcessRun(endpoints)
 	if err := pr.Start(); err != nil {
 		return err
 	}
-	p.SetActive(pr)
-	go func() {
-		pr.WaitingStopped()
-		p.SetInactive()
-	}()
-	p.setState(StateStarted)
+	p.rwMutex.Lock()
+	p.active = pr
+	p.runGen = p.Generation
+	p.state = StateStarted
+	p.stopping = false
+	p.rwMutex.Unlock()
+	p.limitRun(pr)
+	p.saveRun(pr)
+	go p.watch(pr)
+	go p.rotateLogs(pr)
 	return nil
 }
 
+// watch waits for the run exited, and restarts the process according to the restart policy
+func (p *Process) watch(pr ProcRun) {
+	pr.WaitingStopped()
+	p.SetInactive()
+	removeRunState(p.Pwd, p.ProcID, pr.Record().PID)
+	ws := pr.ExitStatus()
+	if ws.Signaled() && ws.Signal() == syscall.SIGKILL && p.Limits.MemoryLimit > 0 {
+		log.Warnf("Process was killed by SIGKILL, maybe for exceeding the memory limit of %d bytes, procID: %s",
+			p.Limits.MemoryLimit, p.ProcID)
+	}
+	p.onExit(exitCodeOf(ws), ws.Signaled() || ws.ExitStatus() != 0, pr.Uptime())
+}
+
+func (p *Process) onExit(exitCode int, failed bool, uptime time.Duration) {
+	p.rwMutex.Lock()
+	defer p.rwMutex.Unlock()
+	if p.state != StateStarted || p.stopping || p.released {
+		// stopped on purpose, or no longer managed by us
+		return
+	}
+	p.restart.LastExitCode = exitCode
+	p.restart.LastExitTime = time.Now()
+	if !p.Restart.shouldRestart(failed) {
+		log.Warnf("Process exited and will not be restarted, procID: %s, exit code: %d, restart policy: %s",
+			p.ProcID, exitCode, p.Restart.Policy)
+		return
+	}
+	if uptime >= stableRunDuration {
+		p.failures = 0
+	}
+	if p.Restart.MaxRetries > 0 && p.failures >= p.Restart.MaxRetries {
+		p.restart.CrashLoop = true
+		log.Errorf("Process is crash looping, give up restarting after %d retries, procID: %s, exit code: %d",
+			p.failures, p.ProcID, exitCode)
+		return
+	}
+	backoff := restartBackoff(p.failures)
+	p.failures++
+	p.restart.NextRestart = time.Now().Add(backoff)
+	p.restartTimer = time.AfterFunc(backoff, p.restartRun)
+	log.Warnf("Process exited, restart it after %v, procID: %s, exit code: %d", backoff, p.ProcID, exitCode)
+}
+
+// restartRun starts a new run of process with the endpoints of the last one
+func (p *Process) restartRun() {
+	p.rwMutex.Lock()
+	p.restartTimer = nil
+	p.restart.NextRestart = time.Time{}
+	if p.state != StateStarted || p.stopping || p.released || p.active != nil {
+		p.rwMutex.Unlock()
+		return
+	}
+	endpoints := p.endpoints
+	p.rwMutex.Unlock()
+
+	pr := p.NewProcessRun(endpoints)
+	if err := pr.Start(); err != nil {
+		log.Errorf("Failed to restart process, procID: %s, error: %v", p.ProcID, err)
+		p.onExit(-1, true, 0)
+		return
+	}
+	p.rwMutex.Lock()
+	if p.state != StateStarted || p.stopping || p.released {
+		// stopped while restarting
+		p.rwMutex.Unlock()
+		pr.Kill()
+		return
+	}
+	p.active = pr
+	p.runGen = p.Generation
+	p.restart.Restarts++
+	p.rwMutex.Unlock()
+	p.limitRun(pr)
+	p.saveRun(pr)
+	go p.watch(pr)
+	go p.rotateLogs(pr)
+}
+
+// cancelRestart cancels the pending restart, the caller should hold the lock
+func (p *Process) cancelRestart() {
+	if p.restartTimer != nil {
+		p.restartTimer.Stop()
+		p.restartTimer = nil
+	}
+	p.restart.NextRestart = time.Time{}
+}
+
 func (p *Process) Stop() error {
-	active := p.Active()
+	p.rwMutex.Lock()
+	p.cancelRestart()
+	active := p.active
 	if active == nil {
+		p.state = StateStopped
+		p.rwMutex.Unlock()
 		log.Warn("Process is already dead, no need to kill")
-		p.setState(StateStopped)
 		return nil
 	}
+	p.stopping = true
+	p.rwMutex.Unlock()
+	defer func() {
+		p.rwMutex.Lock()
+		p.stopping = false
+		p.rwMutex.Unlock()
+	}()
 
-	if err := active.Signal(syscall.SIGINT); err != nil {
-		log.Errorf("Send SIGINT to process unsuccessful, error: %v, procinfo: %v", err, active)
-		return err
-	} else {
-		if isStopped := active.WaitingStoppedInMillisecond(5000); isStopped {
-			log.Debugf("Process terminated after SIGINT, procinfo: %v", active)
-			p.setState(StateStopped)
-			return nil // means process finished
-		}
-	}
-	if err := active.Signal(syscall.SIGTERM); err != nil {
-		log.Errorf("Send SIGTERM to process unsuccessful, error: %v, procinfo: %v", err, active)
-		return err
-	} else {
-		if isStopped := active.WaitingStoppedInMillisecond(5000); isStopped {
-			log.Debugf("Process terminated after SIGTERM, procinfo: %v", active)
-			p.setState(StateStopped)
-			return nil // means process finished
-		}
-	}
-	if err := active.Kill(); err != nil {
-		log.Errorf("Send SIGKILL to process unsuccessful, error: %v, procinfo: %v", err, active)
+	stage, err := p.stopRun(active)
+	if err != nil {
 		return err
-	} else {
-		if isStopped := active.WaitingStoppedInMillisecond(1000); isStopped {
-			log.Debugf("Process terminated after SIGKILL, procinfo: %v", active)
-			p.setState(StateStopped)
-			return nil // means process finished
-		}
 	}
-	log.Errorf("Terminate process unsuccessful, process: %v", active)
-	return errors.New("Failed to stop process")
+	active.SetStopStage(stage)
+	p.setState(StateStopped)
+	return nil
 }
 
 func (p *Process) ProcRuns() []ProcRun {
@@ -270,6 +403,19 @@ func (p *Process) NumOfProcRuns() int {
 	return len(p.procRuns)
 }
 
+// History returns the records of the last n runs at most, the oldest first
+func (p *Process) History(n int) []ProcessRunRecord {
+	runs := p.ProcRuns()
+	if len(runs) > n {
+		runs = runs[len(runs)-n:]
+	}
+	records := make([]ProcessRunRecord, 0, len(runs))
+	for _, pr := range runs {
+		records = append(records, pr.Record())
+	}
+	return records
+}
+
 func (p *Process) HoldProcRun(pr ProcRun) {
 	p.rwMutex.Lock()
 	defer p.rwMutex.Unlock()
@@ -277,24 +423,11 @@ func (p *Process) HoldProcRun(pr ProcRun) {
 }
 
 func (p *Process) NewProcessRun(endpoints map[string]string) ProcRun {
-	run := p.NumOfProcRuns()
-	vars := map[string]string{
-		"PROCID": p.ProcID,
-		"RUN":    strconv.Itoa(run),
-	}
-	vars = AddDefaultVars(vars)
-	if len(p.Pwd) > 0 {
-		vars["PWD"] = p.Pwd
-	}
-	for k, v := range p.Environment {
-		vars[k] = v
-	}
-	for k, v := range p.Metadata {
-		vars[k] = v
-	}
-	for k, v := range endpoints {
-		vars[k] = v
-	}
+	p.rwMutex.RLock()
+	run := p.runBase + len(p.procRuns)
+	p.rwMutex.RUnlock()
+	vars := p.runVars(run, endpoints)
+	p.pruneRunLogs(run)
 
 	c := make([]string, 0)
 	if len(p.Executor) > 0 {
@@ -325,22 +458,32 @@ func (p *Process) NewProcessRun(endpoints map[string]string) ProcRun {
 	return pr
 }
 
+// runVars returns the variables to be replaced in the commandline and the log files of the run
+func (p *Process) runVars(run int, endpoints map[string]string) map[string]string {
+	vars := map[string]string{
+		"PROCID": p.ProcID,
+		"RUN":    strconv.Itoa(run),
+	}
+	vars = AddDefaultVars(vars)
+	if len(p.Pwd) > 0 {
+		vars["PWD"] = p.Pwd
+	}
+	for k, v := range p.Environment {
+		vars[k] = v
+	}
+	for k, v := range p.Metadata {
+		vars[k] = v
+	}
+	for k, v := range endpoints {
+		vars[k] = v
+	}
+	return vars
+}
+
 func (pr *ProcessRun) Start() error {
 	pr.Started = time.Now()
 	pr.Stopc = make(chan struct{})
 
-	stdout, err := pr.Cmd.StdoutPipe()
-	if err != nil {
-		pr.Error = err
-		close(pr.Stopc)
-		return err
-	}
-	stderr, err := pr.Cmd.StderrPipe()
-	if err != nil {
-		pr.Error = err
-		close(pr.Stopc)
-		return err
-	}
 	if len(pr.StdoutFile) > 0 {
 		wr, err := NewFileLogWriter(pr.StdoutFile)
 		if err != nil {
@@ -363,6 +506,27 @@ func (pr *ProcessRun) Start() error {
 	} else {
 		pr.StderrBuf = NewInMemoryLogWriter()
 	}
+	stdout, stdoutPipe, err := outputOf(pr.StdoutBuf)
+	if err != nil {
+		pr.Error = err
+		pr.StdoutBuf.Close()
+		pr.StderrBuf.Close()
+		close(pr.Stopc)
+		return err
+	}
+	stderr, stderrPipe, err := outputOf(pr.StderrBuf)
+	if err != nil {
+		pr.Error = err
+		closePipe(stdout, stdoutPipe)
+		pr.StdoutBuf.Close()
+		pr.StderrBuf.Close()
+		close(pr.Stopc)
+		return err
+	}
+	pr.Cmd.Stdout = stdout
+	pr.Cmd.Stderr = stderr
+	// start the process in its own process group, so that the signals to the minion are not delivered to it
+	pr.Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	if len(pr.Pwd) > 0 {
 		pr.Cmd.Dir = pr.Pwd
 	}
@@ -371,19 +535,24 @@ func (pr *ProcessRun) Start() error {
 	}
 
 	err = pr.Cmd.Start()
-	if err != nil {
+	if err != nil || pr.Cmd.Process == nil {
+		if err == nil {
+			err = errors.New("Start process failed")
+		}
 		pr.Error = err
+		closePipe(stdout, stdoutPipe)
+		closePipe(stderr, stderrPipe)
 		pr.StdoutBuf.Close()
 		pr.StderrBuf.Close()
 		close(pr.Stopc)
 		return err
 	}
-	if pr.Cmd.Process == nil {
-		pr.Error = errors.New("Start process failed")
-		pr.StdoutBuf.Close()
-		pr.StderrBuf.Close()
-		close(pr.Stopc)
-		return pr.Error
+	// the write ends of pipes are inherited by the process, close ours
+	if stdoutPipe != nil {
+		stdout.Close()
+	}
+	if stderrPipe != nil {
+		stderr.Close()
 	}
 
 	ev := &Event{time.Now(), fmt.Sprintf("Process %s[%s] started successfully, commandline: %s, PID: %d",
@@ -392,17 +561,12 @@ func (pr *ProcessRun) Start() error {
 	pr.Events = append(pr.Events, ev)
 
 	go func() {
-		go func() {
-			io.Copy(pr.StdoutBuf, stdout)
-			pr.StdoutBuf.Close()
-		}()
-		go func() {
-			io.Copy(pr.StderrBuf, stderr)
-			pr.StderrBuf.Close()
-		}()
+		go copyOutput(pr.StdoutBuf, stdoutPipe)
+		go copyOutput(pr.StderrBuf, stderrPipe)
 		pr.Cmd.Wait()
 		ps := pr.Cmd.ProcessState
 		sy := ps.Sys().(syscall.WaitStatus)
+		pr.WaitStatus = sy
 		ev := &Event{time.Now(), fmt.Sprintf("Process %s[%s], PID: %d exited with status: %d", pr.SvcName, pr.ProcID, pr.Cmd.Process.Pid, sy.ExitStatus())}
 		log.Info(ev.Message)
 		pr.Events = append(pr.Events, ev)
@@ -413,6 +577,36 @@ func (pr *ProcessRun) Start() error {
 	return nil
 }
 
+// outputOf returns the file for the process to write its output to, the log file is handed to the process directly,
+// so that it keeps running after the minion exited instead of being killed by a broken pipe,
+// otherwise the output is copied to the in-memory log through a pipe, whose read end is returned too
+func outputOf(buf LogWriter) (*os.File, *os.File, error) {
+	if wr, ok := buf.(*FileLogWriter); ok {
+		return wr.file, nil, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w, r, nil
+}
+
+func closePipe(w, r *os.File) {
+	if r != nil {
+		w.Close()
+		r.Close()
+	}
+}
+
+// copyOutput copies the output of process from pipe until it exited, and closes the log writer
+func copyOutput(buf LogWriter, pipe *os.File) {
+	if pipe != nil {
+		io.Copy(buf, pipe)
+		pipe.Close()
+	}
+	buf.Close()
+}
+
 func (pr *ProcessRun) Signal(sig syscall.Signal) error {
 	if pr.Cmd == nil || pr.Cmd.Process == nil {
 		return errors.New("Process not started")
@@ -439,3 +633,51 @@ func (pr *ProcessRun) WaitingStoppedInMillisecond(timeout time.Duration) bool {
 		return false
 	}
 }
+
+func (pr *ProcessRun) ExitStatus() syscall.WaitStatus {
+	return pr.WaitStatus
+}
+
+func (pr *ProcessRun) Uptime() time.Duration {
+	if pr.Stopped.IsZero() {
+		return time.Since(pr.Started)
+	}
+	return pr.Stopped.Sub(pr.Started)
+}
+
+func (pr *ProcessRun) Record() ProcessRunRecord {
+	record := ProcessRunRecord{
+		ID:          pr.ID,
+		Commandline: pr.Commandline,
+		Started:     pr.Started,
+	}
+	if pr.Cmd != nil && pr.Cmd.Process != nil {
+		record.PID = pr.Cmd.Process.Pid
+	}
+	// the exit infomation is written by the waiting goroutine, only safe to read after Stopc closed
+	select {
+	case <-pr.Stopc:
+	default:
+		return record
+	}
+	if pr.Error != nil {
+		record.Error = pr.Error.Error()
+	}
+	record.Stopped = pr.Stopped
+	if !pr.Stopped.IsZero() {
+		record.ExitCode = pr.WaitStatus.ExitStatus()
+		if pr.WaitStatus.Signaled() {
+			record.Signal = pr.WaitStatus.Signal().String()
+		}
+	}
+	pr.stageMutex.Lock()
+	record.StopStage = pr.stopStage
+	pr.stageMutex.Unlock()
+	return record
+}
+
+func (pr *ProcessRun) SetStopStage(stage StopStage) {
+	pr.stageMutex.Lock()
+	defer pr.stageMutex.Unlock()
+	pr.stopStage = stage
+}
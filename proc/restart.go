@@ -0,0 +1,99 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+type RestartPolicy string
+
+const (
+	// never restart the process after it exited, which is the default
+	RestartNever = RestartPolicy("never")
+	// restart the process only if it exited with non-zero code or was killed by signal
+	RestartOnFailure = RestartPolicy("on-failure")
+	// restart the process whenever it exited
+	RestartAlways = RestartPolicy("always")
+
+	// the delay before the first restart, doubled after each consecutive restart
+	initialRestartBackoff = time.Second
+	// the upper limit of delay between two restarts
+	maxRestartBackoff = time.Minute
+	// a run lasted longer than this is considered healthy, the backoff is reset after it
+	stableRunDuration = 5 * time.Minute
+)
+
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	switch RestartPolicy(policy) {
+	case "", RestartNever:
+		return RestartNever, nil
+	case RestartOnFailure:
+		return RestartOnFailure, nil
+	case RestartAlways:
+		return RestartAlways, nil
+	default:
+		return RestartNever, errors.New(fmt.Sprintf("Illegal restart policy: %s", policy))
+	}
+}
+
+func (p RestartPolicy) String() string {
+	if len(p) == 0 {
+		return string(RestartNever)
+	}
+	return string(p)
+}
+
+// RestartSpec tells how to deal with the exit of process which is expected to be running
+type RestartSpec struct {
+	Policy RestartPolicy
+	// the max number of consecutive restarts before giving up, 0 means no limit
+	MaxRetries int
+}
+
+// RestartStatus records the restarting history of process,
+// a process is in crash loop if it exited too many times consecutively and restarting has been given up
+type RestartStatus struct {
+	Restarts     int
+	LastExitCode int
+	LastExitTime time.Time
+	NextRestart  time.Time // zero if no restart is pending
+	CrashLoop    bool
+}
+
+func (s RestartStatus) IsBackingOff() bool {
+	return !s.NextRestart.IsZero()
+}
+
+// shouldRestart tells whether to restart after the process exited, successfully or not
+func (spec RestartSpec) shouldRestart(failed bool) bool {
+	switch spec.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failed
+	default:
+		return false
+	}
+}
+
+// restartBackoff returns the delay before the n-th consecutive restart, n starts from 0
+func restartBackoff(n int) time.Duration {
+	backoff := initialRestartBackoff
+	for i := 0; i < n && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRestartBackoff {
+		backoff = maxRestartBackoff
+	}
+	return backoff
+}
+
+// exitCodeOf returns the exit code of process, or the negative number of signal if it was killed by signal
+func exitCodeOf(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return -int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
@@ -0,0 +1,123 @@
+package proc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		n       int
+		backoff time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{5, 32 * time.Second},
+		{6, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if backoff := restartBackoff(tt.n); backoff != tt.backoff {
+			t.Errorf("restartBackoff(%d) = %v, want %v", tt.n, backoff, tt.backoff)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy RestartPolicy
+		failed bool
+		want   bool
+	}{
+		{"", true, false},
+		{RestartNever, true, false},
+		{RestartOnFailure, true, true},
+		{RestartOnFailure, false, false},
+		{RestartAlways, true, true},
+		{RestartAlways, false, true},
+	}
+	for _, tt := range tests {
+		if got := (RestartSpec{Policy: tt.policy}).shouldRestart(tt.failed); got != tt.want {
+			t.Errorf("shouldRestart of policy %q, failed %v = %v, want %v", tt.policy, tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestOnExit(t *testing.T) {
+	type exit struct {
+		failed bool
+		uptime time.Duration
+		// the backoff of restart, 0 if not restarted
+		backoff   time.Duration
+		crashLoop bool
+	}
+	tests := []struct {
+		name  string
+		spec  RestartSpec
+		exits []exit
+	}{
+		{
+			"never restarted",
+			RestartSpec{Policy: RestartNever},
+			[]exit{{true, 0, 0, false}},
+		},
+		{
+			"not restarted on success",
+			RestartSpec{Policy: RestartOnFailure},
+			[]exit{{false, 0, 0, false}, {true, 0, time.Second, false}},
+		},
+		{
+			"backoff doubled until crash loop",
+			RestartSpec{Policy: RestartOnFailure, MaxRetries: 3},
+			[]exit{
+				{true, 0, time.Second, false},
+				{true, 0, 2 * time.Second, false},
+				{true, 0, 4 * time.Second, false},
+				{true, 0, 0, true},
+			},
+		},
+		{
+			"backoff reset after a stable run",
+			RestartSpec{Policy: RestartAlways, MaxRetries: 2},
+			[]exit{
+				{false, 0, time.Second, false},
+				{false, time.Second, 2 * time.Second, false},
+				{false, stableRunDuration, time.Second, false},
+				{false, 0, 2 * time.Second, false},
+				{false, 0, 0, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		p := &Process{ProcID: "10000", Restart: tt.spec, state: StateStarted}
+		for i, e := range tt.exits {
+			before := time.Now()
+			p.onExit(1, e.failed, e.uptime)
+			p.rwMutex.Lock()
+			status := p.restart
+			// never run the restart in test
+			p.cancelRestart()
+			p.rwMutex.Unlock()
+
+			if status.CrashLoop != e.crashLoop {
+				t.Errorf("%s: exit %d, crash loop %v, want %v", tt.name, i, status.CrashLoop, e.crashLoop)
+			}
+			if e.backoff == 0 {
+				if status.IsBackingOff() {
+					t.Errorf("%s: exit %d, restart pending at %v, want not restarted", tt.name, i, status.NextRestart)
+				}
+				continue
+			}
+			if backoff := status.NextRestart.Sub(before); backoff < e.backoff || backoff > e.backoff+time.Second {
+				t.Errorf("%s: exit %d, restart after %v, want %v", tt.name, i, backoff, e.backoff)
+			}
+		}
+	}
+
+	// the exit of process stopped on purpose is ignored
+	p := &Process{ProcID: "10000", Restart: RestartSpec{Policy: RestartAlways}, state: StateStarted, stopping: true}
+	p.onExit(1, true, 0)
+	if p.restart.IsBackingOff() || !p.restart.LastExitTime.IsZero() {
+		t.Errorf("Process stopped on purpose is restarted, %+v", p.restart)
+	}
+}
@@ -0,0 +1,171 @@
+package proc
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ngaut/log"
+)
+
+const (
+	// how often to check whether the log files of a running process should be rotated
+	logRotateInterval = 10 * time.Second
+	// the suffix of rotated log files is the time of rotation, which sorts the files from the oldest
+	rotatedLogTimeFormat = "20060102-150405.000"
+)
+
+// LogRotation tells how to rotate the log files of process and how many of them to keep,
+// the zero value never rotates nor removes any log file
+type LogRotation struct {
+	// rotate the log file once it grows larger than this in bytes, 0 means no limit
+	MaxSize int64
+	// rotate the log file once it has been written for this long, 0 means no limit
+	MaxAge time.Duration
+	// the max number of rotated files kept for each log file, 0 means keep all
+	MaxFiles int
+	// gzip the rotated files
+	Compress bool
+	// the max number of runs whose log files are kept, including the running one, 0 means keep all
+	MaxRuns int
+}
+
+func (lr LogRotation) IsZero() bool {
+	return lr == LogRotation{}
+}
+
+func (lr LogRotation) enabled() bool {
+	return lr.MaxSize > 0 || lr.MaxAge > 0
+}
+
+// rotateLogs checks the log files of the run periodically and rotates them until the run exited
+func (p *Process) rotateLogs(pr ProcRun) {
+	if !p.LogRotation.enabled() {
+		return
+	}
+	record := pr.Record()
+	since := map[string]time.Time{
+		LogStdout: record.Started,
+		LogStderr: record.Started,
+	}
+	for !pr.WaitingStoppedInMillisecond(logRotateInterval/time.Millisecond) && !p.isReleased() {
+		for stream := range since {
+			filename, err := p.LogFile(record.ID, stream)
+			if err != nil {
+				continue
+			}
+			fi, err := os.Stat(filename)
+			if err != nil || fi.Size() == 0 {
+				continue
+			}
+			now := time.Now()
+			if (p.LogRotation.MaxSize > 0 && fi.Size() >= p.LogRotation.MaxSize) ||
+				(p.LogRotation.MaxAge > 0 && now.Sub(since[stream]) >= p.LogRotation.MaxAge) {
+				if err := rotateLog(filename, p.LogRotation, now); err != nil {
+					log.Warnf("Failed to rotate log file of process, procID: %s, %s, %v", p.ProcID, filename, err)
+					continue
+				}
+				since[stream] = now
+			}
+		}
+	}
+}
+
+// rotateLog copies the log file aside and truncates it, since the file is written by the process directly
+// and can't be reopened, the content written between copying and truncating is lost
+func rotateLog(filename string, spec LogRotation, now time.Time) error {
+	rotated := fmt.Sprintf("%s.%s", filename, now.Format(rotatedLogTimeFormat))
+	if err := copyLog(filename, rotated, false); err != nil {
+		os.Remove(rotated)
+		return err
+	}
+	if err := os.Truncate(filename, 0); err != nil {
+		return err
+	}
+	if spec.Compress {
+		if err := copyLog(rotated, rotated+".gz", true); err != nil {
+			os.Remove(rotated + ".gz")
+			log.Warnf("Failed to compress rotated log file, %s, %v", rotated, err)
+		} else {
+			os.Remove(rotated)
+		}
+	}
+	if spec.MaxFiles > 0 {
+		files := rotatedLogs(filename)
+		for len(files) > spec.MaxFiles {
+			if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+				log.Warnf("Failed to remove rotated log file, %s, %v", files[0], err)
+			}
+			files = files[1:]
+		}
+	}
+	return nil
+}
+
+func copyLog(src, dst string, compress bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser = out
+	if compress {
+		w = gzip.NewWriter(out)
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		return err
+	}
+	if compress {
+		if err := w.Close(); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// rotatedLogs returns the rotated files of the log file, the oldest first
+func rotatedLogs(filename string) []string {
+	files, err := filepath.Glob(filename + ".*")
+	if err != nil {
+		return nil
+	}
+	sort.Strings(files)
+	return files
+}
+
+// pruneRunLogs removes the log files of the runs out of retention, before the run is started
+func (p *Process) pruneRunLogs(run int) {
+	if p.LogRotation.MaxRuns <= 0 {
+		return
+	}
+	// the older runs have been pruned when the former runs started, stop at the first run without any log
+	for r := run - p.LogRotation.MaxRuns; r >= 0; r-- {
+		removed := false
+		for _, stream := range []string{LogStdout, LogStderr} {
+			filename, err := p.LogFile(r, stream)
+			if err != nil {
+				continue
+			}
+			for _, file := range append(rotatedLogs(filename), filename) {
+				if err := os.Remove(file); err == nil {
+					removed = true
+				} else if !os.IsNotExist(err) {
+					log.Warnf("Failed to remove log file of old run, procID: %s, %s, %v", p.ProcID, file, err)
+				}
+			}
+		}
+		if !removed {
+			break
+		}
+	}
+}
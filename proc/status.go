@@ -1,6 +1,12 @@
 package proc
 
-import "github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+)
 
 type ProcessState string
 
@@ -21,14 +27,40 @@ func (s ProcessState) Opposite() ProcessState {
 	}
 }
 
+// HealthState is the result of health probes of process, which is unknown if the process is not running,
+// not probed yet, or its service has no health probe
+type HealthState string
+
+const (
+	HealthUnknown   = HealthState("unknown")
+	HealthHealthy   = HealthState("healthy")
+	HealthUnhealthy = HealthState("unhealthy")
+)
+
+func (s HealthState) String() string {
+	return string(s)
+}
+
+func ParseHealthState(state string) (HealthState, error) {
+	switch HealthState(state) {
+	case HealthUnknown, HealthHealthy, HealthUnhealthy:
+		return HealthState(state), nil
+	default:
+		return HealthUnknown, errors.New(fmt.Sprintf("Illegal health state: %s", state))
+	}
+}
+
 type ProcessStatus struct {
-	ProcID       string
-	SvcName      string
-	MachID       string
-	DesiredState ProcessState
-	CurrentState ProcessState
-	IsAlive      bool
-	RunInfo      ProcessRunInfo
+	ProcID        string
+	SvcName       string
+	MachID        string
+	DesiredState  ProcessState
+	CurrentState  ProcessState
+	IsAlive       bool
+	Health        HealthState
+	RestartStatus RestartStatus
+	ResourceUsage ResourceUsage
+	RunInfo       ProcessRunInfo
 }
 
 type ProcessRunInfo struct {
@@ -36,9 +68,28 @@ type ProcessRunInfo struct {
 	HostName    string
 	HostRegion  string
 	HostIDC     string
+	Version     string // the version of service the process runs
+	Generation  int64  // increased every time the run spec of process is updated
 	Executor    []string
 	Command     string
 	Args        []string
 	Environment map[string]string
 	Endpoints   map[string]utils.Endpoint
+	Restart     RestartSpec
+	LogRotation LogRotation
+	Limits      ResourceLimits
+	Stop        StopSpec
+}
+
+// ProcessRunRecord is the summary of a run of process, which outlives the minion
+type ProcessRunRecord struct {
+	ID          int
+	PID         int
+	Commandline string
+	Started     time.Time
+	Stopped     time.Time // zero if still running
+	ExitCode    int
+	Signal      string    // the signal killed the process, if any
+	StopStage   StopStage // the stage of stop sequence in which the process exited, if stopped on purpose
+	Error       string
 }
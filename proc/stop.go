@@ -0,0 +1,164 @@
+package proc
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+const (
+	defaultStopSignal  = syscall.SIGINT
+	defaultGracePeriod = 10 * time.Second
+	defaultHookTimeout = 5 * time.Second
+	// how long to wait for the process exiting after SIGKILL
+	killTimeout = time.Second
+)
+
+// StopStage is the stage of stop sequence in which the process exited
+type StopStage string
+
+const (
+	// the process exited while the pre-stop hook running
+	StopStagePreStop = StopStage("pre-stop")
+	// the process exited after the stop signal, within the grace period
+	StopStageSignal = StopStage("signal")
+	// the process was killed after the grace period
+	StopStageKill = StopStage("kill")
+)
+
+func (s StopStage) String() string {
+	return string(s)
+}
+
+// StopHook is called before the process is signaled, to let it leave the cluster gracefully,
+// either an HTTP GET or a command, the variables of process can be referred in both
+type StopHook struct {
+	// the URL to GET, any status in [200, 400) means succeeded
+	HTTPGet string
+	// the command to run on the machine, exit code 0 means succeeded
+	Command []string
+	// 0 means the default timeout, which is spent before the grace period and not counted in it
+	Timeout time.Duration
+}
+
+// StopSpec tells how to stop the process gracefully, the zero value sends SIGINT
+// and kills the process if it's still running after 10 seconds
+type StopSpec struct {
+	Signal syscall.Signal
+	// how long to wait for the process exiting after the stop signal before killed,
+	// the signal is sent once the pre-stop hook returns, whose time is not counted in
+	GracePeriod time.Duration
+	PreStop     *StopHook
+}
+
+func (ss StopSpec) IsZero() bool {
+	return ss.Signal == 0 && ss.GracePeriod == 0 && ss.PreStop == nil
+}
+
+func (ss StopSpec) signal() syscall.Signal {
+	if ss.Signal == 0 {
+		return defaultStopSignal
+	}
+	return ss.Signal
+}
+
+func (ss StopSpec) gracePeriod() time.Duration {
+	if ss.GracePeriod <= 0 {
+		return defaultGracePeriod
+	}
+	return ss.GracePeriod
+}
+
+// call runs the hook with the variables of process, and gives up after its timeout
+func (h *StopHook) call(vars map[string]string) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	if len(h.HTTPGet) > 0 {
+		client := &http.Client{
+			Timeout: timeout,
+		}
+		res, err := client.Get(ReplaceVars(h.HTTPGet, vars))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+			return errors.New(fmt.Sprintf("Unexpected status of pre-stop hook: %s", res.Status))
+		}
+		return nil
+	}
+	if len(h.Command) == 0 {
+		return errors.New("Neither URL nor command of pre-stop hook given")
+	}
+	args := make([]string, 0, len(h.Command))
+	for _, arg := range h.Command {
+		args = append(args, ReplaceVars(arg, vars))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		return errors.New(fmt.Sprintf("Pre-stop hook timed out after %v: %s", timeout, strings.Join(args, " ")))
+	}
+}
+
+// stopRun stops the run by the stop spec of process, returns the stage in which the run exited.
+// The grace period starts once the pre-stop hook returns, so a slow hook never shortens the wait after the signal
+func (p *Process) stopRun(pr ProcRun) (StopStage, error) {
+	spec := p.StopSpec
+	grace := spec.gracePeriod()
+
+	if spec.PreStop != nil {
+		p.rwMutex.RLock()
+		vars := p.runVars(pr.Record().ID, p.endpoints)
+		p.rwMutex.RUnlock()
+		if err := spec.PreStop.call(vars); err != nil {
+			// the process is stopped anyway
+			log.Warnf("Pre-stop hook of process failed, procID: %s, %v", p.ProcID, err)
+		}
+		if !pr.Record().Stopped.IsZero() {
+			log.Debugf("Process terminated after pre-stop hook, procinfo: %v", pr)
+			return StopStagePreStop, nil
+		}
+	}
+
+	sig := spec.signal()
+	if err := pr.Signal(sig); err != nil {
+		log.Errorf("Send %v to process unsuccessful, error: %v, procinfo: %v", sig, err, pr)
+		return "", err
+	}
+	if pr.WaitingStoppedInMillisecond(grace / time.Millisecond) {
+		log.Debugf("Process terminated after %v, procinfo: %v", sig, pr)
+		return StopStageSignal, nil
+	}
+
+	log.Warnf("Process is still running after the grace period of %v, kill it, procID: %s", grace, p.ProcID)
+	if err := pr.Kill(); err != nil {
+		log.Errorf("Send SIGKILL to process unsuccessful, error: %v, procinfo: %v", err, pr)
+		return "", err
+	}
+	if pr.WaitingStoppedInMillisecond(killTimeout / time.Millisecond) {
+		log.Debugf("Process terminated after SIGKILL, procinfo: %v", pr)
+		return StopStageKill, nil
+	}
+	log.Errorf("Terminate process unsuccessful, process: %v", pr)
+	return "", errors.New("Failed to stop process")
+}
@@ -0,0 +1,154 @@
+package proc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeRun is a run which exits on the stop signal or SIGKILL after a delay, or never if the delay is negative
+type fakeRun struct {
+	signalDelay time.Duration
+	killDelay   time.Duration
+	stopc       chan struct{}
+	once        sync.Once
+	mutex       sync.Mutex
+	signals     []syscall.Signal
+	stopped     time.Time
+}
+
+func newFakeRun(signalDelay, killDelay time.Duration) *fakeRun {
+	return &fakeRun{
+		signalDelay: signalDelay,
+		killDelay:   killDelay,
+		stopc:       make(chan struct{}),
+	}
+}
+
+func (fr *fakeRun) exit() {
+	fr.once.Do(func() {
+		fr.mutex.Lock()
+		fr.stopped = time.Now()
+		fr.mutex.Unlock()
+		close(fr.stopc)
+	})
+}
+
+func (fr *fakeRun) exitAfter(delay time.Duration) {
+	if delay < 0 {
+		return
+	}
+	time.AfterFunc(delay, fr.exit)
+}
+
+func (fr *fakeRun) Start() error {
+	return nil
+}
+
+func (fr *fakeRun) Signal(sig syscall.Signal) error {
+	fr.mutex.Lock()
+	fr.signals = append(fr.signals, sig)
+	fr.mutex.Unlock()
+	if sig == syscall.SIGKILL {
+		fr.exitAfter(fr.killDelay)
+	} else {
+		fr.exitAfter(fr.signalDelay)
+	}
+	return nil
+}
+
+func (fr *fakeRun) Kill() error {
+	return fr.Signal(syscall.SIGKILL)
+}
+
+func (fr *fakeRun) WaitingStopped() {
+	<-fr.stopc
+}
+
+func (fr *fakeRun) WaitingStoppedInMillisecond(timeout time.Duration) bool {
+	select {
+	case <-fr.stopc:
+		return true
+	case <-time.After(timeout * time.Millisecond):
+		return false
+	}
+}
+
+func (fr *fakeRun) ExitStatus() syscall.WaitStatus {
+	return 0
+}
+
+func (fr *fakeRun) Uptime() time.Duration {
+	return 0
+}
+
+func (fr *fakeRun) Record() ProcessRunRecord {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	return ProcessRunRecord{ID: 1, Stopped: fr.stopped}
+}
+
+func (fr *fakeRun) SetStopStage(StopStage) {
+}
+
+func TestStopRun(t *testing.T) {
+	var hookRun *fakeRun
+	var hookDelay time.Duration
+	var hookExits bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(hookDelay)
+		if hookExits {
+			hookRun.exit()
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		hook        bool
+		hookDelay   time.Duration
+		hookExits   bool
+		signalDelay time.Duration
+		killDelay   time.Duration
+		stage       StopStage
+		signals     []syscall.Signal
+		err         bool
+	}{
+		{"exit on signal", false, 0, false, 50 * time.Millisecond, 0, StopStageSignal, []syscall.Signal{syscall.SIGTERM}, false},
+		{"killed after grace period", false, 0, false, -1, 0, StopStageKill, []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}, false},
+		{"never exit", false, 0, false, -1, -1, "", []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}, true},
+		{"exit in pre-stop hook", true, 0, true, -1, -1, StopStagePreStop, nil, false},
+		{"exit on signal after pre-stop hook", true, 0, false, 50 * time.Millisecond, 0, StopStageSignal, []syscall.Signal{syscall.SIGTERM}, false},
+		// the slow hook doesn't shorten the grace period
+		{"exit on signal after slow pre-stop hook", true, 250 * time.Millisecond, false, 150 * time.Millisecond, 0, StopStageSignal, []syscall.Signal{syscall.SIGTERM}, false},
+	}
+	for _, tt := range tests {
+		spec := StopSpec{
+			Signal:      syscall.SIGTERM,
+			GracePeriod: 300 * time.Millisecond,
+		}
+		if tt.hook {
+			spec.PreStop = &StopHook{HTTPGet: server.URL, Timeout: time.Second}
+		}
+		p := &Process{ProcID: "10000", StopSpec: spec}
+		run := newFakeRun(tt.signalDelay, tt.killDelay)
+		hookRun, hookDelay, hookExits = run, tt.hookDelay, tt.hookExits
+
+		stage, err := p.stopRun(run)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: stopRun returns error %v, want error %v", tt.name, err, tt.err)
+		}
+		if stage != tt.stage {
+			t.Errorf("%s: stopRun returns stage %q, want %q", tt.name, stage, tt.stage)
+		}
+		run.mutex.Lock()
+		if !reflect.DeepEqual(run.signals, tt.signals) {
+			t.Errorf("%s: signals %v sent, want %v", tt.name, run.signals, tt.signals)
+		}
+		run.mutex.Unlock()
+	}
+}
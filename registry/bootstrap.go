@@ -37,6 +37,9 @@ func (r *EtcdRegistry) Bootstrap() (err error) {
 	if err = r.mustCreateNode(r.prefixed(machinePrefix), "", true); err != nil {
 		return
 	}
+	if err = r.mustCreateNode(r.prefixed(indexPrefix), "", true); err != nil {
+		return
+	}
 	if err = r.mustCreateNode(r.prefixed(jobPrefix), "", true); err != nil {
 		return
 	}
@@ -7,10 +7,17 @@ import (
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
+	"github.com/ngaut/log"
 	"golang.org/x/net/context"
 )
 
-const maxProcessID = "max-process-id"
+const (
+	maxProcessID     = "max-process-id"
+	quarantinePrefix = "quarantine"
+
+	// how long a pending process node lives if the creation is interrupted
+	pendingProcessTTL = 30 * time.Second
+)
 
 // EtcdRegistry implement the Registry interface and uses etcd as backend
 type EtcdRegistry struct {
@@ -78,6 +85,39 @@ func (r *EtcdRegistry) mustCreateNode(key, val string, isDir bool) (err error) {
 	return
 }
 
+// createPendingDir creates a directory with TTL, which will be removed by etcd automatically
+// if commitPendingDir never called on it
+func (r *EtcdRegistry) createPendingDir(key string, ttl time.Duration) (err error) {
+	opts := &etcd.SetOptions{
+		PrevExist: etcd.PrevNoExist,
+		Dir:       true,
+		TTL:       ttl,
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	_, err = r.kAPI.Set(ctx, key, "", opts)
+	return
+}
+
+// commitPendingDir clears the TTL of the directory created by createPendingDir, makes it permanent
+func (r *EtcdRegistry) commitPendingDir(key string) (err error) {
+	opts := &etcd.SetOptions{
+		PrevExist: etcd.PrevExist,
+		Dir:       true,
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	_, err = r.kAPI.Set(ctx, key, "", opts)
+	return
+}
+
+// rollbackPendingDir removes the pending directory, it's fine to fail since the directory will expire anyway
+func (r *EtcdRegistry) rollbackPendingDir(key string) {
+	if err := r.deleteNode(key, true); err != nil && !isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+		log.Warnf("Failed to rollback pending node, it will expire later, %s, %v", key, err)
+	}
+}
+
 func (r *EtcdRegistry) GenerateProcID() (string, error) {
 	for {
 		currProcID, err := r.getCurrentProcessID()
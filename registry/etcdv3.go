@@ -0,0 +1,202 @@
+package registry
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/ngaut/log"
+	"golang.org/x/net/context"
+)
+
+const (
+	EtcdV3Backend = "etcdv3"
+
+	// the default limit of operations in one transaction of etcd server
+	maxTxnOps = 128
+)
+
+// EtcdV3Registry implement the Registry interface on the etcd v3 API,
+// it keeps the same key layout as EtcdRegistry, but there is no directory in v3,
+// alive states are attached to leases and processes are created in transactions
+type EtcdV3Registry struct {
+	client     *clientv3.Client
+	keyPrefix  string
+	reqTimeout time.Duration
+	etcdAddrs  string
+	leases     map[string]clientv3.LeaseID // alive key to the lease it attached
+	leaseMutex sync.Mutex
+}
+
+func NewEtcdV3Registry(client *clientv3.Client, keyPrefix string, reqTimeout time.Duration, etcdAddrs string) Registry {
+	return &EtcdV3Registry{
+		client:     client,
+		keyPrefix:  keyPrefix,
+		reqTimeout: reqTimeout,
+		etcdAddrs:  etcdAddrs,
+		leases:     make(map[string]clientv3.LeaseID),
+	}
+}
+
+func (r *EtcdV3Registry) ctx() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.reqTimeout)
+	return ctx, cancel
+}
+
+func (r *EtcdV3Registry) prefixed(p ...string) string {
+	return path.Join(r.keyPrefix, path.Join(p...))
+}
+
+func (r *EtcdV3Registry) GetEtcdAddrs() string {
+	return r.etcdAddrs
+}
+
+func (r *EtcdV3Registry) IsBootstrapped() bool {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Get(ctx, r.prefixed(bootstrapPrefix))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(resp.Kvs) == 0 {
+		// not bootstrapped yet
+		log.Warnf("The etcd v3 registry not bootstrapped yet")
+		return false
+	}
+	return true
+}
+
+func (r *EtcdV3Registry) Bootstrap() error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	_, err := r.client.Txn(ctx).Then(
+		clientv3.OpDelete(r.prefixed(processPrefix)+"/", clientv3.WithPrefix()),
+		clientv3.OpDelete(r.prefixed(machinePrefix)+"/", clientv3.WithPrefix()),
+		clientv3.OpDelete(r.prefixed(indexPrefix)+"/", clientv3.WithPrefix()),
+		clientv3.OpDelete(r.prefixed(jobPrefix)+"/", clientv3.WithPrefix()),
+		clientv3.OpPut(r.prefixed(maxProcessID), strconv.Itoa(initialProcessID)),
+		clientv3.OpPut(r.prefixed(bootstrapPrefix), "bootstrapped"),
+	).Commit()
+	return err
+}
+
+func (r *EtcdV3Registry) GenerateProcID() (string, error) {
+	key := r.prefixed(maxProcessID)
+	for {
+		ctx, cancel := r.ctx()
+		resp, err := r.client.Get(ctx, key)
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		if len(resp.Kvs) == 0 {
+			panic("Max-Process-ID not exists in etcd, maybe not normally bootstrapped")
+		}
+		currProcID := string(resp.Kvs[0].Value)
+		id, err := strconv.Atoi(currProcID)
+		if err != nil {
+			panic(fmt.Sprintf("Illegal value of Max-Process-ID stored in etcd, %v", err))
+		}
+
+		ctx, cancel = r.ctx()
+		txnResp, err := r.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(key, strconv.Itoa(id+1))).
+			Commit()
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		if txnResp.Succeeded {
+			return currProcID, nil
+		}
+		// try failed, next loop
+	}
+}
+
+// refreshAlive keeps the alive key existing for another ttl, by renewing the lease it attached,
+// or granting a new lease if the key has not been attached to any lease or the lease is expired
+func (r *EtcdV3Registry) refreshAlive(aliveKey string, ttl time.Duration) error {
+	r.leaseMutex.Lock()
+	defer r.leaseMutex.Unlock()
+	leaseID, renewed, err := r.renewLease(aliveKey, ttl)
+	if err != nil || renewed {
+		return err
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.client.Put(ctx, aliveKey, "", clientv3.WithLease(leaseID)); err != nil {
+		delete(r.leases, aliveKey)
+		return err
+	}
+	return nil
+}
+
+// renewLease renews the lease attached to the key, or grants a new one if there is no live lease,
+// returns whether the existing lease renewed. Callers must hold the leaseMutex.
+func (r *EtcdV3Registry) renewLease(key string, ttl time.Duration) (clientv3.LeaseID, bool, error) {
+	if leaseID, ok := r.leases[key]; ok {
+		ctx, cancel := r.ctx()
+		_, err := r.client.KeepAliveOnce(ctx, leaseID)
+		cancel()
+		if err == nil {
+			return leaseID, true, nil
+		}
+		log.Debugf("Failed to renew lease of key, %s, %v", key, err)
+		delete(r.leases, key)
+	}
+
+	ctx, cancel := r.ctx()
+	defer cancel()
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	lease, err := r.client.Grant(ctx, seconds)
+	if err != nil {
+		return 0, false, err
+	}
+	r.leases[key] = lease.ID
+	return lease.ID, false, nil
+}
+
+// deleteAlive removes the alive key immediately, along with the lease it attached
+func (r *EtcdV3Registry) deleteAlive(aliveKey string) error {
+	r.leaseMutex.Lock()
+	defer r.leaseMutex.Unlock()
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if leaseID, ok := r.leases[aliveKey]; ok {
+		delete(r.leases, aliveKey)
+		if _, err := r.client.Revoke(ctx, leaseID); err == nil {
+			return nil
+		}
+	}
+	_, err := r.client.Delete(ctx, aliveKey)
+	return err
+}
+
+// groupByNode groups the keys under the dir into nodes named by the first path segment after the dir,
+// e.g. {dir}/{node}/{attr}, returns a map of node name to the map of attribute to value
+func groupByNode(dir string, kvs []*mvccpb.KeyValue) map[string]map[string]string {
+	nodes := make(map[string]map[string]string)
+	for _, kv := range kvs {
+		rel := strings.TrimPrefix(string(kv.Key), dir+"/")
+		parts := strings.SplitN(rel, "/", 2)
+		if len(parts) < 2 || len(parts[0]) == 0 {
+			continue
+		}
+		attrs, ok := nodes[parts[0]]
+		if !ok {
+			attrs = make(map[string]string)
+			nodes[parts[0]] = attrs
+		}
+		attrs[parts[1]] = string(kv.Value)
+	}
+	return nodes
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"golang.org/x/net/context"
+)
+
+// publishJob writes the event under job directory to notify all watchers,
+// failing to publish is not fatal, since watchers will catch up by the next tick
+func (r *EtcdV3Registry) publishJob(ev utils.Event) {
+	jobKey := jobKeyOfEvent(ev.Type)
+	if len(jobKey) == 0 {
+		log.Warnf("Unknown event type, no job published, %v", ev)
+		return
+	}
+	payload, err := marshal(&ev)
+	if err != nil {
+		log.Warnf("Error marshaling job event, %v, %v", ev, err)
+		return
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.client.Put(ctx, r.prefixed(jobPrefix, jobKey), payload); err != nil {
+		log.Warnf("Failed to publish job event in etcd, %v, %v", ev, err)
+	}
+}
+
+// EtcdV3EventStream watches the job directory from a revision,
+// and remembers the last revision seen, so that no event is missed between two calls of Next
+type EtcdV3EventStream struct {
+	client     *clientv3.Client
+	rootPrefix string
+	revision   int64 // the next revision to watch from, 0 means from now on
+	watchCh    clientv3.WatchChan
+	cancel     context.CancelFunc
+	pending    []utils.Event
+}
+
+func NewEtcdV3EventStream(client *clientv3.Client, keyPrefix string) utils.EventStream {
+	return &EtcdV3EventStream{
+		client:     client,
+		rootPrefix: keyPrefix,
+		pending:    make([]utils.Event, 0),
+	}
+}
+
+func (es *EtcdV3EventStream) watch() {
+	if es.cancel != nil {
+		es.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if es.revision > 0 {
+		opts = append(opts, clientv3.WithRev(es.revision))
+	}
+	es.watchCh = es.client.Watch(ctx, path.Join(es.rootPrefix, jobPrefix)+"/", opts...)
+	es.cancel = cancel
+}
+
+// Next returns a channel which will emit an Event as soon as one of interest occurs,
+// the channel is closed without any event if nothing interesting happened before timeout
+func (es *EtcdV3EventStream) Next(timeout time.Duration) chan utils.Event {
+	evchan := make(chan utils.Event, 1)
+	go func() {
+		defer close(evchan)
+		if es.watchCh == nil {
+			es.watch()
+		}
+		deadline := time.After(timeout)
+		for {
+			if len(es.pending) > 0 {
+				evchan <- es.pending[0]
+				es.pending = es.pending[1:]
+				return
+			}
+			select {
+			case <-deadline:
+				return
+			case resp, ok := <-es.watchCh:
+				if !ok {
+					// watcher is closed by client, restart watching from the last revision
+					log.Warnf("Watcher of etcd events closed, rewatch from revision %d", es.revision)
+					es.watch()
+					continue
+				}
+				if resp.CompactRevision > 0 {
+					// some events are compacted, ask for a full reconciling
+					log.Warnf("Events before revision %d have been compacted", resp.CompactRevision)
+					es.revision = resp.CompactRevision
+					es.pending = append(es.pending, utils.Event{Type: ProcessTargetStateChangeEvent})
+					es.watch()
+					continue
+				}
+				if err := resp.Err(); err != nil {
+					log.Errorf("Some failure encountered while waiting for next etcd event, %v", err)
+					es.watch()
+					// wait until timeout to avoid spinning while etcd is unavailable
+					<-deadline
+					return
+				}
+				for _, ev := range resp.Events {
+					es.revision = ev.Kv.ModRevision + 1
+					if ev.Type != clientv3.EventTypePut {
+						continue
+					}
+					if e, ok := parseJob(string(ev.Kv.Key), string(ev.Kv.Value), es.rootPrefix); ok {
+						es.pending = append(es.pending, e)
+					}
+				}
+			}
+		}
+	}()
+	return evchan
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+// processIndexOps returns the operations putting all index keys of process,
+// which are committed in the same transaction with the process keys
+func (r *EtcdV3Registry) processIndexOps(procID, machID, svcName string) []clientv3.Op {
+	ops := []clientv3.Op{}
+	for _, key := range processIndexKeys(procID, machID, svcName) {
+		ops = append(ops, clientv3.OpPut(r.prefixed(key), procID))
+	}
+	return ops
+}
+
+func (r *EtcdV3Registry) deleteProcessIndexOps(procID, machID, svcName string) []clientv3.Op {
+	ops := []clientv3.Op{}
+	for _, key := range processIndexKeys(procID, machID, svcName) {
+		ops = append(ops, clientv3.OpDelete(r.prefixed(key)))
+	}
+	return ops
+}
+
+// processesByIndex reads all processes indexed in the directory of machine or service,
+// the dangling or malformed ones are skipped. The processes are read in batches of transaction,
+// instead of one request for each
+func (r *EtcdV3Registry) processesByIndex(dir string) (map[string]*proc.ProcessStatus, error) {
+	ctx, cancel := r.ctx()
+	resp, err := r.client.Get(ctx, r.prefixed(dir)+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]clientv3.Op, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		procID := path.Base(string(kv.Key))
+		ops = append(ops, clientv3.OpGet(r.prefixed(processPrefix, procID)+"/", clientv3.WithPrefix()))
+	}
+
+	procIDToProcess := make(map[string]*proc.ProcessStatus)
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		ctx, cancel := r.ctx()
+		txnResp, err := r.client.Txn(ctx).Then(ops[:n]...).Commit()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range txnResp.Responses {
+			kvs := res.GetResponseRange().Kvs
+			for procID, attrs := range groupByNode(r.prefixed(processPrefix), kvs) {
+				status, err := processStatusFromAttrs(procID, attrs)
+				if err != nil || status == nil {
+					log.Debugf("Skip process in index, procID[%s], %v", procID, err)
+					continue
+				}
+				procIDToProcess[status.ProcID] = status
+			}
+		}
+		ops = ops[n:]
+	}
+	return procIDToProcess, nil
+}
+
+func (r *EtcdV3Registry) RebuildProcessIndex() error {
+	// read the index and processes at the same revision, so that the index keys of processes created
+	// meanwhile are not read, and never taken as dangling
+	dir := r.prefixed(processPrefix)
+	ctx, cancel := r.ctx()
+	resp, err := r.client.Txn(ctx).Then(
+		clientv3.OpGet(r.prefixed(indexPrefix)+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly()),
+		clientv3.OpGet(dir+"/", clientv3.WithPrefix()),
+	).Commit()
+	cancel()
+	if err != nil {
+		return err
+	}
+	indexKvs := resp.Responses[0].GetResponseRange().Kvs
+	procKvs := resp.Responses[1].GetResponseRange().Kvs
+
+	valid := make(map[string]bool)
+	ops := []clientv3.Op{}
+	for procID, attrs := range groupByNode(dir, procKvs) {
+		status, err := processStatusFromAttrs(procID, attrs)
+		if err != nil || status == nil {
+			continue
+		}
+		ops = append(ops, r.processIndexOps(procID, status.MachID, status.SvcName)...)
+		for _, key := range processIndexKeys(procID, status.MachID, status.SvcName) {
+			valid[r.prefixed(key)] = true
+		}
+	}
+	for _, kv := range indexKvs {
+		if !valid[string(kv.Key)] {
+			log.Infof("Delete dangling index key of process, %s", string(kv.Key))
+			ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+		}
+	}
+
+	// etcd limits the number of operations in one transaction, so commit them in batches
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		ctx, cancel := r.ctx()
+		_, err := r.client.Txn(ctx).Then(ops[:n]...).Commit()
+		cancel()
+		if err != nil {
+			e := fmt.Sprintf("Failed to rebuild index of processes, %v", err)
+			log.Error(e)
+			return errors.New(e)
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
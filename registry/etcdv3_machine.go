@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/machine"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+)
+
+func (r *EtcdV3Registry) Machine(machID string) (*machine.MachineStatus, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	dir := r.prefixed(machinePrefix, machID)
+	resp, err := r.client.Get(ctx, dir+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		// not found
+		e := fmt.Sprintf("Machine not found in etcd, machID: %s", machID)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	nodes := groupByNode(r.prefixed(machinePrefix), resp.Kvs)
+	status, err := machineStatusFromAttrs(machID, nodes[machID])
+	if err != nil || status == nil {
+		e := errors.New(fmt.Sprintf("Invalid machine node, machID[%s], error[%v]", machID, err))
+		return nil, e
+	}
+	return status, nil
+}
+
+func (r *EtcdV3Registry) Machines() (map[string]*machine.MachineStatus, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	dir := r.prefixed(machinePrefix)
+	resp, err := r.client.Get(ctx, dir+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	IDToMachine := make(map[string]*machine.MachineStatus)
+	for machID, attrs := range groupByNode(dir, resp.Kvs) {
+		status, err := machineStatusFromAttrs(machID, attrs)
+		if err != nil || status == nil {
+			e := errors.New(fmt.Sprintf("Invalid machine node, machID[%s], error[%v]", machID, err))
+			return nil, e
+		}
+		IDToMachine[machID] = status
+	}
+	return IDToMachine, nil
+}
+
+func machineStatusFromAttrs(machID string, attrs map[string]string) (*machine.MachineStatus, error) {
+	// The keys representing machine in etcd v3, the same layout as v2:
+	//   /root/machine/{machID}/object
+	//   /root/machine/{machID}/alive
+	//   /root/machine/{machID}/statistic
+	status := &machine.MachineStatus{
+		MachID: machID,
+	}
+	for key, value := range attrs {
+		switch key {
+		case "object":
+			if err := unmarshal(value, &status.MachInfo); err != nil {
+				log.Errorf("Error unmarshaling MachInfo, machID: %s, %v", machID, err)
+				return nil, err
+			}
+		case "alive":
+			status.IsAlive = true
+		case "statistic":
+			if err := unmarshal(value, &status.MachStat); err != nil {
+				log.Errorf("Error unmarshaling MachStat, machID: %s, %v", machID, err)
+				return nil, err
+			}
+		}
+	}
+	return status, nil
+}
+
+func (r *EtcdV3Registry) RegisterMachine(machID, hostName, hostRegion, hostIDC, publicIP string, apiPort int) error {
+	object, err := marshal(&machine.MachineInfo{
+		HostName:   hostName,
+		HostRegion: hostRegion,
+		HostIDC:    hostIDC,
+		PublicIP:   publicIP,
+		APIPort:    apiPort,
+	})
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling MachineInfo, %v", err)
+		log.Errorf(e)
+		return errors.New(e)
+	}
+	statistic, err := marshal(&machine.MachineStat{
+		LoadAvg:     []float64{},
+		UsageOfDisk: []machine.DiskUsage{},
+	})
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling MachineStat, %v", err)
+		log.Errorf(e)
+		return errors.New(e)
+	}
+
+	// update host infomation of the machine, and initialize the statistic if it's a new machine
+	objectKey := r.prefixed(machinePrefix, machID, "object")
+	statisticKey := r.prefixed(machinePrefix, machID, "statistic")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(statisticKey), "=", 0)).
+		Then(clientv3.OpPut(objectKey, object), clientv3.OpPut(statisticKey, statistic)).
+		Else(clientv3.OpPut(objectKey, object)).
+		Commit(); err != nil {
+		e := fmt.Sprintf("Failed to register machine in etcd, %s, %v", machID, err)
+		log.Error(e)
+		return errors.New(e)
+	}
+	r.publishJob(utils.Event{
+		Type:   MachineStateChangeEvent,
+		MachID: machID,
+	})
+	return nil
+}
+
+func (r *EtcdV3Registry) RefreshMachine(machID string, machStat machine.MachineStat, ttl time.Duration) error {
+	object, err := marshal(&machStat)
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling MachineStat, %v, %v", machStat, err)
+		log.Errorf(e)
+		return errors.New(e)
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.client.Put(ctx, r.prefixed(machinePrefix, machID, "statistic"), object); err != nil {
+		e := fmt.Sprintf("Failed to update machine statistic of machine in etcd, %s, %v", machID, err)
+		log.Error(e)
+		return errors.New(e)
+	}
+	return r.refreshAlive(r.prefixed(machinePrefix, machID, "alive"), ttl)
+}
@@ -0,0 +1,419 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+// Processes lists all processes in etcd, malformed processes are skipped
+func (r *EtcdV3Registry) Processes() (map[string]*proc.ProcessStatus, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	dir := r.prefixed(processPrefix)
+	resp, err := r.client.Get(ctx, dir+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	procIDToProcess := make(map[string]*proc.ProcessStatus)
+	for procKey, attrs := range groupByNode(dir, resp.Kvs) {
+		status, err := processStatusFromAttrs(procKey, attrs)
+		if err != nil || status == nil {
+			log.Warnf("Skip malformed process node, key[%s], error[%v]", procKey, err)
+			continue
+		}
+		procIDToProcess[status.ProcID] = status
+	}
+	return procIDToProcess, nil
+}
+
+func (r *EtcdV3Registry) Process(procID string) (*proc.ProcessStatus, error) {
+	// The keys representing a process in etcd v3, the same layout as v2:
+	//   /root/process/{procID}/record
+	//   /root/process/{procID}/desired-state
+	//   /root/process/{procID}/current-state
+	//   /root/process/{procID}/alive
+	//   /root/process/{procID}/health
+	//   /root/process/{procID}/restart-status
+	//   /root/process/{procID}/resource-usage
+	//   /root/process/{procID}/runs
+	//   /root/process/{procID}/object
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Get(ctx, r.prefixed(processPrefix, procID)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		return nil, e
+	}
+	nodes := groupByNode(r.prefixed(processPrefix), resp.Kvs)
+	return processStatusFromAttrs(procID, nodes[procID])
+}
+
+func (r *EtcdV3Registry) ProcessesOnMachine(machID string) (map[string]*proc.ProcessStatus, error) {
+	return r.processesByIndex(path.Join(indexPrefix, "machine", machID))
+}
+
+func (r *EtcdV3Registry) ProcessesOfService(svcName string) (map[string]*proc.ProcessStatus, error) {
+	return r.processesByIndex(path.Join(indexPrefix, "service", svcName))
+}
+
+func (r *EtcdV3Registry) NewProcess(machID, svcName string, runinfo *proc.ProcessRunInfo) (string, error) {
+	// generate new process ID
+	procID, err := r.GenerateProcID()
+	if err != nil {
+		e := fmt.Sprintf("Failed to generate new process ID, %v", err)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	objstr, err := marshal(runinfo)
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling RunInfo, %v, %v", runinfo, err)
+		log.Errorf(e)
+		return "", errors.New(e)
+	}
+	recstr, err := marshal(newProcessRecord(procID, machID, svcName))
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling process record, %v", err)
+		log.Errorf(e)
+		return "", errors.New(e)
+	}
+
+	// all the keys of process and its index are created in one transaction
+	recordKey := r.prefixed(processPrefix, procID, "record")
+	ops := []clientv3.Op{
+		clientv3.OpPut(recordKey, recstr),
+		clientv3.OpPut(r.prefixed(processPrefix, procID, "desired-state"), proc.StateStarted.String()),
+		clientv3.OpPut(r.prefixed(processPrefix, procID, "current-state"), proc.StateStopped.String()),
+		clientv3.OpPut(r.prefixed(processPrefix, procID, "object"), objstr),
+	}
+	ops = append(ops, r.processIndexOps(procID, machID, svcName)...)
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(recordKey), "=", 0)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		e := fmt.Sprintf("Failed to create process in etcd, %s, %v", procID, err)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	if !resp.Succeeded {
+		e := fmt.Sprintf("Failed to create process in etcd, process already exists, %s", procID)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: procID,
+		MachID: machID,
+	})
+	return procID, nil
+}
+
+func (r *EtcdV3Registry) DeleteProcess(procID string) (*proc.ProcessStatus, error) {
+	status, err := r.Process(procID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	ops := []clientv3.Op{clientv3.OpDelete(r.prefixed(processPrefix, status.ProcID)+"/", clientv3.WithPrefix())}
+	ops = append(ops, r.deleteProcessIndexOps(status.ProcID, status.MachID, status.SvcName)...)
+	if _, err := r.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return nil, err
+	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: status.ProcID,
+		MachID: status.MachID,
+	})
+	return status, nil
+}
+
+func (r *EtcdV3Registry) UpdateProcessDesiredState(procID string, state proc.ProcessState) error {
+	status, err := r.Process(procID)
+	if err != nil {
+		return err
+	}
+	desiredStateKey := r.prefixed(processPrefix, status.ProcID, "desired-state")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(desiredStateKey), ">", 0)).
+		Then(clientv3.OpPut(desiredStateKey, state.String())).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: status.ProcID,
+		MachID: status.MachID,
+	})
+	return nil
+}
+
+func (r *EtcdV3Registry) UpdateProcessRunInfo(procID string, generation int64, runinfo *proc.ProcessRunInfo) error {
+	status, err := r.Process(procID)
+	if err != nil {
+		return err
+	}
+	objectKey := r.prefixed(processPrefix, status.ProcID, "object")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Get(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+	}
+	kv := resp.Kvs[0]
+	objstr, err := marshalRunInfoOfGeneration(procID, string(kv.Value), generation, runinfo)
+	if err != nil {
+		return err
+	}
+	txnResp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(objectKey), "=", kv.ModRevision)).
+		Then(clientv3.OpPut(objectKey, objstr)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !txnResp.Succeeded {
+		return errors.New(fmt.Sprintf("Run info of process changed concurrently, procID[%s]", procID))
+	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: status.ProcID,
+		MachID: status.MachID,
+	})
+	return nil
+}
+
+func (r *EtcdV3Registry) UpdateProcessState(procID, machID, svcName string, state proc.ProcessState, isAlive bool, ttl time.Duration) error {
+	currentStateKey := r.prefixed(processPrefix, procID, "current-state")
+	// compare-and-swap the current-state of process
+	ctx, cancel := r.ctx()
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(currentStateKey), "=", state.Opposite().String())).
+		Then(clientv3.OpPut(currentStateKey, state.String())).
+		Else(clientv3.OpGet(currentStateKey)).
+		Commit()
+	cancel()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses) > 0 && len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+			// maybe process has been destroyed
+			log.Warnf("Error updating process state of procID: %s, process node is gone", procID)
+			return nil
+		}
+		log.Debugf("Process's current-state not changed in etcd, procID: %s, state: %s", procID, state.String())
+	}
+
+	// update the real alive state of process in etcd
+	aliveKey := r.prefixed(processPrefix, procID, "alive")
+	if isAlive {
+		return r.refreshAlive(aliveKey, ttl)
+	}
+	return r.deleteAlive(aliveKey)
+}
+
+func (r *EtcdV3Registry) RepairProcesses() ([]string, error) {
+	dir := r.prefixed(processPrefix)
+	ctx, cancel := r.ctx()
+	resp, err := r.client.Get(ctx, dir+"/", clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	quarantined := []string{}
+	for procKey, attrs := range groupByNode(dir, resp.Kvs) {
+		_, err := processStatusFromAttrs(procKey, attrs)
+		if err == nil {
+			continue
+		}
+		log.Warnf("Quarantine malformed process node, key[%s], error[%v]", procKey, err)
+		// move the keys of process into quarantine in one transaction, except the alive state attached to lease
+		ops := []clientv3.Op{}
+		for attr, value := range attrs {
+			if attr == "alive" {
+				continue
+			}
+			ops = append(ops, clientv3.OpPut(r.prefixed(quarantinePrefix, procKey, attr), value))
+		}
+		ops = append(ops, clientv3.OpDelete(r.prefixed(processPrefix, procKey)+"/", clientv3.WithPrefix()))
+		ctx, cancel := r.ctx()
+		_, err = r.client.Txn(ctx).Then(ops...).Commit()
+		cancel()
+		if err != nil {
+			e := fmt.Sprintf("Failed to quarantine malformed process node, %s, %v", procKey, err)
+			log.Error(e)
+			return quarantined, errors.New(e)
+		}
+		quarantined = append(quarantined, procKey)
+	}
+	return quarantined, nil
+}
+
+func (r *EtcdV3Registry) Upgrade() (int, error) {
+	dir := r.prefixed(processPrefix)
+	ctx, cancel := r.ctx()
+	resp, err := r.client.Get(ctx, dir+"/", clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	upgraded := 0
+	for procKey, attrs := range groupByNode(dir, resp.Kvs) {
+		if !isLegacyProcessKey(procKey) {
+			continue
+		}
+		procID, machID, svcName, err := parseLegacyProcessKey(procKey)
+		if err != nil {
+			// leave it to be quarantined
+			log.Warnf("Failed to upgrade process node, %s, %v", procKey, err)
+			continue
+		}
+		recstr, err := marshal(newProcessRecord(procID, machID, svcName))
+		if err != nil {
+			return upgraded, err
+		}
+		// write the new keys and remove the legacy ones in one transaction,
+		// keep the new keys untouched if they have been written somehow
+		recordKey := r.prefixed(processPrefix, procID, "record")
+		legacyOp := clientv3.OpDelete(r.prefixed(processPrefix, procKey)+"/", clientv3.WithPrefix())
+		ops := []clientv3.Op{clientv3.OpPut(recordKey, recstr)}
+		for attr, value := range legacyProcessAttrs(attrs) {
+			ops = append(ops, clientv3.OpPut(r.prefixed(processPrefix, procID, attr), value))
+		}
+		ops = append(ops, legacyOp)
+		ctx, cancel := r.ctx()
+		txnResp, err := r.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(recordKey), "=", 0)).
+			Then(ops...).
+			Else(legacyOp).
+			Commit()
+		cancel()
+		if err != nil {
+			e := fmt.Sprintf("Failed to upgrade process node, %s, %v", procKey, err)
+			log.Error(e)
+			return upgraded, errors.New(e)
+		}
+		if !txnResp.Succeeded {
+			log.Infof("Process node has been upgraded, the legacy one removed, %s", procKey)
+			continue
+		}
+		log.Infof("Process node upgraded, %s", procKey)
+		upgraded++
+	}
+	return upgraded, nil
+}
+
+func (r *EtcdV3Registry) UpdateProcessRestartStatus(procID string, status proc.RestartStatus) error {
+	value, err := marshal(&status)
+	if err != nil {
+		return err
+	}
+	return r.setProcessAttr(procID, "restart-status", value)
+}
+
+func (r *EtcdV3Registry) UpdateProcessHealth(procID string, health proc.HealthState, ttl time.Duration) error {
+	healthKey := r.prefixed(processPrefix, procID, "health")
+	r.leaseMutex.Lock()
+	defer r.leaseMutex.Unlock()
+	leaseID, _, err := r.renewLease(healthKey, ttl)
+	if err != nil {
+		return err
+	}
+	// the health is written along with the lease, unless the process has been destroyed
+	recordKey := r.prefixed(processPrefix, procID, "record")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(recordKey), ">", 0)).
+		Then(clientv3.OpPut(healthKey, health.String(), clientv3.WithLease(leaseID))).
+		Commit()
+	if err != nil {
+		delete(r.leases, healthKey)
+		return err
+	}
+	if !resp.Succeeded {
+		log.Warnf("Error updating health of procID: %s, process node is gone", procID)
+	}
+	return nil
+}
+
+func (r *EtcdV3Registry) UpdateProcessResourceUsage(procID string, usage proc.ResourceUsage) error {
+	value, err := marshal(&usage)
+	if err != nil {
+		return err
+	}
+	return r.setProcessAttr(procID, "resource-usage", value)
+}
+
+func (r *EtcdV3Registry) UpdateProcessRuns(procID string, runs []proc.ProcessRunRecord) error {
+	value, err := marshal(&runs)
+	if err != nil {
+		return err
+	}
+	return r.setProcessAttr(procID, "runs", value)
+}
+
+func (r *EtcdV3Registry) ProcessRuns(procID string) ([]proc.ProcessRunRecord, error) {
+	if _, err := r.Process(procID); err != nil {
+		return nil, err
+	}
+	runs := []proc.ProcessRunRecord{}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Get(ctx, r.prefixed(processPrefix, procID, "runs"))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		// never run yet
+		return runs, nil
+	}
+	if err := unmarshal(string(resp.Kvs[0].Value), &runs); err != nil {
+		log.Errorf("Error unmarshaling runs of process, procID: %s, %v", procID, err)
+		return nil, err
+	}
+	return runs, nil
+}
+
+// setProcessAttr writes an optional attribute of process only if the process exists
+func (r *EtcdV3Registry) setProcessAttr(procID, attr, value string) error {
+	recordKey := r.prefixed(processPrefix, procID, "record")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(recordKey), ">", 0)).
+		Then(clientv3.OpPut(r.prefixed(processPrefix, procID, attr), value)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		log.Warnf("Error updating %s of procID: %s, process node is gone", attr, procID)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+)
+
+// fakeKV keeps the keys in memory, and serves the gets by prefix and the transactions
+// comparing whether a key exists, which are all the upgrade of registry calls
+type fakeKV struct {
+	clientv3.KV
+	t    *testing.T
+	keys map[string]string
+}
+
+func (kv *fakeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	resp := &clientv3.GetResponse{}
+	for _, k := range kv.matched(op) {
+		resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(kv.keys[k])})
+	}
+	return resp, nil
+}
+
+// matched returns the keys in the range of op, sorted
+func (kv *fakeKV) matched(op clientv3.Op) []string {
+	key, end := string(op.KeyBytes()), string(op.RangeBytes())
+	keys := []string{}
+	for k := range kv.keys {
+		if k == key || (len(end) > 0 && k >= key && k < end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (kv *fakeKV) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{kv: kv}
+}
+
+type fakeTxn struct {
+	kv        *fakeKV
+	cmps      []clientv3.Cmp
+	then, els []clientv3.Op
+}
+
+func (txn *fakeTxn) If(cmps ...clientv3.Cmp) clientv3.Txn {
+	txn.cmps = cmps
+	return txn
+}
+
+func (txn *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	txn.then = ops
+	return txn
+}
+
+func (txn *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	txn.els = ops
+	return txn
+}
+
+func (txn *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	succeeded := true
+	for _, cmp := range txn.cmps {
+		key := string(cmp.KeyBytes())
+		if !reflect.DeepEqual(cmp, clientv3.Compare(clientv3.CreateRevision(key), "=", 0)) {
+			txn.kv.t.Fatalf("Unsupported comparison in transaction, %v", cmp)
+		}
+		if _, ok := txn.kv.keys[key]; ok {
+			succeeded = false
+		}
+	}
+	ops := txn.then
+	if !succeeded {
+		ops = txn.els
+	}
+	for _, op := range ops {
+		switch {
+		case op.IsPut():
+			txn.kv.keys[string(op.KeyBytes())] = string(op.ValueBytes())
+		case op.IsDelete():
+			for _, k := range txn.kv.matched(op) {
+				delete(txn.kv.keys, k)
+			}
+		default:
+			txn.kv.t.Fatalf("Unsupported operation in transaction, %v", op)
+		}
+	}
+	return &clientv3.TxnResponse{Succeeded: succeeded}, nil
+}
+
+func TestEtcdV3Upgrade(t *testing.T) {
+	kv := &fakeKV{t: t, keys: map[string]string{
+		// a legacy process node
+		"/tidb/process/10001-m1-PD/desired-state": "started",
+		"/tidb/process/10001-m1-PD/current-state": "stopped",
+		"/tidb/process/10001-m1-PD/object":        "{}",
+		"/tidb/process/10001-m1-PD/alive":         "true",
+		// a legacy process node upgraded already, by another master at the same time
+		"/tidb/process/10002-m2-TiKV/desired-state": "started",
+		"/tidb/process/10002/record":                "{}",
+		// a process node of the current version
+		"/tidb/process/10003/record": "{}",
+		// a malformed legacy process node
+		"/tidb/process/10004-/object": "{}",
+	}}
+	r := NewEtcdV3Registry(&clientv3.Client{KV: kv}, "/tidb", time.Second, "")
+	upgraded, err := r.Upgrade()
+	if err != nil {
+		t.Fatalf("Upgrade failed, %v", err)
+	}
+	if upgraded != 1 {
+		t.Errorf("Upgrade returns %d process nodes upgraded, want 1", upgraded)
+	}
+
+	keys := []string{}
+	for k := range kv.keys {
+		keys = append(keys, strings.TrimPrefix(k, "/tidb/process/"))
+	}
+	sort.Strings(keys)
+	want := []string{
+		"10001/current-state", "10001/desired-state", "10001/object", "10001/record",
+		"10002/record", "10003/record", "10004-/object",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Process keys %q after upgraded, want %q", keys, want)
+	}
+	if kv.keys["/tidb/process/10002/record"] != "{}" {
+		t.Errorf("The process node upgraded already is written again")
+	}
+
+	// nothing to upgrade the second time
+	if upgraded, err := r.Upgrade(); err != nil || upgraded != 0 {
+		t.Errorf("Upgrade again returns %d, %v, want nothing upgraded", upgraded, err)
+	}
+}
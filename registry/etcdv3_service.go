@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+func (r *EtcdV3Registry) Services() (map[string]*service.Definition, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	dir := r.prefixed(servicePrefix) + "/"
+	resp, err := r.client.Get(ctx, dir, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[string]*service.Definition)
+	for _, kv := range resp.Kvs {
+		svcName := strings.TrimPrefix(string(kv.Key), dir)
+		def, err := serviceDefinitionFromValue(svcName, string(kv.Value))
+		if err != nil {
+			return nil, err
+		}
+		defs[svcName] = def
+	}
+	return defs, nil
+}
+
+func (r *EtcdV3Registry) CreateService(def *service.Definition) error {
+	return r.putService(def, false)
+}
+
+func (r *EtcdV3Registry) UpdateService(def *service.Definition) error {
+	return r.putService(def, true)
+}
+
+// putService writes the definition of service, if the service exists or not as expected
+func (r *EtcdV3Registry) putService(def *service.Definition, exists bool) error {
+	object, err := marshal(def)
+	if err != nil {
+		return err
+	}
+	key := r.prefixed(servicePrefix, def.SvcName)
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if exists {
+		cmp = clientv3.Compare(clientv3.CreateRevision(key), ">", 0)
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, object)).Commit()
+	if err != nil {
+		log.Errorf("Failed to save service in etcd, svcName: %s, %v", def.SvcName, err)
+		return err
+	}
+	if !resp.Succeeded {
+		e := fmt.Sprintf("Service already exists in etcd, svcName: %s", def.SvcName)
+		if exists {
+			e = fmt.Sprintf("Service not found in etcd, svcName: %s", def.SvcName)
+		}
+		log.Error(e)
+		return errors.New(e)
+	}
+	return nil
+}
+
+func (r *EtcdV3Registry) DeleteService(svcName string) error {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.client.Delete(ctx, r.prefixed(servicePrefix, svcName))
+	if err != nil {
+		log.Errorf("Failed to delete service in etcd, svcName: %s, %v", svcName, err)
+		return err
+	}
+	if resp.Deleted == 0 {
+		e := fmt.Sprintf("Service not found in etcd, svcName: %s", svcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	return nil
+}
@@ -15,46 +15,97 @@ const (
 	jobPrefix = "job"
 
 	// Occurs when any Process's target state is touched
-	ProcessTargetStateChangeEvent = utils.Event("ProcessTargetStateChangeEvent")
+	ProcessTargetStateChangeEvent = utils.EventType("ProcessTargetStateChangeEvent")
 	// Occurs when any Machine's state is touched
-	MachineStateChangeEvent = utils.Event("MachineStateChangeEvent")
+	MachineStateChangeEvent = utils.EventType("MachineStateChangeEvent")
 )
 
+func jobKeyOfEvent(t utils.EventType) string {
+	// The structure of job nodes in etcd, every write to them triggers the watchers,
+	// with the JSON of the event as value:
+	//   /root/job/process-state
+	//   /root/job/machine-state
+	switch t {
+	case ProcessTargetStateChangeEvent:
+		return "process-state"
+	case MachineStateChangeEvent:
+		return "machine-state"
+	}
+	return ""
+}
+
+// publishJob writes the event under job directory to notify all watchers,
+// failing to publish is not fatal, since watchers will catch up by the next tick
+func (r *EtcdRegistry) publishJob(ev utils.Event) {
+	jobKey := jobKeyOfEvent(ev.Type)
+	if len(jobKey) == 0 {
+		log.Warnf("Unknown event type, no job published, %v", ev)
+		return
+	}
+	payload, err := marshal(&ev)
+	if err != nil {
+		log.Warnf("Error marshaling job event, %v, %v", ev, err)
+		return
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.kAPI.Set(ctx, r.prefixed(jobPrefix, jobKey), payload, &etcd.SetOptions{}); err != nil {
+		log.Warnf("Failed to publish job event in etcd, %v, %v", ev, err)
+	}
+}
+
 type EtcdEventStream struct {
+	kAPI       etcd.KeysAPI
 	watcher    etcd.Watcher
 	rootPrefix string
 }
 
 func NewEtcdEventStream(kapi etcd.KeysAPI, keyPrefix string) utils.EventStream {
+	return &EtcdEventStream{
+		kAPI:       kapi,
+		watcher:    newJobWatcher(kapi, keyPrefix),
+		rootPrefix: keyPrefix,
+	}
+}
+
+func newJobWatcher(kapi etcd.KeysAPI, keyPrefix string) etcd.Watcher {
 	key := path.Join(keyPrefix, jobPrefix)
 	opts := &etcd.WatcherOptions{
 		AfterIndex: 0,
 		Recursive:  true,
 	}
-	return &EtcdEventStream{
-		watcher:    kapi.Watcher(key, opts),
-		rootPrefix: keyPrefix,
-	}
+	return kapi.Watcher(key, opts)
 }
 
-// Next returns a channel which will emit an Event as soon as one of interest occurs
+// Next returns a channel which will emit an Event as soon as one of interest occurs,
+// the channel is closed without any event if nothing interesting happened before timeout
 func (es *EtcdEventStream) Next(timeout time.Duration) chan utils.Event {
-	evchan := make(chan utils.Event)
+	evchan := make(chan utils.Event, 1)
 	go func() {
-		ctx, _ := context.WithTimeout(context.Background(), timeout)
-		res, err := es.watcher.Next(ctx)
-		if err != nil {
-			if err == context.DeadlineExceeded {
-				close(evchan)
-			} else {
+		defer close(evchan)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		for {
+			res, err := es.watcher.Next(ctx)
+			if err != nil {
+				if err == context.DeadlineExceeded {
+					return
+				}
 				log.Errorf("Some failure encountered while waiting for next etcd event, %v", err)
+				if isEtcdError(err, etcd.ErrorCodeEventIndexCleared) {
+					// the watched index is outdated, restart watching from the current index
+					es.watcher = newJobWatcher(es.kAPI, es.rootPrefix)
+					continue
+				}
+				// wait until timeout to avoid spinning while etcd is unavailable
+				<-ctx.Done()
+				return
 			}
-		} else {
 			if ev, ok := parse(res, es.rootPrefix); ok {
 				evchan <- ev
+				return
 			}
 		}
-		return
 	}()
 	return evchan
 }
@@ -63,16 +114,31 @@ func parse(res *etcd.Response, prefix string) (ev utils.Event, ok bool) {
 	if res == nil || res.Node == nil {
 		return
 	}
-	if !strings.HasPrefix(res.Node.Key, path.Join(prefix, jobPrefix)) {
+	return parseJob(res.Node.Key, res.Node.Value, prefix)
+}
+
+// parseJob maps the job node written by publishJob to the event
+func parseJob(key, value, prefix string) (ev utils.Event, ok bool) {
+	if !strings.HasPrefix(key, path.Join(prefix, jobPrefix)) {
 		return
 	}
-	switch path.Base(res.Node.Key) {
+	var t utils.EventType
+	switch path.Base(key) {
 	case "process-state":
-		ev = ProcessTargetStateChangeEvent
-		ok = true
+		t = ProcessTargetStateChangeEvent
 	case "machine-state":
-		ev = MachineStateChangeEvent
-		ok = true
+		t = MachineStateChangeEvent
+	default:
+		return
+	}
+	if len(value) > 0 {
+		if err := unmarshal(value, &ev); err != nil {
+			// the event is still worth a full reconciling even without payload
+			log.Warnf("Error unmarshaling job event, key: %s, %v", key, err)
+			ev = utils.Event{}
+		}
 	}
+	ev.Type = t
+	ok = true
 	return
 }
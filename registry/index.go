@@ -0,0 +1,175 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+const indexPrefix = "index"
+
+// processIndexKeys returns the keys of all secondary index nodes of process, relative to the root prefix
+func processIndexKeys(procID, machID, svcName string) []string {
+	// The structure of index nodes in etcd, the value of each is the procID,
+	// so that processes can be filtered without scanning the whole process directory:
+	//   /root/index/machine/{machID}/{procID}
+	//   /root/index/service/{svcName}/{procID}
+	return []string{
+		path.Join(indexPrefix, "machine", machID, procID),
+		path.Join(indexPrefix, "service", svcName, procID),
+	}
+}
+
+func (r *EtcdRegistry) createProcessIndex(procID, machID, svcName string) error {
+	for _, key := range processIndexKeys(procID, machID, svcName) {
+		ctx, cancel := r.ctx()
+		_, err := r.kAPI.Set(ctx, r.prefixed(key), procID, &etcd.SetOptions{})
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteProcessIndex removes all index nodes of process, an index node left dangling is harmless,
+// since the lookups skip it and RebuildProcessIndex cleans it up
+func (r *EtcdRegistry) deleteProcessIndex(procID, machID, svcName string) {
+	for _, key := range processIndexKeys(procID, machID, svcName) {
+		if err := r.deleteNode(r.prefixed(key), false); err != nil && !isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			log.Warnf("Failed to delete index node of process, %s, %v", key, err)
+		}
+	}
+}
+
+// processesByIndex reads all processes indexed in the directory of machine or service,
+// the dangling or malformed ones are skipped. etcd v2 can't read several keys in one request,
+// so each process indexed is read by its own node, the process directory is never scanned
+func (r *EtcdRegistry) processesByIndex(dir string) (map[string]*proc.ProcessStatus, error) {
+	ctx, cancel := r.ctx()
+	resp, err := r.kAPI.Get(ctx, r.prefixed(dir), &etcd.GetOptions{Quorum: true})
+	cancel()
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			// no process indexed yet
+			return make(map[string]*proc.ProcessStatus), nil
+		}
+		return nil, err
+	}
+	procIDToProcess := make(map[string]*proc.ProcessStatus)
+	for _, node := range resp.Node.Nodes {
+		if node.Dir {
+			continue
+		}
+		procID := path.Base(node.Key)
+		status, err := r.indexedProcess(procID)
+		if err != nil {
+			return nil, err
+		}
+		if status == nil {
+			log.Debugf("Skip process in index, procID[%s]", procID)
+			continue
+		}
+		procIDToProcess[procID] = status
+	}
+	return procIDToProcess, nil
+}
+
+// indexedProcess reads the process node of procID, nil if it's dangling, being created or malformed
+func (r *EtcdRegistry) indexedProcess(procID string) (*proc.ProcessStatus, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.kAPI.Get(ctx, r.prefixed(processPrefix, procID), &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if isPendingProcessNode(resp.Node) {
+		return nil, nil
+	}
+	status, err := processStatusFromEtcdNode(procID, resp.Node)
+	if err != nil || status == nil {
+		log.Warnf("Skip malformed process in index, procID[%s], error[%v]", procID, err)
+		return nil, nil
+	}
+	return status, nil
+}
+
+// RebuildProcessIndex indexes all committed processes, and removes the index nodes of processes not existing,
+// the index nodes of the processes being created are kept, which are created before the processes committed
+func (r *EtcdRegistry) RebuildProcessIndex() error {
+	// read the index before the processes, so that the index nodes of processes created meanwhile are not read,
+	// and never taken as dangling
+	ctx, cancel := r.ctx()
+	indexResp, err := r.kAPI.Get(ctx, r.prefixed(indexPrefix), &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
+	cancel()
+	if err != nil && !isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+		return err
+	}
+	ctx, cancel = r.ctx()
+	resp, err := r.kAPI.Get(ctx, r.prefixed(processPrefix), &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	valid := make(map[string]bool)
+	pending := make(map[string]bool)
+	for _, node := range resp.Node.Nodes {
+		procID := path.Base(node.Key)
+		if isPendingProcessNode(node) {
+			pending[procID] = true
+			continue
+		}
+		status, err := processStatusFromEtcdNode(procID, node)
+		if err != nil || status == nil {
+			continue
+		}
+		if err := r.createProcessIndex(procID, status.MachID, status.SvcName); err != nil {
+			e := fmt.Sprintf("Failed to create index of process, %s, %v", procID, err)
+			log.Error(e)
+			return errors.New(e)
+		}
+		for _, key := range processIndexKeys(procID, status.MachID, status.SvcName) {
+			valid[r.prefixed(key)] = true
+		}
+	}
+	if indexResp == nil {
+		return nil
+	}
+
+	// clean up the dangling index nodes
+	var clean func(node *etcd.Node)
+	clean = func(node *etcd.Node) {
+		if node.Dir {
+			for _, n := range node.Nodes {
+				clean(n)
+			}
+			return
+		}
+		if valid[node.Key] || pending[path.Base(node.Key)] {
+			return
+		}
+		log.Infof("Delete dangling index node of process, %s", node.Key)
+		if err := r.deleteNode(node.Key, false); err != nil && !isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			log.Warnf("Failed to delete dangling index node, %s, %v", node.Key, err)
+		}
+	}
+	clean(indexResp.Node)
+	return nil
+}
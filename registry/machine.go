@@ -9,6 +9,7 @@ import (
 	etcd "github.com/coreos/etcd/client"
 	"github.com/ngaut/log"
 	"github.com/qiuyesuifeng/tidb-demo/machine"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
 )
 
 const machinePrefix = "machine"
@@ -94,22 +95,32 @@ func machineStatusFromEtcdNode(machID string, node *etcd.Node) (*machine.Machine
 	return status, nil
 }
 
-func (r *EtcdRegistry) RegisterMachine(machID, hostName, hostRegion, hostIDC, publicIP string) error {
+func (r *EtcdRegistry) RegisterMachine(machID, hostName, hostRegion, hostIDC, publicIP string, apiPort int) error {
 	if exists, err := r.checkMachineExists(machID); err != nil {
 		return err
 	} else if !exists {
 		// not found then create a new machine node
-		return r.createMachine(machID, hostName, hostRegion, hostIDC, publicIP)
-	}
-
-	// found it, update host infomation of the machine
-	machInfo := &machine.MachineInfo{
-		HostName:   hostName,
-		HostRegion: hostRegion,
-		HostIDC:    hostIDC,
-		PublicIP:   publicIP,
+		if err := r.createMachine(machID, hostName, hostRegion, hostIDC, publicIP, apiPort); err != nil {
+			return err
+		}
+	} else {
+		// found it, update host infomation of the machine
+		machInfo := &machine.MachineInfo{
+			HostName:   hostName,
+			HostRegion: hostRegion,
+			HostIDC:    hostIDC,
+			PublicIP:   publicIP,
+			APIPort:    apiPort,
+		}
+		if err := r.updateMeachineInfo(machID, machInfo); err != nil {
+			return err
+		}
 	}
-	return r.updateMeachineInfo(machID, machInfo)
+	r.publishJob(utils.Event{
+		Type:   MachineStateChangeEvent,
+		MachID: machID,
+	})
+	return nil
 }
 
 func (r *EtcdRegistry) checkMachineExists(machID string) (bool, error) {
@@ -145,12 +156,13 @@ func (r *EtcdRegistry) updateMeachineInfo(machID string, machInfo *machine.Machi
 	return nil
 }
 
-func (r *EtcdRegistry) createMachine(machID, hostName, hostRegion, hostIDC, publicIP string) error {
+func (r *EtcdRegistry) createMachine(machID, hostName, hostRegion, hostIDC, publicIP string, apiPort int) error {
 	object := &machine.MachineInfo{
 		HostName:   hostName,
 		HostRegion: hostRegion,
 		HostIDC:    hostIDC,
 		PublicIP:   publicIP,
+		APIPort:    apiPort,
 	}
 	statobj := &machine.MachineStat{
 		UsageOfCPU:  0.0,
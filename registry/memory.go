@@ -0,0 +1,628 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/machine"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+const (
+	EtcdBackend   = "etcd"
+	MemoryBackend = "memory"
+
+	// the first procID allocated by a freshly bootstrapped registry, same as etcd
+	initialProcessID = 10000
+	// capacity of the channel buffering events for each memory event stream
+	memoryEventBuffer = 64
+)
+
+var (
+	sharedMemRegistry *MemoryRegistry
+	sharedMemOnce     sync.Once
+)
+
+// MemoryRegistry implement the Registry interface and keeps all states in process memory,
+// it's used by unit tests and single-node demos which run the whole control plane without etcd
+type MemoryRegistry struct {
+	etcdAddrs    string
+	clock        clockwork.Clock
+	bootstrapped bool
+	maxProcID    int
+	machines     map[string]*memMachine
+	processes    map[string]*memProcess
+	quarantine   map[string]*memProcess
+	services     map[string]string // svcName to the definition in JSON
+	watchers     []chan utils.Event
+	rwMutex      sync.RWMutex
+}
+
+type memMachine struct {
+	object      string
+	statistic   string
+	aliveExpire time.Time
+}
+
+type memProcess struct {
+	procID        string
+	machID        string
+	svcName       string
+	desiredState  proc.ProcessState
+	currentState  proc.ProcessState
+	aliveExpire   time.Time
+	health        proc.HealthState
+	healthExpire  time.Time
+	restartStatus proc.RestartStatus
+	resourceUsage proc.ResourceUsage
+	runs          []proc.ProcessRunRecord
+	object        string
+}
+
+func NewMemoryRegistry(etcdAddrs string) *MemoryRegistry {
+	return &MemoryRegistry{
+		etcdAddrs:  etcdAddrs,
+		clock:      clockwork.NewRealClock(),
+		machines:   make(map[string]*memMachine),
+		processes:  make(map[string]*memProcess),
+		quarantine: make(map[string]*memProcess),
+		services:   make(map[string]string),
+		watchers:   make([]chan utils.Event, 0),
+	}
+}
+
+// SharedMemoryRegistry returns the registry instance shared by master and minion running in the same binary,
+// the instance survives server restarts so that nothing is lost when receiving a SIGHUP
+func SharedMemoryRegistry(etcdAddrs string) *MemoryRegistry {
+	sharedMemOnce.Do(func() {
+		sharedMemRegistry = NewMemoryRegistry(etcdAddrs)
+	})
+	return sharedMemRegistry
+}
+
+func (r *MemoryRegistry) GetEtcdAddrs() string {
+	return r.etcdAddrs
+}
+
+func (r *MemoryRegistry) IsBootstrapped() bool {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	if !r.bootstrapped {
+		log.Warnf("The memory registry not bootstrapped yet")
+	}
+	return r.bootstrapped
+}
+
+func (r *MemoryRegistry) Bootstrap() error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	r.machines = make(map[string]*memMachine)
+	r.processes = make(map[string]*memProcess)
+	r.quarantine = make(map[string]*memProcess)
+	r.maxProcID = initialProcessID
+	r.bootstrapped = true
+	return nil
+}
+
+func (r *MemoryRegistry) GenerateProcID() (string, error) {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	return r.generateProcID()
+}
+
+func (r *MemoryRegistry) generateProcID() (string, error) {
+	if !r.bootstrapped {
+		return "", errors.New("Max-Process-ID not exists in memory registry, maybe not normally bootstrapped")
+	}
+	procID := strconv.Itoa(r.maxProcID)
+	r.maxProcID++
+	return procID, nil
+}
+
+func (r *MemoryRegistry) isAlive(expire time.Time) bool {
+	return !expire.IsZero() && r.clock.Now().Before(expire)
+}
+
+func (r *MemoryRegistry) Machine(machID string) (*machine.MachineStatus, error) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	m, ok := r.machines[machID]
+	if !ok {
+		e := fmt.Sprintf("Machine not found in memory registry, machID: %s", machID)
+		log.Error(e)
+		return nil, errors.New(e)
+	}
+	status, err := r.machineStatus(machID, m)
+	if err != nil {
+		e := errors.New(fmt.Sprintf("Invalid machine node, machID[%s], error[%v]", machID, err))
+		return nil, e
+	}
+	return status, nil
+}
+
+func (r *MemoryRegistry) Machines() (map[string]*machine.MachineStatus, error) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	IDToMachine := make(map[string]*machine.MachineStatus)
+	for machID, m := range r.machines {
+		status, err := r.machineStatus(machID, m)
+		if err != nil {
+			e := errors.New(fmt.Sprintf("Invalid machine node, machID[%s], error[%v]", machID, err))
+			return nil, e
+		}
+		IDToMachine[machID] = status
+	}
+	return IDToMachine, nil
+}
+
+func (r *MemoryRegistry) machineStatus(machID string, m *memMachine) (*machine.MachineStatus, error) {
+	status := &machine.MachineStatus{
+		MachID:  machID,
+		IsAlive: r.isAlive(m.aliveExpire),
+	}
+	if err := unmarshal(m.object, &status.MachInfo); err != nil {
+		log.Errorf("Error unmarshaling MachInfo, machID: %s, %v", machID, err)
+		return nil, err
+	}
+	if err := unmarshal(m.statistic, &status.MachStat); err != nil {
+		log.Errorf("Error unmarshaling MachStat, machID: %s, %v", machID, err)
+		return nil, err
+	}
+	return status, nil
+}
+
+func (r *MemoryRegistry) RegisterMachine(machID, hostName, hostRegion, hostIDC, publicIP string, apiPort int) error {
+	object, err := marshal(&machine.MachineInfo{
+		HostName:   hostName,
+		HostRegion: hostRegion,
+		HostIDC:    hostIDC,
+		PublicIP:   publicIP,
+		APIPort:    apiPort,
+	})
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling MachineInfo, %v", err)
+		log.Errorf(e)
+		return errors.New(e)
+	}
+
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	if m, ok := r.machines[machID]; ok {
+		// found it, update host infomation of the machine
+		m.object = object
+	} else {
+		statistic, err := marshal(&machine.MachineStat{
+			LoadAvg:     []float64{},
+			UsageOfDisk: []machine.DiskUsage{},
+		})
+		if err != nil {
+			e := fmt.Sprintf("Error marshaling MachineStat, %v", err)
+			log.Errorf(e)
+			return errors.New(e)
+		}
+		r.machines[machID] = &memMachine{
+			object:    object,
+			statistic: statistic,
+		}
+	}
+	r.broadcast(utils.Event{
+		Type:   MachineStateChangeEvent,
+		MachID: machID,
+	})
+	return nil
+}
+
+func (r *MemoryRegistry) RefreshMachine(machID string, machStat machine.MachineStat, ttl time.Duration) error {
+	statistic, err := marshal(&machStat)
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling MachineStat, %v, %v", machStat, err)
+		log.Errorf(e)
+		return errors.New(e)
+	}
+
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	m, ok := r.machines[machID]
+	if !ok {
+		e := fmt.Sprintf("Failed to update machine statistic, machine not registered, %s", machID)
+		log.Error(e)
+		return errors.New(e)
+	}
+	m.statistic = statistic
+	m.aliveExpire = r.clock.Now().Add(ttl)
+	return nil
+}
+
+func (r *MemoryRegistry) processStatus(p *memProcess) (*proc.ProcessStatus, error) {
+	status := &proc.ProcessStatus{
+		ProcID:        p.procID,
+		MachID:        p.machID,
+		SvcName:       p.svcName,
+		DesiredState:  p.desiredState,
+		CurrentState:  p.currentState,
+		IsAlive:       r.isAlive(p.aliveExpire),
+		Health:        proc.HealthUnknown,
+		RestartStatus: p.restartStatus,
+		ResourceUsage: p.resourceUsage,
+	}
+	if err := unmarshal(p.object, &status.RunInfo); err != nil {
+		log.Errorf("Error unmarshaling RunInfo, procID: %s, %v", p.procID, err)
+		return nil, err
+	}
+	if r.isAlive(p.healthExpire) {
+		status.Health = p.health
+	}
+	return status, nil
+}
+
+func (r *MemoryRegistry) Process(procID string) (*proc.ProcessStatus, error) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		return nil, e
+	}
+	return r.processStatus(p)
+}
+
+func (r *MemoryRegistry) Processes() (map[string]*proc.ProcessStatus, error) {
+	return r.filterProcesses(func(p *memProcess) bool { return true })
+}
+
+func (r *MemoryRegistry) ProcessesOnMachine(machID string) (map[string]*proc.ProcessStatus, error) {
+	return r.filterProcesses(func(p *memProcess) bool { return p.machID == machID })
+}
+
+func (r *MemoryRegistry) ProcessesOfService(svcName string) (map[string]*proc.ProcessStatus, error) {
+	return r.filterProcesses(func(p *memProcess) bool { return p.svcName == svcName })
+}
+
+func (r *MemoryRegistry) filterProcesses(match func(*memProcess) bool) (map[string]*proc.ProcessStatus, error) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	procIDToProcess := make(map[string]*proc.ProcessStatus)
+	for procID, p := range r.processes {
+		if !match(p) {
+			continue
+		}
+		status, err := r.processStatus(p)
+		if err != nil {
+			log.Warnf("Skip malformed process, procID[%s], error[%v]", procID, err)
+			continue
+		}
+		procIDToProcess[procID] = status
+	}
+	return procIDToProcess, nil
+}
+
+func (r *MemoryRegistry) RepairProcesses() ([]string, error) {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	quarantined := []string{}
+	for procID, p := range r.processes {
+		if _, err := r.processStatus(p); err == nil {
+			continue
+		}
+		log.Warnf("Quarantine malformed process, procID[%s]", procID)
+		r.quarantine[procID] = p
+		delete(r.processes, procID)
+		quarantined = append(quarantined, procID)
+	}
+	return quarantined, nil
+}
+
+// Upgrade does nothing, since there is nothing persisted by old versions
+func (r *MemoryRegistry) Upgrade() (int, error) {
+	return 0, nil
+}
+
+// RebuildProcessIndex does nothing, since processes in memory are always keyed by procID
+// and filtering them is cheap enough without any index
+func (r *MemoryRegistry) RebuildProcessIndex() error {
+	return nil
+}
+
+func (r *MemoryRegistry) NewProcess(machID, svcName string, runinfo *proc.ProcessRunInfo) (string, error) {
+	object, err := marshal(runinfo)
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling RunInfo, %v", err)
+		log.Errorf(e)
+		return "", errors.New(e)
+	}
+
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	procID, err := r.generateProcID()
+	if err != nil {
+		e := fmt.Sprintf("Failed to generate new process ID, %v", err)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	r.processes[procID] = &memProcess{
+		procID:       procID,
+		machID:       machID,
+		svcName:      svcName,
+		desiredState: proc.StateStarted,
+		currentState: proc.StateStopped,
+		object:       object,
+	}
+	r.broadcast(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: procID,
+		MachID: machID,
+	})
+	return procID, nil
+}
+
+func (r *MemoryRegistry) DeleteProcess(procID string) (*proc.ProcessStatus, error) {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		return nil, e
+	}
+	status, err := r.processStatus(p)
+	if err != nil {
+		return nil, err
+	}
+	delete(r.processes, procID)
+	r.broadcast(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: procID,
+		MachID: p.machID,
+	})
+	return status, nil
+}
+
+func (r *MemoryRegistry) UpdateProcessDesiredState(procID string, state proc.ProcessState) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		return e
+	}
+	p.desiredState = state
+	r.broadcast(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: procID,
+		MachID: p.machID,
+	})
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateProcessRunInfo(procID string, generation int64, runinfo *proc.ProcessRunInfo) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		return e
+	}
+	object, err := marshalRunInfoOfGeneration(procID, p.object, generation, runinfo)
+	if err != nil {
+		return err
+	}
+	p.object = object
+	r.broadcast(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: procID,
+		MachID: p.machID,
+	})
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateProcessState(procID, machID, svcName string, state proc.ProcessState, isAlive bool, ttl time.Duration) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		// maybe process has been destroyed
+		log.Warnf("Error updating process state of procID: %s, process node is gone", procID)
+		return nil
+	}
+	// compare-and-swap the current-state, the same as etcd does
+	if p.currentState == state.Opposite() {
+		p.currentState = state
+	} else {
+		log.Debugf("Process's current-state not changed in memory registry, procID: %s, state: %s", procID, state.String())
+	}
+	if isAlive {
+		p.aliveExpire = r.clock.Now().Add(ttl)
+	} else {
+		// delete the alive state of process immediately
+		p.aliveExpire = time.Time{}
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateProcessRestartStatus(procID string, status proc.RestartStatus) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		log.Warnf("Error updating restart status of procID: %s, process node is gone", procID)
+		return nil
+	}
+	p.restartStatus = status
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateProcessHealth(procID string, health proc.HealthState, ttl time.Duration) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		log.Warnf("Error updating health of procID: %s, process node is gone", procID)
+		return nil
+	}
+	p.health = health
+	p.healthExpire = r.clock.Now().Add(ttl)
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateProcessResourceUsage(procID string, usage proc.ResourceUsage) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		log.Warnf("Error updating resource usage of procID: %s, process node is gone", procID)
+		return nil
+	}
+	p.resourceUsage = usage
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateProcessRuns(procID string, runs []proc.ProcessRunRecord) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		log.Warnf("Error updating runs of procID: %s, process node is gone", procID)
+		return nil
+	}
+	p.runs = append([]proc.ProcessRunRecord{}, runs...)
+	return nil
+}
+
+func (r *MemoryRegistry) ProcessRuns(procID string) ([]proc.ProcessRunRecord, error) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	p, ok := r.processes[procID]
+	if !ok {
+		e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		return nil, e
+	}
+	return append([]proc.ProcessRunRecord{}, p.runs...), nil
+}
+
+// Services returns the definitions of all services, by svcName
+func (r *MemoryRegistry) Services() (map[string]*service.Definition, error) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	defs := make(map[string]*service.Definition)
+	for svcName, object := range r.services {
+		def, err := serviceDefinitionFromValue(svcName, object)
+		if err != nil {
+			return nil, err
+		}
+		defs[svcName] = def
+	}
+	return defs, nil
+}
+
+func (r *MemoryRegistry) CreateService(def *service.Definition) error {
+	object, err := marshal(def)
+	if err != nil {
+		return err
+	}
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	if _, ok := r.services[def.SvcName]; ok {
+		e := fmt.Sprintf("Service already exists in memory registry, svcName: %s", def.SvcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	r.services[def.SvcName] = object
+	return nil
+}
+
+func (r *MemoryRegistry) UpdateService(def *service.Definition) error {
+	object, err := marshal(def)
+	if err != nil {
+		return err
+	}
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	if _, ok := r.services[def.SvcName]; !ok {
+		e := fmt.Sprintf("Service not found in memory registry, svcName: %s", def.SvcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	r.services[def.SvcName] = object
+	return nil
+}
+
+func (r *MemoryRegistry) DeleteService(svcName string) error {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	if _, ok := r.services[svcName]; !ok {
+		e := fmt.Sprintf("Service not found in memory registry, svcName: %s", svcName)
+		log.Error(e)
+		return errors.New(e)
+	}
+	delete(r.services, svcName)
+	return nil
+}
+
+// broadcast delivers the event to all event streams watching this registry,
+// an event is dropped if the stream is falling behind, the reconciler will catch up by tick.
+// Callers must hold the lock of registry.
+func (r *MemoryRegistry) broadcast(ev utils.Event) {
+	for _, w := range r.watchers {
+		select {
+		case w <- ev:
+		default:
+			log.Warnf("Event stream is full, drop event: %v", ev)
+		}
+	}
+}
+
+func (r *MemoryRegistry) watch() chan utils.Event {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	w := make(chan utils.Event, memoryEventBuffer)
+	r.watchers = append(r.watchers, w)
+	return w
+}
+
+// unwatch stops delivering events to the watcher
+func (r *MemoryRegistry) unwatch(w chan utils.Event) {
+	r.rwMutex.Lock()
+	defer r.rwMutex.Unlock()
+	for i, watcher := range r.watchers {
+		if watcher == w {
+			r.watchers = append(r.watchers[:i], r.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+type MemoryEventStream struct {
+	events chan utils.Event
+}
+
+// NewMemoryEventStream returns the event stream of registry, and the function to cancel it,
+// which must be called once the stream is no longer read, otherwise the events would pile up in it
+func NewMemoryEventStream(r *MemoryRegistry) (utils.EventStream, func()) {
+	events := r.watch()
+	cancel := func() {
+		r.unwatch(events)
+	}
+	return &MemoryEventStream{
+		events: events,
+	}, cancel
+}
+
+// Next returns a channel which will emit an Event as soon as one of interest occurs
+func (es *MemoryEventStream) Next(timeout time.Duration) chan utils.Event {
+	evchan := make(chan utils.Event, 1)
+	go func() {
+		select {
+		case ev := <-es.events:
+			evchan <- ev
+		case <-time.After(timeout):
+			close(evchan)
+		}
+	}()
+	return evchan
+}
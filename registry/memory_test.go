@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/qiuyesuifeng/tidb-demo/machine"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+func newTestMemoryRegistry(t *testing.T) (*MemoryRegistry, clockwork.FakeClock) {
+	r := NewMemoryRegistry("")
+	clock := clockwork.NewFakeClock()
+	r.clock = clock
+	if err := r.Bootstrap(); err != nil {
+		t.Fatalf("Bootstrap failed, %v", err)
+	}
+	return r, clock
+}
+
+func TestMemoryRegistryMachines(t *testing.T) {
+	r, clock := newTestMemoryRegistry(t)
+	if _, err := r.Machine("m1"); err == nil {
+		t.Fatalf("Machine not registered yet is found")
+	}
+	if err := r.RefreshMachine("m1", machine.MachineStat{}, time.Minute); err == nil {
+		t.Fatalf("Machine not registered yet is refreshed")
+	}
+
+	tests := []struct {
+		machID   string
+		hostName string
+		publicIP string
+		refresh  bool
+		alive    bool
+	}{
+		{"m1", "host1", "10.0.0.1", true, true},
+		{"m2", "host2", "10.0.0.2", false, false},
+		// register again with new host information
+		{"m1", "host3", "10.0.0.3", true, true},
+	}
+	for _, tt := range tests {
+		if err := r.RegisterMachine(tt.machID, tt.hostName, "region", "idc", tt.publicIP, 9000); err != nil {
+			t.Fatalf("RegisterMachine(%s) failed, %v", tt.machID, err)
+		}
+		if tt.refresh {
+			stat := machine.MachineStat{LoadAvg: []float64{1, 2, 3}}
+			if err := r.RefreshMachine(tt.machID, stat, time.Minute); err != nil {
+				t.Fatalf("RefreshMachine(%s) failed, %v", tt.machID, err)
+			}
+		}
+		status, err := r.Machine(tt.machID)
+		if err != nil {
+			t.Fatalf("Machine(%s) failed, %v", tt.machID, err)
+		}
+		if status.MachInfo.HostName != tt.hostName || status.MachInfo.PublicIP != tt.publicIP {
+			t.Errorf("Machine(%s) has info %+v, want host %s and IP %s", tt.machID, status.MachInfo, tt.hostName, tt.publicIP)
+		}
+		if status.IsAlive != tt.alive {
+			t.Errorf("Machine(%s) alive is %v, want %v", tt.machID, status.IsAlive, tt.alive)
+		}
+		if tt.refresh && len(status.MachStat.LoadAvg) != 3 {
+			t.Errorf("Machine(%s) has statistic %+v, want the refreshed one", tt.machID, status.MachStat)
+		}
+	}
+
+	machs, err := r.Machines()
+	if err != nil {
+		t.Fatalf("Machines failed, %v", err)
+	}
+	if len(machs) != 2 {
+		t.Errorf("Machines returns %d machines, want 2", len(machs))
+	}
+	clock.Advance(2 * time.Minute)
+	if status, _ := r.Machine("m1"); status.IsAlive {
+		t.Errorf("Machine m1 is still alive after the ttl expired")
+	}
+}
+
+func TestMemoryRegistryProcesses(t *testing.T) {
+	r, clock := newTestMemoryRegistry(t)
+	tests := []struct {
+		machID  string
+		svcName string
+		procID  string
+	}{
+		{"m1", "PD", "10000"},
+		{"m1", "TiKV", "10001"},
+		{"m2", "TiKV", "10002"},
+	}
+	for _, tt := range tests {
+		procID, err := r.NewProcess(tt.machID, tt.svcName, &proc.ProcessRunInfo{Command: tt.svcName})
+		if err != nil {
+			t.Fatalf("NewProcess(%s, %s) failed, %v", tt.machID, tt.svcName, err)
+		}
+		if procID != tt.procID {
+			t.Errorf("NewProcess(%s, %s) returns procID %s, want %s", tt.machID, tt.svcName, procID, tt.procID)
+		}
+		status, err := r.Process(procID)
+		if err != nil {
+			t.Fatalf("Process(%s) failed, %v", procID, err)
+		}
+		if status.MachID != tt.machID || status.SvcName != tt.svcName || status.RunInfo.Command != tt.svcName {
+			t.Errorf("Process(%s) returns %+v, want on %s of %s", procID, status, tt.machID, tt.svcName)
+		}
+		if status.DesiredState != proc.StateStarted || status.CurrentState != proc.StateStopped || status.IsAlive {
+			t.Errorf("Process(%s) in states %s/%s, alive %v, want a new process", procID,
+				status.DesiredState, status.CurrentState, status.IsAlive)
+		}
+	}
+
+	filters := []struct {
+		name  string
+		list  func() (map[string]*proc.ProcessStatus, error)
+		count int
+	}{
+		{"all", r.Processes, 3},
+		{"on m1", func() (map[string]*proc.ProcessStatus, error) { return r.ProcessesOnMachine("m1") }, 2},
+		{"on m3", func() (map[string]*proc.ProcessStatus, error) { return r.ProcessesOnMachine("m3") }, 0},
+		{"of TiKV", func() (map[string]*proc.ProcessStatus, error) { return r.ProcessesOfService("TiKV") }, 2},
+	}
+	for _, tt := range filters {
+		procs, err := tt.list()
+		if err != nil {
+			t.Fatalf("Listing processes %s failed, %v", tt.name, err)
+		}
+		if len(procs) != tt.count {
+			t.Errorf("Listing processes %s returns %d, want %d", tt.name, len(procs), tt.count)
+		}
+	}
+
+	// the run info is updated only if of the given generation
+	if err := r.UpdateProcessRunInfo("10000", 1, &proc.ProcessRunInfo{Command: "pd-server"}); err == nil {
+		t.Errorf("UpdateProcessRunInfo of a wrong generation succeeded")
+	}
+	if err := r.UpdateProcessRunInfo("10000", 0, &proc.ProcessRunInfo{Command: "pd-server"}); err != nil {
+		t.Fatalf("UpdateProcessRunInfo failed, %v", err)
+	}
+	if status, _ := r.Process("10000"); status.RunInfo.Command != "pd-server" || status.RunInfo.Generation != 1 {
+		t.Errorf("Process 10000 has run info %+v after updated, want pd-server of generation 1", status.RunInfo)
+	}
+
+	if err := r.UpdateProcessDesiredState("10001", proc.StateStopped); err != nil {
+		t.Fatalf("UpdateProcessDesiredState failed, %v", err)
+	}
+	if err := r.UpdateProcessState("10001", "m1", "TiKV", proc.StateStarted, true, time.Minute); err != nil {
+		t.Fatalf("UpdateProcessState failed, %v", err)
+	}
+	status, _ := r.Process("10001")
+	if status.DesiredState != proc.StateStopped || status.CurrentState != proc.StateStarted || !status.IsAlive {
+		t.Errorf("Process 10001 in states %s/%s, alive %v, want stopped/started and alive",
+			status.DesiredState, status.CurrentState, status.IsAlive)
+	}
+	clock.Advance(2 * time.Minute)
+	if status, _ := r.Process("10001"); status.IsAlive {
+		t.Errorf("Process 10001 is still alive after the ttl expired")
+	}
+
+	if _, err := r.DeleteProcess("10002"); err != nil {
+		t.Fatalf("DeleteProcess failed, %v", err)
+	}
+	if _, err := r.Process("10002"); err == nil {
+		t.Errorf("Process 10002 is found after deleted")
+	}
+	if _, err := r.DeleteProcess("10002"); err == nil {
+		t.Errorf("Process 10002 is deleted twice")
+	}
+}
+
+func TestMemoryEventStream(t *testing.T) {
+	r, _ := newTestMemoryRegistry(t)
+	es, cancel := NewMemoryEventStream(r)
+
+	tests := []struct {
+		name   string
+		action func() error
+		event  utils.Event
+	}{
+		{
+			"register machine",
+			func() error { return r.RegisterMachine("m1", "host1", "region", "idc", "10.0.0.1", 9000) },
+			utils.Event{Type: MachineStateChangeEvent, MachID: "m1"},
+		},
+		{
+			"new process",
+			func() error {
+				_, err := r.NewProcess("m1", "PD", &proc.ProcessRunInfo{})
+				return err
+			},
+			utils.Event{Type: ProcessTargetStateChangeEvent, ProcID: "10000", MachID: "m1"},
+		},
+		{
+			"stop process",
+			func() error { return r.UpdateProcessDesiredState("10000", proc.StateStopped) },
+			utils.Event{Type: ProcessTargetStateChangeEvent, ProcID: "10000", MachID: "m1"},
+		},
+		{
+			"delete process",
+			func() error {
+				_, err := r.DeleteProcess("10000")
+				return err
+			},
+			utils.Event{Type: ProcessTargetStateChangeEvent, ProcID: "10000", MachID: "m1"},
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.action(); err != nil {
+			t.Fatalf("Failed to %s, %v", tt.name, err)
+		}
+		ev := <-es.Next(time.Second)
+		if ev != tt.event {
+			t.Errorf("Event of %s is %+v, want %+v", tt.name, ev, tt.event)
+		}
+	}
+	if ev := <-es.Next(10 * time.Millisecond); !ev.None() {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+
+	cancel()
+	if len(r.watchers) != 0 {
+		t.Errorf("%d watchers left after the event stream canceled", len(r.watchers))
+	}
+	if err := r.RegisterMachine("m2", "host2", "region", "idc", "10.0.0.2", 9000); err != nil {
+		t.Fatalf("RegisterMachine failed, %v", err)
+	}
+	if ev := <-es.Next(10 * time.Millisecond); !ev.None() {
+		t.Errorf("Event %+v delivered after the event stream canceled", ev)
+	}
+}
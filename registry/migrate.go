@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/ngaut/log"
+)
+
+// MigrateV2ToV3 copies the tidemo tree stored through the etcd v2 API into the v3 keyspace with the same layout,
+// alive states are skipped since they will be refreshed by minions soon after.
+// The bootstrapped flag is copied at last, so that an interrupted migration can be started over.
+// Returns the number of keys copied.
+func MigrateV2ToV3(kapi etcd.KeysAPI, client *clientv3.Client, keyPrefix string, reqTimeout time.Duration, force bool) (int, error) {
+	v2 := NewEtcdRegistry(kapi, keyPrefix, reqTimeout, "").(*EtcdRegistry)
+	v3 := NewEtcdV3Registry(client, keyPrefix, reqTimeout, "").(*EtcdV3Registry)
+
+	bootstrapKey := v3.prefixed(bootstrapPrefix)
+	ctx, cancel := v3.ctx()
+	resp, err := client.Get(ctx, bootstrapKey)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) > 0 && !force {
+		return 0, errors.New(fmt.Sprintf("The etcd v3 registry is already bootstrapped, key: %s", bootstrapKey))
+	}
+
+	ctx, cancel = v2.ctx()
+	v2resp, err := kapi.Get(ctx, v2.keyPrefix, &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
+	cancel()
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			return 0, errors.New(fmt.Sprintf("%s not found in etcd v2, nothing to migrate", keyPrefix))
+		}
+		return 0, err
+	}
+
+	count := 0
+	var walk func(n *etcd.Node) error
+	walk = func(n *etcd.Node) error {
+		if n.Dir {
+			for _, child := range n.Nodes {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if n.Expiration != nil || n.Key == bootstrapKey {
+			return nil
+		}
+		ctx, cancel := v3.ctx()
+		defer cancel()
+		if _, err := client.Put(ctx, n.Key, n.Value); err != nil {
+			return err
+		}
+		log.Debugf("Migrated key %s", n.Key)
+		count++
+		return nil
+	}
+	if err := walk(v2resp.Node); err != nil {
+		return count, err
+	}
+
+	ctx, cancel = v3.ctx()
+	defer cancel()
+	if _, err := client.Put(ctx, bootstrapKey, "bootstrapped"); err != nil {
+		return count, err
+	}
+	return count + 1, nil
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"path"
-	"strings"
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
@@ -15,6 +14,8 @@ import (
 
 const processPrefix = "process"
 
+// Processes lists all processes in etcd,
+// process nodes which are still being created or malformed are skipped, but never fail the whole listing
 func (r *EtcdRegistry) Processes() (map[string]*proc.ProcessStatus, error) {
 	key := r.prefixed(processPrefix)
 	opts := &etcd.GetOptions{
@@ -32,175 +33,111 @@ func (r *EtcdRegistry) Processes() (map[string]*proc.ProcessStatus, error) {
 		return nil, err
 	}
 
-	IDToProcess := make(map[string]*proc.ProcessStatus)
+	procIDToProcess := make(map[string]*proc.ProcessStatus)
 	for _, node := range resp.Node.Nodes {
-		key := path.Base(node.Key)
-		parts := strings.Split(key, "-")
-		if len(parts) < 3 {
-			e := errors.New(fmt.Sprintf("Node key[%s] is illegal, invalid key foramt of process", node.Key))
-			return nil, e
+		if isPendingProcessNode(node) {
+			log.Debugf("Process node is still being created, skip it, key[%s]", node.Key)
+			continue
 		}
-		procID := parts[0]
-		machID := parts[1]
-		svcName := parts[2]
-		status, err := processStatusFromEtcdNode(procID, machID, svcName, node)
+		status, err := processStatusFromEtcdNode(path.Base(node.Key), node)
 		if err != nil || status == nil {
-			e := errors.New(fmt.Sprintf("Invalid process node, key[%s], error[%v]", node.Key, err))
-			return nil, e
+			log.Warnf("Skip malformed process node, key[%s], error[%v]", node.Key, err)
+			continue
 		}
-		IDToProcess[procID] = status
+		procIDToProcess[status.ProcID] = status
 	}
-	return IDToProcess, nil
+	return procIDToProcess, nil
 }
 
 func (r *EtcdRegistry) Process(procID string) (*proc.ProcessStatus, error) {
-	key := r.prefixed(processPrefix)
-	opts := &etcd.GetOptions{
-		Recursive: true,
-		Quorum:    true,
-	}
 	ctx, cancel := r.ctx()
 	defer cancel()
-	resp, err := r.kAPI.Get(ctx, key, opts)
+	resp, err := r.kAPI.Get(ctx, r.prefixed(processPrefix, procID), &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
 	if err != nil {
 		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
-			e := errors.New(fmt.Sprintf("Node[%s] not found in etcd, Ti-Cluster may not be properly bootstrapped", key))
-			return nil, e
+			return nil, errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
 		}
 		return nil, err
 	}
-
-	for _, node := range resp.Node.Nodes {
-		key := path.Base(node.Key)
-		parts := strings.Split(key, "-")
-		if len(parts) < 3 {
-			e := errors.New(fmt.Sprintf("Node key[%s] is illegal, invalid key foramt of process", node.Key))
-			return nil, e
-		}
-		if procID != parts[0] {
-			continue
-		}
-		machID := parts[1]
-		svcName := parts[2]
-		status, err := processStatusFromEtcdNode(procID, machID, svcName, node)
-		if err != nil || status == nil {
-			e := errors.New(fmt.Sprintf("Invalid process node, key[%s], error[%v]", node.Key, err))
-			return nil, e
-		}
-		return status, nil
+	if isPendingProcessNode(resp.Node) {
+		return nil, errors.New(fmt.Sprintf("Process is still being created, procID[%s]", procID))
 	}
-	e := errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
-	return nil, e
+	return processStatusFromEtcdNode(procID, resp.Node)
 }
 
 func (r *EtcdRegistry) ProcessesOnMachine(machID string) (map[string]*proc.ProcessStatus, error) {
-	key := r.prefixed(processPrefix)
-	opts := &etcd.GetOptions{
-		Recursive: true,
-		Quorum:    true,
-	}
-	ctx, cancel := r.ctx()
-	defer cancel()
-	resp, err := r.kAPI.Get(ctx, key, opts)
-	if err != nil {
-		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
-			e := errors.New(fmt.Sprintf("Node[%s] not found in etcd, Ti-Cluster may not be properly bootstrapped", key))
-			return nil, e
-		}
-		return nil, err
-	}
-
-	procIDToProcess := make(map[string]*proc.ProcessStatus)
-	for _, node := range resp.Node.Nodes {
-		key := path.Base(node.Key)
-		parts := strings.Split(key, "-")
-		if len(parts) < 3 {
-			e := errors.New(fmt.Sprintf("Node key[%s] is illegal, invalid key foramt of process", node.Key))
-			return nil, e
-		}
-		procID := parts[0]
-		if machID != parts[1] {
-			continue
-		}
-		svcName := parts[2]
-		status, err := processStatusFromEtcdNode(procID, machID, svcName, node)
-		if err != nil || status == nil {
-			e := errors.New(fmt.Sprintf("Invalid process node, key[%s], error[%v]", node.Key, err))
-			return nil, e
-		}
-		procIDToProcess[procID] = status
-	}
-	return procIDToProcess, nil
+	return r.processesByIndex(path.Join(indexPrefix, "machine", machID))
 }
 
 func (r *EtcdRegistry) ProcessesOfService(svcName string) (map[string]*proc.ProcessStatus, error) {
-	key := r.prefixed(processPrefix)
-	opts := &etcd.GetOptions{
-		Recursive: true,
-		Quorum:    true,
-	}
-	ctx, cancel := r.ctx()
-	defer cancel()
-	resp, err := r.kAPI.Get(ctx, key, opts)
-	if err != nil {
-		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
-			e := errors.New(fmt.Sprintf("Node[%s] not found in etcd, Ti-Cluster may not be properly bootstrapped", key))
-			return nil, e
-		}
-		return nil, err
-	}
+	return r.processesByIndex(path.Join(indexPrefix, "service", svcName))
+}
 
-	procIDToProcess := make(map[string]*proc.ProcessStatus)
-	for _, node := range resp.Node.Nodes {
-		key := path.Base(node.Key)
-		parts := strings.Split(key, "-")
-		if len(parts) < 3 {
-			e := errors.New(fmt.Sprintf("Node key[%s] is illegal, invalid key foramt of process", node.Key))
-			return nil, e
-		}
-		procID := parts[0]
-		machID := parts[1]
-		if svcName != parts[2] {
-			continue
-		}
-		status, err := processStatusFromEtcdNode(procID, machID, svcName, node)
-		if err != nil || status == nil {
-			e := errors.New(fmt.Sprintf("Invalid process node, key[%s], error[%v]", node.Key, err))
-			return nil, e
-		}
-		procIDToProcess[procID] = status
-	}
-	return procIDToProcess, nil
+// A process node is created with a TTL, and the TTL is cleared after all its children created,
+// so a process node with TTL is one being created, or left by an interrupted creation which will expire soon
+func isPendingProcessNode(node *etcd.Node) bool {
+	return node.Dir && node.Expiration != nil
 }
 
 // The structure of node representing a process in etcd:
-//   /root/process/{procID}-{machID}-{svcName}
+//   /root/process/{procID}
+//                  /record
 //                  /desired-state
 //                  /current-state
 //                  /alive
+//                  /health
+//                  /restart-status
+//                  /resource-usage
+//                  /runs
 //                  /object
-//                  /endpoints/{endpoint}
-func processStatusFromEtcdNode(procID, machID, svcName string, node *etcd.Node) (*proc.ProcessStatus, error) {
+func processStatusFromEtcdNode(procID string, node *etcd.Node) (*proc.ProcessStatus, error) {
 	if !node.Dir {
 		return nil, errors.New(fmt.Sprintf("Invalid process node, not a etcd directory, key[%v]", node.Key))
 	}
+	attrs := make(map[string]string)
+	for _, n := range node.Nodes {
+		attrs[path.Base(n.Key)] = n.Value
+	}
+	return processStatusFromAttrs(procID, attrs)
+}
+
+// the attributes which a well-formed process node must have
+var requiredProcessAttrs = []string{"record", "desired-state", "current-state", "object"}
+
+// processStatusFromAttrs parses the attributes of process node into status,
+// returns an error if the node is malformed
+func processStatusFromAttrs(procID string, attrs map[string]string) (*proc.ProcessStatus, error) {
+	for _, attr := range requiredProcessAttrs {
+		if _, ok := attrs[attr]; !ok {
+			return nil, errors.New(fmt.Sprintf("Attribute %s of process is missing, procID: %s", attr, procID))
+		}
+	}
 	status := &proc.ProcessStatus{
-		ProcID:  procID,
-		MachID:  machID,
-		SvcName: svcName,
+		Health: proc.HealthUnknown,
 	}
-	for _, n := range node.Nodes {
-		key := path.Base(n.Key)
+	for key, value := range attrs {
 		switch key {
+		case "record":
+			record, err := parseProcessRecord(procID, value)
+			if err != nil {
+				log.Errorf("Error parsing process record, procID: %s, %v", procID, err)
+				return nil, err
+			}
+			status.ProcID = record.ProcID
+			status.MachID = record.MachID
+			status.SvcName = record.SvcName
 		case "desired-state":
-			if state, err := parseProcessState(n.Value); err != nil {
+			if state, err := parseProcessState(value); err != nil {
 				log.Errorf("Error parsing process state, procID: %s, %v", procID, err)
 				return nil, err
 			} else {
 				status.DesiredState = state
 			}
 		case "current-state":
-			if state, err := parseProcessState(n.Value); err != nil {
+			if state, err := parseProcessState(value); err != nil {
 				log.Errorf("Error parsing process state, procID: %s, %v", procID, err)
 				return nil, err
 			} else {
@@ -208,8 +145,25 @@ func processStatusFromEtcdNode(procID, machID, svcName string, node *etcd.Node)
 			}
 		case "alive":
 			status.IsAlive = true
+		case "health":
+			if health, err := proc.ParseHealthState(value); err != nil {
+				log.Errorf("Error parsing health state, procID: %s, %v", procID, err)
+				return nil, err
+			} else {
+				status.Health = health
+			}
+		case "restart-status":
+			if err := unmarshal(value, &status.RestartStatus); err != nil {
+				log.Errorf("Error unmarshaling RestartStatus, procID: %s, %v", procID, err)
+				return nil, err
+			}
+		case "resource-usage":
+			if err := unmarshal(value, &status.ResourceUsage); err != nil {
+				log.Errorf("Error unmarshaling ResourceUsage, procID: %s, %v", procID, err)
+				return nil, err
+			}
 		case "object":
-			if err := unmarshal(n.Value, &status.RunInfo); err != nil {
+			if err := unmarshal(value, &status.RunInfo); err != nil {
 				log.Errorf("Error unmarshaling RunInfo, procID: %s, %v", procID, err)
 				return nil, err
 			}
@@ -231,16 +185,15 @@ func parseProcessState(state string) (proc.ProcessState, error) {
 
 func (r *EtcdRegistry) UpdateProcessState(procID, machID, svcName string, state proc.ProcessState, isAlive bool, ttl time.Duration) error {
 	// update the current-state of process
-	if err := r.updateProcessCurrentState(procID, machID, svcName, state); err != nil {
+	if err := r.updateProcessCurrentState(procID, state); err != nil {
 		return err
 	}
 	// update the real alive state of process in etcd
-	return r.refreshProcessAlive(procID, machID, svcName, isAlive, ttl)
+	return r.refreshProcessAlive(procID, isAlive, ttl)
 }
 
-func (r *EtcdRegistry) updateProcessCurrentState(procID, machID, svcName string, state proc.ProcessState) error {
-	procKey := strings.Join([]string{procID, machID, svcName}, "-")
-	currentStateKey := r.prefixed(processPrefix, procKey, "current-state")
+func (r *EtcdRegistry) updateProcessCurrentState(procID string, state proc.ProcessState) error {
+	currentStateKey := r.prefixed(processPrefix, procID, "current-state")
 	ctx, cancel := r.ctx()
 	defer cancel()
 	_, err := r.kAPI.Set(ctx, currentStateKey, state.String(), &etcd.SetOptions{
@@ -263,9 +216,8 @@ func (r *EtcdRegistry) updateProcessCurrentState(procID, machID, svcName string,
 	return nil
 }
 
-func (r *EtcdRegistry) refreshProcessAlive(procID, machID, svcName string, isAlive bool, ttl time.Duration) error {
-	procKey := strings.Join([]string{procID, machID, svcName}, "-")
-	aliveKey := r.prefixed(processPrefix, procKey, "alive")
+func (r *EtcdRegistry) refreshProcessAlive(procID string, isAlive bool, ttl time.Duration) error {
+	aliveKey := r.prefixed(processPrefix, procID, "alive")
 	if isAlive {
 		// try to touch alive node of process, if node not exists than create it
 		if ok, err := r.touchProcessAlive(aliveKey, ttl); err != nil {
@@ -315,56 +267,148 @@ func (r *EtcdRegistry) createProcessAlive(aliveKey string, ttl time.Duration) er
 	return nil
 }
 
-func (r *EtcdRegistry) NewProcess(machID, svcName string, hostIP, hostName, hostRegion, hostIDC string,
-	executor []string, command string, args []string, env map[string]string, endpoints map[string]utils.Endpoint) error {
+func (r *EtcdRegistry) UpdateProcessRestartStatus(procID string, status proc.RestartStatus) error {
+	value, err := marshal(&status)
+	if err != nil {
+		return err
+	}
+	return r.setProcessAttr(procID, "restart-status", value)
+}
+
+func (r *EtcdRegistry) UpdateProcessHealth(procID string, health proc.HealthState, ttl time.Duration) error {
+	return r.setProcessAttrWithTTL(procID, "health", health.String(), ttl)
+}
+
+func (r *EtcdRegistry) UpdateProcessResourceUsage(procID string, usage proc.ResourceUsage) error {
+	value, err := marshal(&usage)
+	if err != nil {
+		return err
+	}
+	return r.setProcessAttr(procID, "resource-usage", value)
+}
+
+func (r *EtcdRegistry) UpdateProcessRuns(procID string, runs []proc.ProcessRunRecord) error {
+	value, err := marshal(&runs)
+	if err != nil {
+		return err
+	}
+	return r.setProcessAttr(procID, "runs", value)
+}
+
+func (r *EtcdRegistry) ProcessRuns(procID string) ([]proc.ProcessRunRecord, error) {
+	if _, err := r.Process(procID); err != nil {
+		return nil, err
+	}
+	runs := []proc.ProcessRunRecord{}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.kAPI.Get(ctx, r.prefixed(processPrefix, procID, "runs"), &etcd.GetOptions{Quorum: true})
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			// never run yet
+			return runs, nil
+		}
+		return nil, err
+	}
+	if err := unmarshal(resp.Node.Value, &runs); err != nil {
+		log.Errorf("Error unmarshaling runs of process, procID: %s, %v", procID, err)
+		return nil, err
+	}
+	return runs, nil
+}
+
+// setProcessAttr writes an optional attribute of process,
+// make sure not to create a process directory implicitly if the process has been destroyed
+func (r *EtcdRegistry) setProcessAttr(procID, attr, value string) error {
+	return r.setProcessAttrWithTTL(procID, attr, value, 0)
+}
+
+// setProcessAttrWithTTL writes the attribute which expires after ttl, never expires if ttl is 0
+func (r *EtcdRegistry) setProcessAttrWithTTL(procID, attr, value string, ttl time.Duration) error {
+	recordKey := r.prefixed(processPrefix, procID, "record")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.kAPI.Get(ctx, recordKey, &etcd.GetOptions{Quorum: true}); err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			log.Warnf("Error updating %s of procID: %s, process node is gone", attr, procID)
+			return nil
+		}
+		return err
+	}
+	_, err := r.kAPI.Set(ctx, r.prefixed(processPrefix, procID, attr), value, &etcd.SetOptions{TTL: ttl})
+	return err
+}
+
+func (r *EtcdRegistry) NewProcess(machID, svcName string, runinfo *proc.ProcessRunInfo) (string, error) {
 	// generate new process ID
 	procID, err := r.GenerateProcID()
 	if err != nil {
 		e := fmt.Sprintf("Failed to generate new process ID, %v", err)
 		log.Error(e)
-		return errors.New(e)
+		return "", errors.New(e)
 	}
-	procKey := strings.Join([]string{procID, machID, svcName}, "-")
 	desiredState := proc.StateStarted
 	currentState := proc.StateStopped
-	object := &proc.ProcessRunInfo{
-		HostIP:      hostIP,
-		HostName:    hostName,
-		HostRegion:  hostRegion,
-		HostIDC:     hostIDC,
-		Executor:    executor,
-		Command:     command,
-		Args:        args,
-		Environment: env,
-		Endpoints:   endpoints,
-	}
-	if err := r.mustCreateNode(r.prefixed(processPrefix, procKey), "", true); err != nil {
-		e := fmt.Sprintf("Failed to create node of process, %s, %v", procKey, err)
-		log.Error(e)
-		return errors.New(e)
+	objstr, err := marshal(runinfo)
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling RunInfo, %v, %v", runinfo, err)
+		log.Errorf(e)
+		return "", errors.New(e)
 	}
-	if err := r.createNode(r.prefixed(processPrefix, procKey, "desired-state"), desiredState.String(), false); err != nil {
-		e := fmt.Sprintf("Failed to create desired-state of process node, %s, %v", procKey, err)
-		log.Error(e)
-		return errors.New(e)
+	recstr, err := marshal(newProcessRecord(procID, machID, svcName))
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling process record, %v", err)
+		log.Errorf(e)
+		return "", errors.New(e)
 	}
-	if err := r.createNode(r.prefixed(processPrefix, procKey, "current-state"), currentState.String(), false); err != nil {
-		e := fmt.Sprintf("Failed to create current-state of process node, %s, %v", procKey, err)
+
+	// etcd v2 has no transaction, so the process node is created as a pending node with TTL first,
+	// which is invisible to listing, then committed by clearing the TTL after all children created.
+	// If creation fails midway, the pending node is removed, or expired by etcd if even removing fails.
+	procDir := r.prefixed(processPrefix, procID)
+	if err := r.createPendingDir(procDir, pendingProcessTTL); err != nil {
+		e := fmt.Sprintf("Failed to create node of process, %s, %v", procID, err)
 		log.Error(e)
-		return errors.New(e)
+		return "", errors.New(e)
 	}
-	if objstr, err := marshal(object); err == nil {
-		if err := r.createNode(r.prefixed(processPrefix, procKey, "object"), objstr, false); err != nil {
-			e := fmt.Sprintf("Failed to create RunInfo of process node, %s, %v, %v", procKey, object, err)
+	children := []struct {
+		key   string
+		value string
+	}{
+		{"record", recstr},
+		{"desired-state", desiredState.String()},
+		{"current-state", currentState.String()},
+		{"object", objstr},
+	}
+	for _, child := range children {
+		if err := r.createNode(path.Join(procDir, child.key), child.value, false); err != nil {
+			e := fmt.Sprintf("Failed to create %s of process node, %s, %v", child.key, procID, err)
 			log.Error(e)
-			return errors.New(e)
+			r.rollbackPendingDir(procDir)
+			return "", errors.New(e)
 		}
-	} else {
-		e := fmt.Sprintf("Error marshaling RunInfo, %v, %v", object, err)
-		log.Errorf(e)
-		return errors.New(e)
 	}
-	return nil
+	// index nodes are created before committing, so that a committed process is always indexed
+	if err := r.createProcessIndex(procID, machID, svcName); err != nil {
+		e := fmt.Sprintf("Failed to create index of process, %s, %v", procID, err)
+		log.Error(e)
+		r.deleteProcessIndex(procID, machID, svcName)
+		r.rollbackPendingDir(procDir)
+		return "", errors.New(e)
+	}
+	if err := r.commitPendingDir(procDir); err != nil {
+		e := fmt.Sprintf("Failed to commit process node, %s, %v", procID, err)
+		log.Error(e)
+		r.deleteProcessIndex(procID, machID, svcName)
+		r.rollbackPendingDir(procDir)
+		return "", errors.New(e)
+	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: procID,
+		MachID: machID,
+	})
+	return procID, nil
 }
 
 func (r *EtcdRegistry) DeleteProcess(procID string) (*proc.ProcessStatus, error) {
@@ -372,10 +416,15 @@ func (r *EtcdRegistry) DeleteProcess(procID string) (*proc.ProcessStatus, error)
 	if err != nil {
 		return nil, err
 	}
-	procKey := strings.Join([]string{status.ProcID, status.MachID, status.SvcName}, "-")
-	if err := r.deleteNode(r.prefixed(processPrefix, procKey), true); err != nil {
+	if err := r.deleteNode(r.prefixed(processPrefix, status.ProcID), true); err != nil {
 		return nil, err
 	}
+	r.deleteProcessIndex(status.ProcID, status.MachID, status.SvcName)
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: status.ProcID,
+		MachID: status.MachID,
+	})
 	return status, nil
 }
 
@@ -384,13 +433,138 @@ func (r *EtcdRegistry) UpdateProcessDesiredState(procID string, state proc.Proce
 	if err != nil {
 		return err
 	}
-	procKey := strings.Join([]string{status.ProcID, status.MachID, status.SvcName}, "-")
 	ctx, cancel := r.ctx()
 	defer cancel()
-	if _, err := r.kAPI.Set(ctx, r.prefixed(processPrefix, procKey, "desired-state"), state.String(), &etcd.SetOptions{
+	if _, err := r.kAPI.Set(ctx, r.prefixed(processPrefix, status.ProcID, "desired-state"), state.String(), &etcd.SetOptions{
 		PrevExist: etcd.PrevExist,
 	}); err != nil {
 		return err
 	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: status.ProcID,
+		MachID: status.MachID,
+	})
+	return nil
+}
+
+func (r *EtcdRegistry) UpdateProcessRunInfo(procID string, generation int64, runinfo *proc.ProcessRunInfo) error {
+	status, err := r.Process(procID)
+	if err != nil {
+		return err
+	}
+	objectKey := r.prefixed(processPrefix, status.ProcID, "object")
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.kAPI.Get(ctx, objectKey, &etcd.GetOptions{Quorum: true})
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			return errors.New(fmt.Sprintf("No process found by procID[%s]", procID))
+		}
+		return err
+	}
+	objstr, err := marshalRunInfoOfGeneration(procID, resp.Node.Value, generation, runinfo)
+	if err != nil {
+		return err
+	}
+	// compare-and-swap by the index of object node, so that concurrent updates never overwrite each other
+	if _, err := r.kAPI.Set(ctx, objectKey, objstr, &etcd.SetOptions{
+		PrevIndex: resp.Node.ModifiedIndex,
+	}); err != nil {
+		if isEtcdError(err, etcd.ErrorCodeTestFailed) {
+			return errors.New(fmt.Sprintf("Run info of process changed concurrently, procID[%s]", procID))
+		}
+		return err
+	}
+	r.publishJob(utils.Event{
+		Type:   ProcessTargetStateChangeEvent,
+		ProcID: status.ProcID,
+		MachID: status.MachID,
+	})
+	return nil
+}
+
+// marshalRunInfoOfGeneration checks that the current run info is of the generation,
+// and returns the new run info marshaled with the next generation
+func marshalRunInfoOfGeneration(procID, current string, generation int64, runinfo *proc.ProcessRunInfo) (string, error) {
+	var old proc.ProcessRunInfo
+	if err := unmarshal(current, &old); err != nil {
+		log.Errorf("Error unmarshaling RunInfo, procID: %s, %v", procID, err)
+		return "", err
+	}
+	if old.Generation != generation {
+		return "", errors.New(fmt.Sprintf("Run info of process is of generation %d rather than %d, procID[%s]", old.Generation, generation, procID))
+	}
+	next := *runinfo
+	next.Generation = generation + 1
+	objstr, err := marshal(&next)
+	if err != nil {
+		e := fmt.Sprintf("Error marshaling RunInfo, %v, %v", runinfo, err)
+		log.Error(e)
+		return "", errors.New(e)
+	}
+	return objstr, nil
+}
+
+func (r *EtcdRegistry) RepairProcesses() ([]string, error) {
+	key := r.prefixed(processPrefix)
+	opts := &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	}
+	ctx, cancel := r.ctx()
+	resp, err := r.kAPI.Get(ctx, key, opts)
+	cancel()
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			e := errors.New(fmt.Sprintf("%s not found in etcd, cluster may not be properly bootstrapped", key))
+			return nil, e
+		}
+		return nil, err
+	}
+
+	quarantined := []string{}
+	for _, node := range resp.Node.Nodes {
+		if isPendingProcessNode(node) {
+			// it's being created or will expire soon, not our business
+			continue
+		}
+		procKey := path.Base(node.Key)
+		_, err := processStatusFromEtcdNode(procKey, node)
+		if err == nil {
+			continue
+		}
+		log.Warnf("Quarantine malformed process node, key[%s], error[%v]", node.Key, err)
+		if err := r.copyNode(node, r.prefixed(quarantinePrefix, procKey)); err != nil {
+			e := fmt.Sprintf("Failed to copy process node into quarantine, %s, %v", procKey, err)
+			log.Error(e)
+			return quarantined, errors.New(e)
+		}
+		if err := r.deleteNode(node.Key, node.Dir); err != nil && !isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			e := fmt.Sprintf("Failed to delete malformed process node, %s, %v", procKey, err)
+			log.Error(e)
+			return quarantined, errors.New(e)
+		}
+		quarantined = append(quarantined, procKey)
+	}
+	return quarantined, nil
+}
+
+// copyNode writes all the permanent leaves under node into dst recursively, keeping the relative paths
+func (r *EtcdRegistry) copyNode(node *etcd.Node, dst string) error {
+	if !node.Dir {
+		if node.Expiration != nil {
+			return nil
+		}
+		ctx, cancel := r.ctx()
+		defer cancel()
+		_, err := r.kAPI.Set(ctx, dst, node.Value, &etcd.SetOptions{})
+		return err
+	}
+	for _, n := range node.Nodes {
+		if err := r.copyNode(n, path.Join(dst, path.Base(n.Key))); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -6,8 +6,8 @@ import (
 	"time"
 
 	"github.com/qiuyesuifeng/tidb-demo/machine"
-	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
 	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
 )
 
 // Registry interface defined a set of operations to access a distributed key value store,
@@ -25,7 +25,7 @@ type Registry interface {
 	// return a map of machID to machineStatus
 	Machines() (map[string]*machine.MachineStatus, error)
 	// Create new machine node in etcd
-	RegisterMachine(machID, hostName, hostRegion, hostIDC, publicIP string) error
+	RegisterMachine(machID, hostName, hostRegion, hostIDC, publicIP string, apiPort int) error
 	// Update statistic info of machine and refresh the TTL of alive state in etcd
 	RefreshMachine(machID string, machStat machine.MachineStat, ttl time.Duration) error
 	// Return the status of process with specified procID
@@ -40,15 +40,45 @@ type Registry interface {
 	// Retrieve all processes instantiated from the specified service
 	// return a map of procID to status infomation of process
 	ProcessesOfService(svcName string) (map[string]*proc.ProcessStatus, error)
-	// Create new process node of specified service in etcd
-	NewProcess(machID, svcName string, hostIP, hostName, hostRegion, hostIDC string,
-		executor []string, command string, args []string, env map[string]string, endpoints map[string]utils.Endpoint) error
+	// Create new process node of specified service in etcd, return the procID allocated
+	NewProcess(machID, svcName string, runinfo *proc.ProcessRunInfo) (string, error)
 	// Destroy the process, normally the process should be in stopped state
 	DeleteProcess(procID string) (*proc.ProcessStatus, error)
+	// Replace the run info of process in etcd if its generation is still the given one, which is increased by one then,
+	// the minion restarts the local process with the new run info
+	UpdateProcessRunInfo(procID string, generation int64, runinfo *proc.ProcessRunInfo) error
 	// Update process desirede state in etcd
 	UpdateProcessDesiredState(procID string, state proc.ProcessState) error
 	// Update process current state in etcd, notice that isAlive is real run state of the local process
 	UpdateProcessState(procID, machID, svcName string, state proc.ProcessState, isAlive bool, ttl time.Duration) error
+	// Update the restarting history of process in etcd, reported by minion after the local process exited or restarted
+	UpdateProcessRestartStatus(procID string, status proc.RestartStatus) error
+	// Update the result of health probes of process in etcd, which expires after ttl unless updated again
+	UpdateProcessHealth(procID string, health proc.HealthState, ttl time.Duration) error
+	// Update the resources used by process in etcd, reported by minion periodically if the process is limited
+	UpdateProcessResourceUsage(procID string, usage proc.ResourceUsage) error
+	// Save the records of the last runs of process in etcd, the oldest first
+	UpdateProcessRuns(procID string, runs []proc.ProcessRunRecord) error
+	// Return the records of the last runs of process, the oldest first
+	ProcessRuns(procID string) ([]proc.ProcessRunRecord, error)
+	// Upgrade the process nodes written by old versions into the current format,
+	// return the number of process nodes upgraded
+	Upgrade() (int, error)
+	// Move malformed process nodes, which are skipped by listing, out of process directory into quarantine directory,
+	// return the keys of process nodes quarantined
+	RepairProcesses() ([]string, error)
+	// Recreate the missing index of processes and remove the dangling ones,
+	// the index by procID, machine and service is maintained on write to avoid scanning all processes
+	RebuildProcessIndex() error
+	// Retrieve the definitions of all services in Ti-Cluster,
+	// return a map of svcName to the definition of service
+	Services() (map[string]*service.Definition, error)
+	// Create the definition of new service in etcd, fails if the service exists
+	CreateService(def *service.Definition) error
+	// Replace the definition of existing service in etcd
+	UpdateService(def *service.Definition) error
+	// Delete the definition of service in etcd
+	DeleteService(svcName string) error
 }
 
 func marshal(obj interface{}) (string, error) {
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+// the definition of service is stored as JSON in etcd, under /root/service/{svcName}
+const servicePrefix = "service"
+
+func (r *EtcdRegistry) Services() (map[string]*service.Definition, error) {
+	ctx, cancel := r.ctx()
+	defer cancel()
+	resp, err := r.kAPI.Get(ctx, r.prefixed(servicePrefix), &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			// no service defined yet
+			return map[string]*service.Definition{}, nil
+		}
+		return nil, err
+	}
+	defs := make(map[string]*service.Definition)
+	for _, node := range resp.Node.Nodes {
+		svcName := path.Base(node.Key)
+		def, err := serviceDefinitionFromValue(svcName, node.Value)
+		if err != nil {
+			return nil, err
+		}
+		defs[svcName] = def
+	}
+	return defs, nil
+}
+
+func (r *EtcdRegistry) CreateService(def *service.Definition) error {
+	object, err := marshal(def)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.kAPI.Set(ctx, r.prefixed(servicePrefix, def.SvcName), object, &etcd.SetOptions{
+		PrevExist: etcd.PrevNoExist,
+	}); err != nil {
+		if isEtcdError(err, etcd.ErrorCodeNodeExist) {
+			e := fmt.Sprintf("Service already exists in etcd, svcName: %s", def.SvcName)
+			log.Error(e)
+			return errors.New(e)
+		}
+		log.Errorf("Failed to create service in etcd, svcName: %s, %v", def.SvcName, err)
+		return err
+	}
+	return nil
+}
+
+func (r *EtcdRegistry) UpdateService(def *service.Definition) error {
+	object, err := marshal(def)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := r.ctx()
+	defer cancel()
+	if _, err := r.kAPI.Set(ctx, r.prefixed(servicePrefix, def.SvcName), object, &etcd.SetOptions{
+		PrevExist: etcd.PrevExist,
+	}); err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			e := fmt.Sprintf("Service not found in etcd, svcName: %s", def.SvcName)
+			log.Error(e)
+			return errors.New(e)
+		}
+		log.Errorf("Failed to update service in etcd, svcName: %s, %v", def.SvcName, err)
+		return err
+	}
+	return nil
+}
+
+func (r *EtcdRegistry) DeleteService(svcName string) error {
+	if err := r.deleteNode(r.prefixed(servicePrefix, svcName), false); err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			e := fmt.Sprintf("Service not found in etcd, svcName: %s", svcName)
+			log.Error(e)
+			return errors.New(e)
+		}
+		log.Errorf("Failed to delete service in etcd, svcName: %s, %v", svcName, err)
+		return err
+	}
+	return nil
+}
+
+func serviceDefinitionFromValue(svcName, value string) (*service.Definition, error) {
+	def := &service.Definition{}
+	if err := unmarshal(value, def); err != nil {
+		log.Errorf("Error unmarshaling service definition, svcName: %s, %v", svcName, err)
+		return nil, err
+	}
+	if def.SvcName != svcName {
+		return nil, errors.New(fmt.Sprintf("Invalid service node, svcName[%s], defined as %s", svcName, def.SvcName))
+	}
+	return def, nil
+}
@@ -0,0 +1,158 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/ngaut/log"
+)
+
+// processRecordVersion is the version of the format of process node currently written,
+// version 1 is the legacy format which keeps machID and svcName in the key of process node,
+// e.g. {procID}-{machID}-{svcName}, that's ambiguous if any of them contains a dash.
+// Since version 2 the process node is keyed by procID only, and the record inside it tells the rest
+const processRecordVersion = 2
+
+type processRecord struct {
+	Version int
+	ProcID  string
+	MachID  string
+	SvcName string
+}
+
+func newProcessRecord(procID, machID, svcName string) *processRecord {
+	return &processRecord{
+		Version: processRecordVersion,
+		ProcID:  procID,
+		MachID:  machID,
+		SvcName: svcName,
+	}
+}
+
+func parseProcessRecord(procID, value string) (*processRecord, error) {
+	record := &processRecord{}
+	if err := unmarshal(value, record); err != nil {
+		return nil, err
+	}
+	if record.Version != processRecordVersion {
+		return nil, errors.New(fmt.Sprintf("Unsupported version of process record, %d", record.Version))
+	}
+	if record.ProcID != procID {
+		return nil, errors.New(fmt.Sprintf("ProcID in record mismatched with key, %s", record.ProcID))
+	}
+	if len(record.MachID) == 0 || len(record.SvcName) == 0 {
+		return nil, errors.New("MachID or service name is missing in record")
+	}
+	return record, nil
+}
+
+// isLegacyProcessKey tells whether the key of process node is in the version 1 format
+func isLegacyProcessKey(procKey string) bool {
+	return strings.Contains(procKey, "-")
+}
+
+// parseLegacyProcessKey splits the version 1 key of process node into procID, machID and svcName,
+// procID is a number and machID is a hex string generated by minion, neither contains a dash,
+// so everything after the second dash belongs to svcName
+func parseLegacyProcessKey(procKey string) (procID, machID, svcName string, err error) {
+	parts := strings.SplitN(procKey, "-", 3)
+	if len(parts) < 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		err = errors.New(fmt.Sprintf("Node key[%s] is illegal, invalid key foramt of process", procKey))
+		return
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// legacyProcessAttrs returns the attributes worth keeping when upgrading a legacy process,
+// the alive state is dropped since it'll be refreshed by minion soon
+func legacyProcessAttrs(attrs map[string]string) map[string]string {
+	kept := make(map[string]string)
+	for _, attr := range requiredProcessAttrs {
+		if value, ok := attrs[attr]; ok {
+			kept[attr] = value
+		}
+	}
+	return kept
+}
+
+func (r *EtcdRegistry) Upgrade() (int, error) {
+	key := r.prefixed(processPrefix)
+	ctx, cancel := r.ctx()
+	resp, err := r.kAPI.Get(ctx, key, &etcd.GetOptions{
+		Recursive: true,
+		Quorum:    true,
+	})
+	cancel()
+	if err != nil {
+		if isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			e := errors.New(fmt.Sprintf("%s not found in etcd, cluster may not be properly bootstrapped", key))
+			return 0, e
+		}
+		return 0, err
+	}
+
+	upgraded := 0
+	for _, node := range resp.Node.Nodes {
+		procKey := path.Base(node.Key)
+		if !isLegacyProcessKey(procKey) || isPendingProcessNode(node) || !node.Dir {
+			continue
+		}
+		procID, machID, svcName, err := parseLegacyProcessKey(procKey)
+		if err != nil {
+			// leave it to be quarantined
+			log.Warnf("Failed to upgrade process node, %s, %v", procKey, err)
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, n := range node.Nodes {
+			attrs[path.Base(n.Key)] = n.Value
+		}
+		if err := r.upgradeProcess(procID, machID, svcName, legacyProcessAttrs(attrs)); err != nil {
+			e := fmt.Sprintf("Failed to upgrade process node, %s, %v", procKey, err)
+			log.Error(e)
+			return upgraded, errors.New(e)
+		}
+		// the new node is committed, the legacy one can be safely removed
+		if err := r.deleteNode(node.Key, true); err != nil && !isEtcdError(err, etcd.ErrorCodeKeyNotFound) {
+			e := fmt.Sprintf("Failed to delete legacy process node, %s, %v", procKey, err)
+			log.Error(e)
+			return upgraded, errors.New(e)
+		}
+		log.Infof("Process node upgraded, %s", procKey)
+		upgraded++
+	}
+	return upgraded, nil
+}
+
+// upgradeProcess writes the process node in the current format in the same way as NewProcess,
+// it succeeds if the node has been written by a previous interrupted upgrade
+func (r *EtcdRegistry) upgradeProcess(procID, machID, svcName string, attrs map[string]string) error {
+	recstr, err := marshal(newProcessRecord(procID, machID, svcName))
+	if err != nil {
+		return err
+	}
+	procDir := r.prefixed(processPrefix, procID)
+	if err := r.createPendingDir(procDir, pendingProcessTTL); err != nil {
+		if isEtcdError(err, etcd.ErrorCodeNodeExist) {
+			if _, err := r.Process(procID); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	attrs["record"] = recstr
+	for attr, value := range attrs {
+		if err := r.createNode(path.Join(procDir, attr), value, false); err != nil {
+			r.rollbackPendingDir(procDir)
+			return err
+		}
+	}
+	if err := r.commitPendingDir(procDir); err != nil {
+		r.rollbackPendingDir(procDir)
+		return err
+	}
+	return nil
+}
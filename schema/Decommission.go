@@ -0,0 +1,23 @@
+package schema
+
+import (
+	"time"
+)
+
+type DecommissionSpec struct {
+	Timeout string `json:"timeout"`
+}
+
+type Decommission struct {
+	ProcID       string    `json:"procID"`
+	SvcName      string    `json:"svcName"`
+	MachID       string    `json:"machID"`
+	Timeout      string    `json:"timeout"`
+	State        string    `json:"state"`
+	StoreID      uint64    `json:"storeID"`
+	StoreState   string    `json:"storeState"`
+	RegionCount  int32     `json:"regionCount"`
+	Error        string    `json:"error"`
+	StartedTime  time.Time `json:"startedTime"`
+	FinishedTime time.Time `json:"finishedTime"`
+}
@@ -0,0 +1,11 @@
+package schema
+
+type EndpointDefinition struct {
+	Name      string `json:"name"`
+	Protocol  string `json:"protocol"`
+	Port      int32  `json:"port"`
+	Flag      string `json:"flag"`
+	ConfigKey string `json:"configKey"`
+	Format    string `json:"format"`
+	WithIP    bool   `json:"withIP"`
+}
@@ -0,0 +1,12 @@
+package schema
+
+type HealthProbe struct {
+	Type             string   `json:"type"`
+	Endpoint         string   `json:"endpoint"`
+	Path             string   `json:"path"`
+	Command          []string `json:"command"`
+	InitialDelay     string   `json:"initialDelay"`
+	Interval         string   `json:"interval"`
+	Timeout          string   `json:"timeout"`
+	FailureThreshold int32    `json:"failureThreshold"`
+}
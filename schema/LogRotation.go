@@ -0,0 +1,9 @@
+package schema
+
+type LogRotation struct {
+	MaxSizeMB int32  `json:"maxSizeMB"`
+	MaxAge    string `json:"maxAge"`
+	MaxFiles  int32  `json:"maxFiles"`
+	Compress  bool   `json:"compress"`
+	MaxRuns   int32  `json:"maxRuns"`
+}
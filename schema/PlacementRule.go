@@ -0,0 +1,8 @@
+package schema
+
+type PlacementRule struct {
+	MaxPerHost     int32             `json:"maxPerHost"`
+	MaxPerIDC      int32             `json:"maxPerIDC"`
+	MaxPerRegion   int32             `json:"maxPerRegion"`
+	RequiredLabels map[string]string `json:"requiredLabels"`
+}
@@ -0,0 +1,8 @@
+package schema
+
+type PlacementViolation struct {
+	ProcID     string   `json:"procID"`
+	MachID     string   `json:"machID"`
+	SvcName    string   `json:"svcName"`
+	Violations []string `json:"violations"`
+}
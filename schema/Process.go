@@ -1,20 +1,33 @@
 package schema
 
 type Process struct {
-	ProcID       string        `json:"procID"`
-	SvcName      string        `json:"svcName"`
-	MachID       string        `json:"machID"`
-	DesiredState string        `json:"desiredState"`
-	CurrentState string        `json:"currentState"`
-	IsAlive      bool          `json:"isAlive"`
-	Endpoints    []string      `json:"endpoints"`
-	Executor     []string      `json:"executor"`
-	Command      string        `json:"command"`
-	Args         []string      `json:"args"`
-	Environments []Environment `json:"environments"`
-	PublicIP     string        `json:"publicIP"`
-	HostName     string        `json:"hostName"`
-	HostMeta     HostMeta      `json:"hostMeta"`
-	Port         int32         `json:"port"`
-	Protocol     string        `json:"protocol"`
+	ProcID        string         `json:"procID"`
+	SvcName       string         `json:"svcName"`
+	MachID        string         `json:"machID"`
+	Placement     string         `json:"placement"`
+	DesiredState  string         `json:"desiredState"`
+	CurrentState  string         `json:"currentState"`
+	IsAlive       bool           `json:"isAlive"`
+	Health        string         `json:"health"`
+	Restarts      int32          `json:"restarts"`
+	LastExitCode  int32          `json:"lastExitCode"`
+	BackingOff    bool           `json:"backingOff"`
+	CrashLoop     bool           `json:"crashLoop"`
+	Endpoints     []string       `json:"endpoints"`
+	Version       string         `json:"version"`
+	Generation    int64          `json:"generation"`
+	Executor      []string       `json:"executor"`
+	Command       string         `json:"command"`
+	Args          []string       `json:"args"`
+	Environments  []Environment  `json:"environments"`
+	RestartPolicy string         `json:"restartPolicy"`
+	MaxRetries    int32          `json:"maxRetries"`
+	LogRotation   LogRotation    `json:"logRotation"`
+	Limits        ResourceLimits `json:"limits"`
+	Usage         ResourceUsage  `json:"usage"`
+	PublicIP      string         `json:"publicIP"`
+	HostName      string         `json:"hostName"`
+	HostMeta      HostMeta       `json:"hostMeta"`
+	Port          int32          `json:"port"`
+	Protocol      string         `json:"protocol"`
 }
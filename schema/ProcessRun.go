@@ -0,0 +1,17 @@
+package schema
+
+import (
+	"time"
+)
+
+type ProcessRun struct {
+	RunID       int32     `json:"runID"`
+	PID         int32     `json:"pid"`
+	Commandline string    `json:"commandline"`
+	StartedTime time.Time `json:"startedTime"`
+	StoppedTime time.Time `json:"stoppedTime"`
+	ExitCode    int32     `json:"exitCode"`
+	Signal      string    `json:"signal"`
+	StopStage   string    `json:"stopStage"`
+	Error       string    `json:"error"`
+}
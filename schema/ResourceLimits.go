@@ -0,0 +1,7 @@
+package schema
+
+type ResourceLimits struct {
+	CPUQuota      float64 `json:"cpuQuota"`
+	MemoryLimitMB int32   `json:"memoryLimitMB"`
+	IOWeight      int32   `json:"ioWeight"`
+}
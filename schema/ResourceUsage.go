@@ -0,0 +1,7 @@
+package schema
+
+type ResourceUsage struct {
+	CPUSeconds  float64 `json:"cpuSeconds"`
+	MemoryUsage int64   `json:"memoryUsage"`
+	OOMKills    int32   `json:"oomKills"`
+}
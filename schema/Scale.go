@@ -0,0 +1,31 @@
+package schema
+
+import (
+	"time"
+)
+
+type ScaleSpec struct {
+	Replicas      int32  `json:"replicas"`
+	HealthTimeout string `json:"healthTimeout"`
+}
+
+type Scale struct {
+	SvcName       string      `json:"svcName"`
+	Replicas      int32       `json:"replicas"`
+	Previous      int32       `json:"previous"`
+	HealthTimeout string      `json:"healthTimeout"`
+	State         string      `json:"state"`
+	Error         string      `json:"error"`
+	StartedTime   time.Time   `json:"startedTime"`
+	FinishedTime  time.Time   `json:"finishedTime"`
+	Steps         []ScaleStep `json:"steps"`
+}
+
+type ScaleStep struct {
+	Action string `json:"action"`
+	MachID string `json:"machID"`
+	ProcID string `json:"procID"`
+	Reason string `json:"reason"`
+	State  string `json:"state"`
+	Error  string `json:"error"`
+}
@@ -1,14 +1,22 @@
 package schema
 
 type Service struct {
-	SvcName      string        `json:"svcName"`
-	Version      string        `json:"version"`
-	Executor     []string      `json:"executor"`
-	Command      string        `json:"command"`
-	Args         []string      `json:"args"`
-	Environments []Environment `json:"environments"`
-	Port         int32         `json:"port"`
-	Protocol     string        `json:"protocol"`
-	Dependencies []string      `json:"dependencies"`
-	Endpoints    []string      `json:"endpoints"`
+	SvcName             string               `json:"svcName"`
+	Version             string               `json:"version"`
+	Executor            []string             `json:"executor"`
+	Command             string               `json:"command"`
+	Args                []string             `json:"args"`
+	Environments        []Environment        `json:"environments"`
+	Port                int32                `json:"port"`
+	Protocol            string               `json:"protocol"`
+	Dependencies        []string             `json:"dependencies"`
+	Endpoints           []string             `json:"endpoints"`
+	EndpointDefinitions []EndpointDefinition `json:"endpointDefinitions"`
+	ConfigFlag          string               `json:"configFlag"`
+	LogRotation         LogRotation          `json:"logRotation"`
+	HealthProbe         *HealthProbe         `json:"healthProbe,omitempty"`
+	Stop                StopSpec             `json:"stop"`
+	RestartPolicy       string               `json:"restartPolicy"`
+	MaxRetries          int32                `json:"maxRetries"`
+	Placement           PlacementRule        `json:"placement"`
 }
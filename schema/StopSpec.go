@@ -0,0 +1,6 @@
+package schema
+
+type StopSpec struct {
+	Signal      int32  `json:"signal"`
+	GracePeriod string `json:"gracePeriod"`
+}
@@ -0,0 +1,16 @@
+package schema
+
+type TopologyPlan struct {
+	DryRun  bool              `json:"dryRun"`
+	Applied bool              `json:"applied"`
+	Actions []*TopologyAction `json:"actions"`
+}
+
+type TopologyAction struct {
+	Type    string `json:"type"`
+	SvcName string `json:"svcName"`
+	ProcID  string `json:"procID"`
+	MachID  string `json:"machID"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error"`
+}
@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"time"
+)
+
+type UpgradeSpec struct {
+	Version       string        `json:"version"`
+	Executor      []string      `json:"executor"`
+	Command       string        `json:"command"`
+	Args          []string      `json:"args"`
+	Environments  []Environment `json:"environments"`
+	HealthTimeout string        `json:"healthTimeout"`
+}
+
+type Upgrade struct {
+	SvcName      string        `json:"svcName"`
+	Spec         UpgradeSpec   `json:"spec"`
+	State        string        `json:"state"`
+	Error        string        `json:"error"`
+	StartedTime  time.Time     `json:"startedTime"`
+	FinishedTime time.Time     `json:"finishedTime"`
+	Steps        []UpgradeStep `json:"steps"`
+}
+
+type UpgradeStep struct {
+	MachID          string   `json:"machID"`
+	ProcID          string   `json:"procID"`
+	State           string   `json:"state"`
+	Error           string   `json:"error"`
+	PreviousVersion string   `json:"previousVersion"`
+	PreviousCommand string   `json:"previousCommand"`
+	PreviousArgs    []string `json:"previousArgs"`
+}
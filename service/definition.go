@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+// Definition describes a service, which is stored in registry and loaded by every master and minion,
+// so that a service can be added or changed without rebuilding tidemo
+type Definition struct {
+	SvcName      string
+	Version      string
+	Executor     []string
+	Command      string
+	Args         []string
+	Environments map[string]string
+	Endpoints    map[string]EndpointDefinition
+	// the name of flag giving the config file in TOML, which the addresses of endpoints can be parsed from
+	ConfigFlag   string
+	LogRotation  proc.LogRotation
+	HealthProbe  *HealthProbe // nil if the service can't be probed
+	Stop         proc.StopSpec
+	Restart      proc.RestartSpec // the restart spec of processes not given their own, never restarted if empty
+	Placement    *PlacementRule   // nil if the processes of service can be placed anywhere
+	Dependencies []string         // the services must be serving before the processes of service started
+}
+
+func (d *Definition) Validate() error {
+	if len(d.SvcName) == 0 {
+		return errors.New("Name of service is necessary")
+	}
+	if strings.ContainsAny(d.SvcName, "/ ") {
+		return errors.New(fmt.Sprintf("Illegal name of service: %s", d.SvcName))
+	}
+	if len(d.Command) == 0 {
+		return errors.New(fmt.Sprintf("Command of service %s is necessary", d.SvcName))
+	}
+	for name, ed := range d.Endpoints {
+		if len(name) == 0 || ed.Port < 0 {
+			return errors.New(fmt.Sprintf("Illegal endpoint %q of service %s", name, d.SvcName))
+		}
+		if err := ed.Format.validate(); err != nil {
+			return errors.New(fmt.Sprintf("Illegal endpoint %q of service %s, %v", name, d.SvcName, err))
+		}
+	}
+	if hp := d.HealthProbe; hp != nil {
+		if hp.Type != ProbeExec {
+			if _, ok := d.Endpoints[hp.Endpoint]; !ok {
+				return errors.New(fmt.Sprintf("The endpoint to probe not defined by service %s: %s", d.SvcName, hp.Endpoint))
+			}
+		}
+		if hp.Interval <= 0 || hp.Timeout <= 0 || hp.FailureThreshold <= 0 {
+			return errors.New(fmt.Sprintf("The interval, timeout and failure threshold of health probe should be positive, service: %s", d.SvcName))
+		}
+	}
+	for _, dep := range d.Dependencies {
+		if dep == d.SvcName {
+			return errors.New(fmt.Sprintf("Service %s depends on itself", d.SvcName))
+		}
+	}
+	return nil
+}
+
+// DefaultDefinitions returns the definitions of built-in services, which are written into
+// registry if no service defined yet
+func DefaultDefinitions() map[string]*Definition {
+	return map[string]*Definition{
+		PD_SERVICE:   pdDefinition(),
+		TiKV_SERVICE: tikvDefinition(),
+		TiDB_SERVICE: tidbDefinition(),
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+// StartOrder sorts the registered services by dependencies, every service comes after the ones it depends on,
+// the services not depending on each other are sorted by name. Reverse it to stop services.
+func StartOrder() ([]string, error) {
+	return sortByDependencies(Registered())
+}
+
+func sortByDependencies(svcs map[string]Service) ([]string, error) {
+	names := make([]string, 0, len(svcs))
+	for name := range svcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]string, 0, len(names))
+	// 1 while visiting the dependencies of service, 2 after the service sorted
+	visited := make(map[string]int)
+	var visit func(name string, from string) error
+	visit = func(name string, from string) error {
+		svc, ok := svcs[name]
+		if !ok {
+			return errors.New(fmt.Sprintf("Service %s depends on unregistered service %s", from, name))
+		}
+		switch visited[name] {
+		case 1:
+			return errors.New(fmt.Sprintf("Circular dependency between services %s and %s", from, name))
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, dep := range svc.Status().Dependencies {
+			if err := visit(dep, name); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		res = append(res, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, name); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// DependenciesReady returns nil if every service the service depends on has a process ready to serve,
+// that is alive, started and healthy, or just started if the service can't be probed
+func DependenciesReady(svcName string, procs map[string]*proc.ProcessStatus) error {
+	svcs := Registered()
+	svc, ok := svcs[svcName]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unregistered service: %s", svcName))
+	}
+	for _, dep := range svc.Status().Dependencies {
+		depSvc, ok := svcs[dep]
+		if !ok {
+			return errors.New(fmt.Sprintf("Service %s depends on unregistered service %s", svcName, dep))
+		}
+		probed := depSvc.Status().HealthProbe != nil
+		ready := false
+		for _, p := range procs {
+			if p.SvcName != dep || !p.IsAlive || p.CurrentState != proc.StateStarted {
+				continue
+			}
+			if !probed || p.Health == proc.HealthHealthy {
+				ready = true
+				break
+			}
+		}
+		if !ready {
+			return errors.New(fmt.Sprintf("No process of service %s ready, which %s depends on", dep, svcName))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+)
+
+// AddrFormat tells how the address of endpoint is written in args or config file
+type AddrFormat string
+
+const (
+	// "host:port", ":port", "[::1]:port" or only the port, the default
+	AddrHostPort = AddrFormat("hostport")
+	// only the port, e.g. "4000"
+	AddrPort = AddrFormat("port")
+	// "scheme://host:port/path", the first one is taken if separated by comma
+	AddrURL = AddrFormat("url")
+)
+
+func (f AddrFormat) validate() error {
+	switch f {
+	case "", AddrHostPort, AddrPort, AddrURL:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unknown format of address: %s", f))
+	}
+}
+
+// EndpointDefinition tells the default address of endpoint, and where to find the address overriding it,
+// the flag of args takes precedence over the key of config file
+type EndpointDefinition struct {
+	Protocol utils.Protocol
+	Port     utils.Port
+	// the name of flag without leading dashes, e.g. "addr" for "--addr 0.0.0.0:1234" or "--addr=0.0.0.0:1234",
+	// empty if the endpoint can't be changed by args
+	Flag string
+	// the dotted key in the config file given by the config flag of service, e.g. "server.addr"
+	ConfigKey string
+	Format    AddrFormat
+	// take the IP of address as well as the port, e.g. for the advertised address
+	WithIP bool
+}
+
+// ParseEndpointFromArgs returns the endpoints of process started with the args, the address of endpoint
+// is taken from the flag defined for it, or from the config file if the flag not given, otherwise the default,
+// the config file is read by master on creating process, so it should be deployed on the same path as minions
+func (s *service) ParseEndpointFromArgs(args []string) map[string]utils.Endpoint {
+	var res = make(map[string]utils.Endpoint)
+	var config map[string]interface{}
+	if path, ok := lookupFlag(args, s.def.ConfigFlag); ok {
+		config = loadConfig(path)
+	}
+	for name, ed := range s.def.Endpoints {
+		ep := utils.Endpoint{
+			Protocol: ed.Protocol,
+			Port:     ed.Port,
+		}
+		value, ok := lookupFlag(args, ed.Flag)
+		if !ok {
+			value, ok = lookupConfig(config, ed.ConfigKey)
+		}
+		if ok {
+			ip, port, err := parseAddr(value, ed.Format)
+			if err != nil {
+				log.Warnf("Illegal address of endpoint %s of service %s, the default used, %v", name, s.def.SvcName, err)
+			} else {
+				if ed.WithIP && net.ParseIP(ip) != nil {
+					ep.IPAddr = ip
+				}
+				if port > 0 {
+					ep.Port = port
+				}
+			}
+		}
+		res[name] = ep
+	}
+	return res
+}
+
+// lookupFlag returns the value of flag in args, any of "-name value", "--name value", "-name=value"
+// and "--name=value", the last one wins if given more than once, args after "--" are not flags
+func lookupFlag(args []string, name string) (string, bool) {
+	if len(name) == 0 {
+		return "", false
+	}
+	value, found := "", false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name && i+1 < len(args) {
+			value, found = args[i+1], true
+			i++
+		} else if strings.HasPrefix(arg, name+"=") {
+			value, found = strings.TrimPrefix(arg, name+"="), true
+		}
+	}
+	return value, found
+}
+
+// loadConfig reads the config file in TOML, nil if it can't be read
+func loadConfig(path string) map[string]interface{} {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Debugf("Config file not readable, endpoints not parsed from it, %s, %v", path, err)
+		return nil
+	}
+	config := make(map[string]interface{})
+	if _, err := toml.Decode(string(data), &config); err != nil {
+		log.Warnf("Illegal config file, endpoints not parsed from it, %s, %v", path, err)
+		return nil
+	}
+	return config
+}
+
+// lookupConfig returns the value of dotted key in config, e.g. "server.addr" for addr in [server] table
+func lookupConfig(config map[string]interface{}, key string) (string, bool) {
+	if config == nil || len(key) == 0 {
+		return "", false
+	}
+	parts := strings.Split(key, ".")
+	table := config
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := table[part].(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		table = next
+	}
+	switch value := table[parts[len(parts)-1]].(type) {
+	case string:
+		return value, true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	default:
+		return "", false
+	}
+}
+
+// parseAddr returns the host and port of address in format, the host is empty if not given
+func parseAddr(addr string, format AddrFormat) (string, utils.Port, error) {
+	var host, port string
+	switch format {
+	case AddrPort:
+		port = addr
+	case AddrURL:
+		if idx := strings.Index(addr, ","); idx >= 0 {
+			addr = addr[:idx]
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", 0, err
+		}
+		host, port = u.Hostname(), u.Port()
+	default:
+		if !strings.Contains(addr, ":") {
+			// a bare port, as written by the definitions without format
+			port = addr
+			break
+		}
+		var err error
+		if host, port, err = net.SplitHostPort(addr); err != nil {
+			return "", 0, err
+		}
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return host, 0, errors.New(fmt.Sprintf("Illegal port of address: %s", addr))
+	}
+	return host, utils.Port(p), nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+)
+
+func TestLookupFlag(t *testing.T) {
+	tests := []struct {
+		args  []string
+		name  string
+		value string
+		found bool
+	}{
+		{[]string{"--port", "4000"}, "port", "4000", true},
+		{[]string{"-port", "4000"}, "port", "4000", true},
+		{[]string{"--port=4000"}, "port", "4000", true},
+		{[]string{"-port=4000"}, "port", "4000", true},
+		{[]string{"--port=4000", "--port", "5000"}, "port", "5000", true},
+		{[]string{"--port="}, "port", "", true},
+		{[]string{"--port"}, "port", "", false},
+		{[]string{"--ports", "4000"}, "port", "", false},
+		{[]string{"--status-port", "10080"}, "port", "", false},
+		{[]string{"port", "4000"}, "port", "", false},
+		{[]string{"--", "--port", "4000"}, "port", "", false},
+		{[]string{"--port", "4000", "--", "--port", "5000"}, "port", "4000", true},
+		{[]string{"--port", "4000"}, "", "", false},
+		{nil, "port", "", false},
+	}
+	for _, tt := range tests {
+		value, found := lookupFlag(tt.args, tt.name)
+		if value != tt.value || found != tt.found {
+			t.Errorf("lookupFlag(%q, %q) = %q, %v, want %q, %v", tt.args, tt.name, value, found, tt.value, tt.found)
+		}
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		format AddrFormat
+		host   string
+		port   utils.Port
+		err    bool
+	}{
+		{"127.0.0.1:4000", "", "127.0.0.1", 4000, false},
+		{":4000", AddrHostPort, "", 4000, false},
+		{"[::1]:4000", AddrHostPort, "::1", 4000, false},
+		{"4000", "", "", 4000, false},
+		{"localhost", AddrHostPort, "", 0, true},
+		{"127.0.0.1:0", AddrHostPort, "127.0.0.1", 0, true},
+		{"127.0.0.1:65536", AddrHostPort, "127.0.0.1", 0, true},
+		{"20160", AddrPort, "", 20160, false},
+		{"port", AddrPort, "", 0, true},
+		{"http://10.0.0.1:2379", AddrURL, "10.0.0.1", 2379, false},
+		{"http://10.0.0.1:2379,http://10.0.0.2:2379", AddrURL, "10.0.0.1", 2379, false},
+		{"http://10.0.0.1", AddrURL, "10.0.0.1", 0, true},
+		{"://10.0.0.1:2379", AddrURL, "", 0, true},
+	}
+	for _, tt := range tests {
+		host, port, err := parseAddr(tt.addr, tt.format)
+		if (err != nil) != tt.err {
+			t.Errorf("parseAddr(%q, %q) returns error %v, want error %v", tt.addr, tt.format, err, tt.err)
+			continue
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("parseAddr(%q, %q) = %q, %d, want %q, %d", tt.addr, tt.format, host, port, tt.host, tt.port)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+type ProbeType string
+
+const (
+	// connect to the endpoint of process
+	ProbeTCP = ProbeType("tcp")
+	// GET the path on the endpoint of process, any status in [200, 400) means healthy
+	ProbeHTTP = ProbeType("http")
+	// run the command on the machine of process, exit code 0 means healthy
+	ProbeExec = ProbeType("exec")
+)
+
+// HealthProbe tells how to check whether the process of service is serving,
+// besides whether it's running
+type HealthProbe struct {
+	Type ProbeType
+	// the name of endpoint to probe, for tcp and http probes
+	Endpoint string
+	// the path to GET, for http probes
+	Path string
+	// the command to run, the endpoints of process can be referred as variables, for exec probes
+	Command []string
+	// do not probe until the process has been running for this long
+	InitialDelay time.Duration
+	Interval     time.Duration
+	Timeout      time.Duration
+	// the number of consecutive failures before the process is considered unhealthy
+	FailureThreshold int
+}
+
+// Probe checks the process once, with the endpoints it serves, returns nil if healthy
+func (hp *HealthProbe) Probe(endpoints map[string]utils.Endpoint) error {
+	switch hp.Type {
+	case ProbeTCP, ProbeHTTP:
+		ep, ok := endpoints[hp.Endpoint]
+		if !ok {
+			return errors.New(fmt.Sprintf("Endpoint to probe not found: %s", hp.Endpoint))
+		}
+		addr := probeAddr(ep)
+		if hp.Type == ProbeTCP {
+			conn, err := net.DialTimeout("tcp", addr, hp.Timeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+		client := &http.Client{
+			Timeout: hp.Timeout,
+		}
+		res, err := client.Get(fmt.Sprintf("http://%s%s", addr, hp.Path))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+			return errors.New(fmt.Sprintf("Unhealthy status of http probe: %s", res.Status))
+		}
+		return nil
+	case ProbeExec:
+		if len(hp.Command) == 0 {
+			return errors.New("No command of exec probe")
+		}
+		vars := make(map[string]string)
+		for name, ep := range endpoints {
+			vars[name] = probeAddr(ep)
+		}
+		args := make([]string, 0, len(hp.Command))
+		for _, arg := range hp.Command {
+			args = append(args, proc.ReplaceVars(arg, vars))
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(hp.Timeout):
+			cmd.Process.Kill()
+			<-done
+			return errors.New(fmt.Sprintf("Exec probe timed out after %v: %s", hp.Timeout, strings.Join(args, " ")))
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unknown type of health probe: %s", hp.Type))
+	}
+}
+
+// probeAddr returns the address to probe the endpoint locally, the process listening on all interfaces
+// is probed through the loopback interface
+func probeAddr(ep utils.Endpoint) string {
+	ip := ep.IPAddr
+	if len(ip) == 0 || ip == "0.0.0.0" {
+		ip = "127.0.0.1"
+	} else if ip == "::" {
+		ip = "::1"
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(int(ep.Port.Value())))
+}
+
+func newTCPProbe(endpoint string) *HealthProbe {
+	return &HealthProbe{
+		Type:             ProbeTCP,
+		Endpoint:         endpoint,
+		InitialDelay:     5 * time.Second,
+		Interval:         5 * time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 3,
+	}
+}
+
+func newHTTPProbe(endpoint, path string) *HealthProbe {
+	return &HealthProbe{
+		Type:             ProbeHTTP,
+		Endpoint:         endpoint,
+		Path:             path,
+		InitialDelay:     5 * time.Second,
+		Interval:         5 * time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 3,
+	}
+}
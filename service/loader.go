@@ -0,0 +1,93 @@
+package service
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/ngaut/log"
+)
+
+// Store keeps the definitions of services, which is implemented by registry
+type Store interface {
+	// return a map of svcName to the definition of service
+	Services() (map[string]*Definition, error)
+	CreateService(def *Definition) error
+}
+
+// LoadServices registers the services defined in store, the built-in services are written
+// into store first if no service defined yet, e.g. in a freshly bootstrapped registry
+func LoadServices(store Store) error {
+	defs, err := store.Services()
+	if err != nil {
+		log.Errorf("Failed to list services in registry, %v", err)
+		return err
+	}
+	if len(defs) == 0 {
+		for _, def := range DefaultDefinitions() {
+			// fails if created by another master or minion at the same time, which is fine
+			if err := store.CreateService(def); err != nil {
+				log.Warnf("Failed to create built-in service %s in registry, %v", def.SvcName, err)
+			}
+		}
+		if defs, err = store.Services(); err != nil {
+			log.Errorf("Failed to list services in registry, %v", err)
+			return err
+		}
+		log.Infof("Built-in services created in registry, count: %d", len(defs))
+	}
+	if err := RegisterDefinitions(defs); err != nil {
+		log.Errorf("Failed to register services defined in registry, %v", err)
+		return err
+	}
+	return nil
+}
+
+func NewPoller(store Store, interval time.Duration) *Poller {
+	return &Poller{
+		store:    store,
+		clock:    clockwork.NewRealClock(),
+		interval: interval,
+	}
+}
+
+// Poller reloads the services from store periodically, so that the services created, updated or deleted
+// through any master are seen by every master and minion, which takes up to the interval on the other nodes
+type Poller struct {
+	store    Store
+	clock    clockwork.Clock
+	interval time.Duration
+}
+
+func (p *Poller) Run(stopc <-chan struct{}) {
+	for {
+		select {
+		case <-stopc:
+			log.Debug("Service poller is exiting due to stop signal")
+			return
+		case <-p.clock.After(p.interval):
+			if err := p.reload(); err != nil {
+				log.Errorf("Failed to reload services, keep the ones registered, %v", err)
+			}
+		}
+	}
+}
+
+func (p *Poller) reload() error {
+	defs, err := p.store.Services()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*Definition)
+	for name, svc := range Registered() {
+		current[name] = svc.Definition()
+	}
+	if reflect.DeepEqual(defs, current) {
+		return nil
+	}
+	if err := RegisterDefinitions(defs); err != nil {
+		return err
+	}
+	log.Infof("Services reloaded from registry, count: %d", len(defs))
+	return nil
+}
@@ -1,95 +1,156 @@
 package service
 
 import (
-	"flag"
-	"regexp"
-	"strconv"
+	"fmt"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
 )
 
 const PD_SERVICE = "PD"
 
-type PDService struct {
-	service
-}
-
-func NewPDService() Service {
-	return &PDService{
-		service{
-			svcName:      PD_SERVICE,
-			version:      "1.0.0",
-			executor:     []string{},
-			command:      "bin/pd-server",
-			args:         []string{"--addr", "0.0.0.0:1234", "--advertise-addr", "$HOST_IP:1234", "--etcd", "$ETCD_ADDR", "--pprof", ":6060", "-L", "debug", "--cluster-id", "1", "--max-peer-count", "3"},
-			environments: map[string]string{},
-			endpoints: map[string]utils.Endpoint{
-				"PD_ADDR": utils.Endpoint{
-					Port: utils.Port(1234),
-				},
-				"PD_ADVERTISE_ADDR": utils.Endpoint{
-					Port: utils.Port(1234),
-				},
-				"PD_PPROF_ADDR": utils.Endpoint{
-					Port: utils.Port(6060),
-				},
+// PD embeds etcd, the arguments to join the cluster of existing PD processes are added on creating process,
+// see PDClusterArgs
+func pdDefinition() *Definition {
+	return &Definition{
+		SvcName:      PD_SERVICE,
+		Version:      "1.0.0",
+		Executor:     []string{},
+		Command:      "bin/pd-server",
+		Args:         []string{"--name", "pd-$PROCID", "--data-dir", "data/pd-$PROCID", "--client-urls", "http://0.0.0.0:1234", "--advertise-client-urls", "http://$HOST_IP:1234", "--peer-urls", "http://0.0.0.0:1235", "--advertise-peer-urls", "http://$HOST_IP:1235", "-L", "info"},
+		Environments: map[string]string{},
+		LogRotation:  defaultLogRotation,
+		HealthProbe:  newTCPProbe("PD_ADDR"),
+		Stop: proc.StopSpec{
+			Signal:      syscall.SIGTERM,
+			GracePeriod: 30 * time.Second,
+		},
+		// a PD process created together with its peers fails to join them until they're serving,
+		// and is restarted with backoff until it joins
+		Restart: proc.RestartSpec{
+			Policy: proc.RestartOnFailure,
+		},
+		Placement: &PlacementRule{
+			MaxPerHost: 1,
+		},
+		ConfigFlag: "config",
+		Endpoints: map[string]EndpointDefinition{
+			"PD_ADDR": EndpointDefinition{
+				Port:      utils.Port(1234),
+				Flag:      "client-urls",
+				ConfigKey: "client-urls",
+				Format:    AddrURL,
+			},
+			"PD_ADVERTISE_ADDR": EndpointDefinition{
+				Protocol:  utils.Protocol("http"),
+				Port:      utils.Port(1234),
+				Flag:      "advertise-client-urls",
+				ConfigKey: "advertise-client-urls",
+				Format:    AddrURL,
+				WithIP:    true,
+			},
+			"PD_PEER_ADDR": EndpointDefinition{
+				Port:      utils.Port(1235),
+				Flag:      "peer-urls",
+				ConfigKey: "peer-urls",
+				Format:    AddrURL,
+			},
+			"PD_ADVERTISE_PEER_ADDR": EndpointDefinition{
+				Protocol:  utils.Protocol("http"),
+				Port:      utils.Port(1235),
+				Flag:      "advertise-peer-urls",
+				ConfigKey: "advertise-peer-urls",
+				Format:    AddrURL,
+				WithIP:    true,
 			},
 		},
 	}
 }
 
-func (s *PDService) ParseEndpointFromArgs(args []string) map[string]utils.Endpoint {
-	var res = make(map[string]utils.Endpoint)
-	argset := flag.NewFlagSet(PD_SERVICE, flag.ContinueOnError)
-	argset.String("addr", "127.0.0.1:1234", "server listening address")
-	argset.String("advertise-addr", "", "server advertise listening address [127.0.0.1:1234] for client communication")
-	argset.String("etcd", "127.0.0.1:2379", "Etcd endpoints, separated by comma")
-	argset.String("root", "/pd", "pd root path in etcd")
-	argset.Int64("lease", 3, "Leader lease time (second)")
-	argset.String("L", "debug", "log level: info, debug, warn, error, fatal")
-	argset.String("pprof", ":6060", "pprof HTTP listening address")
-	argset.Uint64("cluster-id", 0, "Cluster ID")
-	argset.Uint("max-peer-count", 3, "max peer number for the region")
-	if err := argset.Parse(args); err != nil {
-		// handle error
-		return s.endpoints
+// PDClusterArgs returns the args of new PD process with the flag to join the cluster of existing PD processes,
+// or with the initial cluster of itself if no PD process exists yet, the args are kept if either flag given
+func PDClusterArgs(args []string, endpoints map[string]utils.Endpoint, procs map[string]*proc.ProcessStatus) []string {
+	if _, ok := lookupFlag(args, "join"); ok {
+		return args
+	}
+	if _, ok := lookupFlag(args, "initial-cluster"); ok {
+		return args
+	}
+	// join the alive PD processes, or all of them if none alive, which may be just created
+	alive, all := []string{}, []string{}
+	for _, status := range procs {
+		if status.SvcName != PD_SERVICE {
+			continue
+		}
+		url := pdClientURL(status.RunInfo.Endpoints)
+		if len(url) == 0 {
+			continue
+		}
+		all = append(all, url)
+		if status.IsAlive {
+			alive = append(alive, url)
+		}
 	}
+	urls := alive
+	if len(urls) == 0 {
+		urls = all
+	}
+	res := append([]string{}, args...)
+	if len(urls) > 0 {
+		sort.Strings(urls)
+		return append(res, "--join", strings.Join(urls, ","))
+	}
+	name, ok := lookupFlag(args, "name")
+	peer, found := endpoints["PD_ADVERTISE_PEER_ADDR"]
+	if !ok || !found {
+		return args
+	}
+	return append(res, "--initial-cluster", fmt.Sprintf("%s=%s", name, peer.String()))
+}
 
-	for k, v := range s.endpoints {
-		switch k {
-		case "PD_ADDR":
-			if flag := argset.Lookup("addr"); flag != nil {
-				addrParts := strings.Split(flag.Value.String(), ":")
-				if len(addrParts) > 1 {
-					if p, err := strconv.Atoi(addrParts[1]); err == nil {
-						v.Port = utils.Port(p)
-					}
-				}
-			}
-		case "PD_ADVERTISE_ADDR":
-			if flag := argset.Lookup("advertise-addr"); flag != nil {
-				addrParts := strings.Split(flag.Value.String(), ":")
-				if len(addrParts) > 1 {
-					if ok, _ := regexp.MatchString(`^(\d+)\.(\d+)\.(\d+)\.(\d+)$`, addrParts[0]); ok {
-						v.IPAddr = addrParts[0]
-					}
-					if p, err := strconv.Atoi(addrParts[1]); err == nil {
-						v.Port = utils.Port(p)
-					}
-				}
-			}
-		case "PD_PPROF_ADDR":
-			if flag := argset.Lookup("pprof"); flag != nil {
-				addrParts := strings.Split(flag.Value.String(), ":")
-				if len(addrParts) > 1 {
-					if p, err := strconv.Atoi(addrParts[1]); err == nil {
-						v.Port = utils.Port(p)
-					}
-				}
-			}
+// KeepPDClusterArgs returns the args of PD process with the flags to join or initialize the cluster kept from
+// the previous args, unless either flag given, so that the updated process is still the member of the same cluster
+func KeepPDClusterArgs(args, previous []string) []string {
+	if _, ok := lookupFlag(args, "join"); ok {
+		return args
+	}
+	if _, ok := lookupFlag(args, "initial-cluster"); ok {
+		return args
+	}
+	res := append([]string{}, args...)
+	for _, name := range []string{"join", "initial-cluster"} {
+		if value, ok := lookupFlag(previous, name); ok {
+			res = append(res, "--"+name, value)
 		}
-		res[k] = v
 	}
 	return res
 }
+
+// PDMemberName returns the name of PD process as a member of PD cluster, empty if not named by args
+func PDMemberName(status *proc.ProcessStatus) string {
+	name, ok := lookupFlag(status.RunInfo.Args, "name")
+	if !ok {
+		return ""
+	}
+	return proc.ReplaceVars(name, map[string]string{
+		"PROCID":    status.ProcID,
+		"SERVICE":   status.SvcName,
+		"HOST_NAME": status.RunInfo.HostName,
+		"HOST_IP":   status.RunInfo.HostIP,
+	})
+}
+
+// pdClientURL returns the URL which PD process advertises to clients
+func pdClientURL(endpoints map[string]utils.Endpoint) string {
+	if ep, ok := endpoints["PD_ADVERTISE_ADDR"]; ok {
+		return ep.String()
+	}
+	if ep, ok := endpoints["PD_ADDR"]; ok {
+		return "http://" + probeAddr(ep)
+	}
+	return ""
+}
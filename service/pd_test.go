@@ -0,0 +1,141 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+func newTestPDProcess(svcName, ip string, alive bool) *proc.ProcessStatus {
+	return &proc.ProcessStatus{
+		SvcName: svcName,
+		IsAlive: alive,
+		RunInfo: proc.ProcessRunInfo{
+			Endpoints: map[string]utils.Endpoint{
+				"PD_ADVERTISE_ADDR": utils.Endpoint{
+					Protocol: utils.Protocol("http"),
+					IPAddr:   ip,
+					Port:     utils.Port(1234),
+				},
+			},
+		},
+	}
+}
+
+func TestPDClusterArgs(t *testing.T) {
+	args := []string{"--name", "pd-1"}
+	endpoints := map[string]utils.Endpoint{
+		"PD_ADVERTISE_PEER_ADDR": utils.Endpoint{
+			Protocol: utils.Protocol("http"),
+			IPAddr:   "10.0.0.1",
+			Port:     utils.Port(1235),
+		},
+	}
+	tests := []struct {
+		name      string
+		args      []string
+		endpoints map[string]utils.Endpoint
+		procs     map[string]*proc.ProcessStatus
+		want      []string
+	}{
+		{
+			"the first PD initializes the cluster",
+			args, endpoints, nil,
+			[]string{"--name", "pd-1", "--initial-cluster", "pd-1=http://10.0.0.1:1235"},
+		},
+		{
+			"processes of other services ignored",
+			args, endpoints,
+			map[string]*proc.ProcessStatus{"2": newTestPDProcess(TiKV_SERVICE, "10.0.0.2", true)},
+			[]string{"--name", "pd-1", "--initial-cluster", "pd-1=http://10.0.0.1:1235"},
+		},
+		{
+			"join the alive PD processes",
+			args, endpoints,
+			map[string]*proc.ProcessStatus{
+				"2": newTestPDProcess(PD_SERVICE, "10.0.0.3", true),
+				"3": newTestPDProcess(PD_SERVICE, "10.0.0.2", true),
+				"4": newTestPDProcess(PD_SERVICE, "10.0.0.4", false),
+			},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.2:1234,http://10.0.0.3:1234"},
+		},
+		{
+			"join all PD processes if none alive",
+			args, endpoints,
+			map[string]*proc.ProcessStatus{
+				"2": newTestPDProcess(PD_SERVICE, "10.0.0.2", false),
+				"3": newTestPDProcess(PD_SERVICE, "10.0.0.3", false),
+			},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.2:1234,http://10.0.0.3:1234"},
+		},
+		{
+			"join given",
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.9:1234"}, endpoints,
+			map[string]*proc.ProcessStatus{"2": newTestPDProcess(PD_SERVICE, "10.0.0.2", true)},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.9:1234"},
+		},
+		{
+			"initial cluster given",
+			[]string{"--name", "pd-1", "--initial-cluster=pd-1=http://10.0.0.1:1235"}, endpoints,
+			map[string]*proc.ProcessStatus{"2": newTestPDProcess(PD_SERVICE, "10.0.0.2", true)},
+			[]string{"--name", "pd-1", "--initial-cluster=pd-1=http://10.0.0.1:1235"},
+		},
+		{
+			"no name to initialize the cluster",
+			[]string{"-L", "info"}, endpoints, nil,
+			[]string{"-L", "info"},
+		},
+		{
+			"no peer address to initialize the cluster",
+			args, map[string]utils.Endpoint{}, nil,
+			[]string{"--name", "pd-1"},
+		},
+	}
+	for _, tt := range tests {
+		given := append([]string{}, tt.args...)
+		got := PDClusterArgs(tt.args, tt.endpoints, tt.procs)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: PDClusterArgs = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(tt.args, given) {
+			t.Errorf("%s: PDClusterArgs modifies the given args to %q", tt.name, tt.args)
+		}
+	}
+}
+
+func TestKeepPDClusterArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		previous []string
+		want     []string
+	}{
+		{
+			[]string{"--name", "pd-1"},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.2:1234"},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.2:1234"},
+		},
+		{
+			[]string{"--name", "pd-1"},
+			[]string{"--name", "pd-1", "--initial-cluster=pd-1=http://10.0.0.1:1235"},
+			[]string{"--name", "pd-1", "--initial-cluster", "pd-1=http://10.0.0.1:1235"},
+		},
+		{
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.3:1234"},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.2:1234"},
+			[]string{"--name", "pd-1", "--join", "http://10.0.0.3:1234"},
+		},
+		{
+			[]string{"--name", "pd-1"},
+			[]string{"--name", "pd-1"},
+			[]string{"--name", "pd-1"},
+		},
+	}
+	for _, tt := range tests {
+		got := KeepPDClusterArgs(tt.args, tt.previous)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("KeepPDClusterArgs(%q, %q) = %q, want %q", tt.args, tt.previous, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ngaut/log"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+)
+
+const (
+	pdAPIPrefix  = "/pd/api/v1"
+	pdAPITimeout = 5 * time.Second
+)
+
+// the states of TiKV store known by PD
+const (
+	StoreUp        = "Up"
+	StoreOffline   = "Offline"
+	StoreTombstone = "Tombstone"
+)
+
+// PDStore is a TiKV store registered in PD
+type PDStore struct {
+	ID      uint64
+	Address string
+	State   string
+	// the number of regions still on the store, which drops to 0 before the offline store becomes tombstone
+	RegionCount int
+}
+
+// PDClient calls the HTTP API of PD, by the endpoint PD_ADDR of PD processes,
+// which are tried one by one until one of them responds
+type PDClient struct {
+	addrs  []string
+	client *http.Client
+}
+
+// NewPDClient creates the client by the alive PD processes among procs
+func NewPDClient(procs map[string]*proc.ProcessStatus) (*PDClient, error) {
+	addrs := []string{}
+	for _, status := range procs {
+		if status.SvcName != PD_SERVICE || !status.IsAlive {
+			continue
+		}
+		if ep, ok := status.RunInfo.Endpoints["PD_ADDR"]; ok {
+			addrs = append(addrs, probeAddr(ep))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("No alive PD process to call")
+	}
+	return &PDClient{
+		addrs: addrs,
+		client: &http.Client{
+			Timeout: pdAPITimeout,
+		},
+	}, nil
+}
+
+type pdStoreInfo struct {
+	Store struct {
+		ID      uint64 `json:"id"`
+		Address string `json:"address"`
+		// the state in number is given by the old versions of PD, 0: Up, 1: Offline, 2: Tombstone
+		State     int    `json:"state"`
+		StateName string `json:"state_name"`
+	} `json:"store"`
+	Status struct {
+		RegionCount int `json:"region_count"`
+	} `json:"status"`
+}
+
+func (info *pdStoreInfo) toStore() *PDStore {
+	state := info.Store.StateName
+	if len(state) == 0 {
+		switch info.Store.State {
+		case 0:
+			state = StoreUp
+		case 1:
+			state = StoreOffline
+		case 2:
+			state = StoreTombstone
+		}
+	}
+	return &PDStore{
+		ID:          info.Store.ID,
+		Address:     info.Store.Address,
+		State:       state,
+		RegionCount: info.Status.RegionCount,
+	}
+}
+
+// Stores lists the stores in PD, the tombstone ones may be left out
+func (c *PDClient) Stores() ([]*PDStore, error) {
+	var resp struct {
+		Stores []*pdStoreInfo `json:"stores"`
+	}
+	if err := c.do("GET", "/stores", &resp); err != nil {
+		return nil, err
+	}
+	stores := make([]*PDStore, 0, len(resp.Stores))
+	for _, info := range resp.Stores {
+		stores = append(stores, info.toStore())
+	}
+	return stores, nil
+}
+
+// Store returns the store by ID, including the tombstone one
+func (c *PDClient) Store(storeID uint64) (*PDStore, error) {
+	info := &pdStoreInfo{}
+	if err := c.do("GET", fmt.Sprintf("/store/%d", storeID), info); err != nil {
+		return nil, err
+	}
+	return info.toStore(), nil
+}
+
+// DeleteStore asks PD to take the store offline, PD migrates the regions on it to other stores,
+// and marks it tombstone after all regions migrated
+func (c *PDClient) DeleteStore(storeID uint64) error {
+	return c.do("DELETE", fmt.Sprintf("/store/%d", storeID), nil)
+}
+
+// PDMember is a member of the etcd cluster embedded in PD
+type PDMember struct {
+	Name       string   `json:"name"`
+	MemberID   uint64   `json:"member_id"`
+	PeerURLs   []string `json:"peer_urls"`
+	ClientURLs []string `json:"client_urls"`
+}
+
+// Members lists the members of PD cluster
+func (c *PDClient) Members() ([]*PDMember, error) {
+	var resp struct {
+		Members []*PDMember `json:"members"`
+	}
+	if err := c.do("GET", "/members", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Members, nil
+}
+
+// DeleteMember removes the member from PD cluster by name, the PD process of the member should be stopped afterwards
+func (c *PDClient) DeleteMember(name string) error {
+	return c.do("DELETE", "/members/name/"+url.PathEscape(name), nil)
+}
+
+// MaxReplicas returns the number of replicas of each region, which needs as many stores up
+func (c *PDClient) MaxReplicas() (int, error) {
+	var resp struct {
+		MaxReplicas int `json:"max-replicas"`
+	}
+	if err := c.do("GET", "/config/replicate", &resp); err != nil {
+		return 0, err
+	}
+	return resp.MaxReplicas, nil
+}
+
+// do sends the request to PD processes until one of them responds, and decodes the response into out unless nil,
+// a response of error status from PD is returned as error without trying others
+func (c *PDClient) do(method, path string, out interface{}) error {
+	var lastErr error
+	for _, addr := range c.addrs {
+		target := fmt.Sprintf("http://%s%s%s", addr, pdAPIPrefix, path)
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return err
+		}
+		res, err := c.client.Do(req)
+		if err != nil {
+			log.Warnf("Failed to call PD API, %s %s, %v", method, target, err)
+			lastErr = err
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			log.Warnf("Failed to read response of PD API, %s %s, %v", method, target, err)
+			lastErr = err
+			continue
+		}
+		if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+			return errors.New(fmt.Sprintf("PD API %s %s responded %s, %s", method, path, res.Status, strings.TrimSpace(string(body))))
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.Unmarshal(body, out); err != nil {
+			return errors.New(fmt.Sprintf("Illegal response of PD API %s %s, %v", method, path, err))
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("No PD process responded, %v", lastErr))
+}
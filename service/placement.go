@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/qiuyesuifeng/tidb-demo/machine"
+)
+
+// the labels every machine has, derived from its infomation, which placement rules can require
+const (
+	LabelRegion   = "region"
+	LabelIDC      = "idc"
+	LabelHostName = "hostname"
+	LabelIP       = "ip"
+)
+
+// PlacementRule restricts where the processes of service can be placed, which is enforced by master
+// on every creation of process, the zero value places no restriction
+type PlacementRule struct {
+	// the max number of processes of service on one host, in one IDC and in one region, 0 means no limit
+	MaxPerHost   int
+	MaxPerIDC    int
+	MaxPerRegion int
+	// the labels the machine must have, e.g. region=bj
+	RequiredLabels map[string]string
+}
+
+// MachineLabels returns the labels of machine, by which the placement rules select machines
+func MachineLabels(mach *machine.MachineStatus) map[string]string {
+	return map[string]string{
+		LabelRegion:   mach.MachInfo.HostRegion,
+		LabelIDC:      mach.MachInfo.HostIDC,
+		LabelHostName: mach.MachInfo.HostName,
+		LabelIP:       mach.MachInfo.PublicIP,
+	}
+}
+
+// Check returns the violations of rule if a process of service is placed on the machine,
+// along with the other processes of service placed on the peers, nil if no violation
+func (pr *PlacementRule) Check(mach *machine.MachineStatus, peers []*machine.MachineStatus) []string {
+	var violations []string
+	labels := MachineLabels(mach)
+	keys := make([]string, 0, len(pr.RequiredLabels))
+	for k := range pr.RequiredLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := pr.RequiredLabels[k]; labels[k] != v {
+			violations = append(violations, fmt.Sprintf("label %s=%s required, got %q", k, v, labels[k]))
+		}
+	}
+
+	// count the process itself in
+	onHost, inIDC, inRegion := 1, 1, 1
+	for _, peer := range peers {
+		if peer.MachInfo.HostRegion != mach.MachInfo.HostRegion {
+			continue
+		}
+		inRegion++
+		if peer.MachInfo.HostIDC != mach.MachInfo.HostIDC {
+			continue
+		}
+		inIDC++
+		if peer.MachID == mach.MachID {
+			onHost++
+		}
+	}
+	if pr.MaxPerHost > 0 && onHost > pr.MaxPerHost {
+		violations = append(violations, fmt.Sprintf("%d processes on host %s, at most %d allowed",
+			onHost, mach.MachID, pr.MaxPerHost))
+	}
+	if pr.MaxPerIDC > 0 && inIDC > pr.MaxPerIDC {
+		violations = append(violations, fmt.Sprintf("%d processes in IDC %s, at most %d allowed",
+			inIDC, mach.MachInfo.HostIDC, pr.MaxPerIDC))
+	}
+	if pr.MaxPerRegion > 0 && inRegion > pr.MaxPerRegion {
+		violations = append(violations, fmt.Sprintf("%d processes in region %s, at most %d allowed",
+			inRegion, mach.MachInfo.HostRegion, pr.MaxPerRegion))
+	}
+	return violations
+}
@@ -1,46 +1,110 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
-	"github.com/qiuyesuifeng/tidb-demo/registry"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
 )
 
-var Registered map[string]Service
+// the default rotation of logs of all services, unless specified when starting process
+var defaultLogRotation = proc.LogRotation{
+	MaxSize:  100 * 1024 * 1024,
+	MaxAge:   24 * time.Hour,
+	MaxFiles: 10,
+	Compress: true,
+	MaxRuns:  5,
+}
+
+var (
+	registered      map[string]Service
+	registeredMutex sync.RWMutex
+)
 
-func RegisterServices() {
-	Registered = make(map[string]Service)
-	Registered[TiDB_SERVICE] = NewTiDBService()
-	Registered[PD_SERVICE] = NewPDService()
-	Registered[TiKV_SERVICE] = NewTiKVService()
+// Registered returns the services loaded from registry, a map of svcName to service,
+// the map is replaced rather than modified on reloading, so it's safe to iterate
+func Registered() map[string]Service {
+	registeredMutex.RLock()
+	defer registeredMutex.RUnlock()
+	return registered
 }
 
-func RegisterServciesFromEtcd(reg registry.Registry) {
-	// TODO: implement it
+// RegisterDefinitions replaces the registered services with the ones defined,
+// nothing changed if any definition is invalid or the dependencies can't be satisfied
+func RegisterDefinitions(defs map[string]*Definition) error {
+	svcs, err := NewServices(defs)
+	if err != nil {
+		return err
+	}
+	registeredMutex.Lock()
+	registered = svcs
+	registeredMutex.Unlock()
+	return nil
+}
+
+// NewServices creates the services defined, and checks the dependencies between them
+func NewServices(defs map[string]*Definition) (map[string]Service, error) {
+	svcs := make(map[string]Service)
+	for name, def := range defs {
+		if err := def.Validate(); err != nil {
+			return nil, err
+		}
+		if name != def.SvcName {
+			return nil, errors.New(fmt.Sprintf("Service %s defined under another name %s", def.SvcName, name))
+		}
+		svcs[name] = NewService(def)
+	}
+	if _, err := sortByDependencies(svcs); err != nil {
+		return nil, err
+	}
+	return svcs, nil
 }
 
 type Service interface {
 	Status() *ServiceStatus
+	Definition() *Definition
 	ParseEndpointFromArgs([]string) map[string]utils.Endpoint
 }
 
 type service struct {
-	svcName      string
-	version      string
-	executor     []string
-	command      string
-	args         []string
-	environments map[string]string
-	endpoints    map[string]utils.Endpoint
+	def *Definition
+}
+
+// NewService creates the service by its definition, which should have been validated
+func NewService(def *Definition) Service {
+	return &service{
+		def: def,
+	}
 }
 
 func (s *service) Status() *ServiceStatus {
+	endpoints := make(map[string]utils.Endpoint)
+	for name, ed := range s.def.Endpoints {
+		endpoints[name] = utils.Endpoint{
+			Protocol: ed.Protocol,
+			Port:     ed.Port,
+		}
+	}
 	return &ServiceStatus{
-		SvcName:      s.svcName,
-		Version:      s.version,
-		Executor:     s.executor,
-		Command:      s.command,
-		Args:         s.args,
-		Environments: s.environments,
-		Endpoints:    s.endpoints,
+		SvcName:      s.def.SvcName,
+		Version:      s.def.Version,
+		Executor:     s.def.Executor,
+		Command:      s.def.Command,
+		Args:         s.def.Args,
+		Environments: s.def.Environments,
+		Endpoints:    endpoints,
+		LogRotation:  s.def.LogRotation,
+		HealthProbe:  s.def.HealthProbe,
+		Stop:         s.def.Stop,
+		Restart:      s.def.Restart,
+		Placement:    s.def.Placement,
+		Dependencies: s.def.Dependencies,
 	}
 }
+
+func (s *service) Definition() *Definition {
+	return s.def
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"github.com/qiuyesuifeng/tidb-demo/pkg/utils"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
 )
 
 type ServiceStatus struct {
@@ -12,6 +13,12 @@ type ServiceStatus struct {
 	Args         []string
 	Environments map[string]string
 	Endpoints    map[string]utils.Endpoint
+	LogRotation  proc.LogRotation
+	HealthProbe  *HealthProbe // nil if the service can't be probed
+	Stop         proc.StopSpec
+	Restart      proc.RestartSpec
+	Placement    *PlacementRule // nil if the processes of service can be placed anywhere
+	Dependencies []string       // the services must be serving before the processes of service started
 }
 
 type TiDBPerfMetrics struct {
@@ -2,10 +2,9 @@ package service
 
 import (
 	"encoding/json"
-	"flag"
 	"io/ioutil"
 	"net/http"
-	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/ngaut/log"
@@ -15,75 +14,47 @@ import (
 
 const TiDB_SERVICE = "TiDB"
 
-type TiDBService struct {
-	service
-}
-
-func NewTiDBService() Service {
-	return &TiDBService{
-		service{
-			svcName:      TiDB_SERVICE,
-			version:      "1.0.0",
-			executor:     []string{},
-			command:      "bin/tidb-server",
-			args:         []string{"-L", "info", "--store", "tikv", "--path", "$ETCD_ADDR/pd?cluster=1", "-P", "4000", "--lease", "1"},
-			environments: map[string]string{},
-			endpoints: map[string]utils.Endpoint{
-				"TIDB_ADDR": utils.Endpoint{
-					Protocol: utils.Protocol("mysql"),
-					Port:     utils.Port(4000),
-				},
-				"TIDB_STATUS_ADDR": utils.Endpoint{
-					Protocol: utils.Protocol("http"),
-					Port:     utils.Port(10080),
-				},
+func tidbDefinition() *Definition {
+	return &Definition{
+		SvcName:      TiDB_SERVICE,
+		Version:      "1.0.0",
+		Executor:     []string{},
+		Command:      "bin/tidb-server",
+		Args:         []string{"-L", "info", "--store", "tikv", "--path", "$PD_ADDR", "-P", "4000", "--lease", "1"},
+		Environments: map[string]string{},
+		LogRotation:  defaultLogRotation,
+		HealthProbe:  newHTTPProbe("TIDB_STATUS_ADDR", "/status"),
+		Stop: proc.StopSpec{
+			Signal:      syscall.SIGTERM,
+			GracePeriod: 15 * time.Second,
+		},
+		Dependencies: []string{TiKV_SERVICE, PD_SERVICE},
+		Endpoints: map[string]EndpointDefinition{
+			"TIDB_ADDR": EndpointDefinition{
+				Protocol: utils.Protocol("mysql"),
+				Port:     utils.Port(4000),
+				Flag:     "P",
+				Format:   AddrPort,
+			},
+			"TIDB_STATUS_ADDR": EndpointDefinition{
+				Protocol: utils.Protocol("http"),
+				Port:     utils.Port(10080),
+				Flag:     "status",
+				Format:   AddrPort,
 			},
 		},
 	}
 }
 
-func (s *TiDBService) ParseEndpointFromArgs(args []string) map[string]utils.Endpoint {
-	var res = make(map[string]utils.Endpoint)
-	argset := flag.NewFlagSet(TiDB_SERVICE, flag.ContinueOnError)
-	argset.String("store", "goleveldb", "registered store name, [memory, goleveldb, hbase, boltdb, tikv]")
-	argset.String("path", "/tmp/tidb", "tidb storage path")
-	argset.String("L", "debug", "log level: info, debug, warn, error, fatal")
-	argset.String("P", "4000", "mp server port")
-	argset.String("status", "10080", "tidb server status port")
-	argset.Int("lease", 1, "schema lease seconds, very dangerous to change only if you know what you do")
-	if err := argset.Parse(args); err != nil {
-		// handle error
-		return s.endpoints
-	}
-
-	for k, v := range s.endpoints {
-		switch k {
-		case "TIDB_ADDR":
-			if flag := argset.Lookup("P"); flag != nil {
-				if p, err := strconv.Atoi(flag.Value.String()); err == nil {
-					v.Port = utils.Port(p)
-				}
-			}
-		case "TIDB_STATUS_ADDR":
-			if flag := argset.Lookup("status"); flag != nil {
-				if p, err := strconv.Atoi(flag.Value.String()); err == nil {
-					v.Port = utils.Port(p)
-				}
-			}
-		}
-		res[k] = v
-	}
-	return res
-}
-
-func (s *TiDBService) RetrieveRealPerformance(allProcesses map[string]*proc.ProcessStatus) *TiDBPerfMetrics {
+// RetrieveTiDBPerformance accumulates the performance of all alive TiDB processes
+func RetrieveTiDBPerformance(allProcesses map[string]*proc.ProcessStatus) *TiDBPerfMetrics {
 	var res = &TiDBPerfMetrics{}
 	for _, proc := range allProcesses {
 		if proc.SvcName != TiDB_SERVICE || !proc.IsAlive {
 			continue
 		}
 		if endpoint, ok := proc.RunInfo.Endpoints["TIDB_STATUS_ADDR"]; ok {
-			if status, err := s.fetchTiDBStatusFromHttp(endpoint); err == nil {
+			if status, err := fetchTiDBStatusFromHttp(endpoint); err == nil {
 				// accumulating
 				res.Connections += status.Connections
 				res.TPS += status.TPS
@@ -93,10 +64,11 @@ func (s *TiDBService) RetrieveRealPerformance(allProcesses map[string]*proc.Proc
 	return res
 }
 
-func (s *TiDBService) RetrieveLocalRealPerformance() *TiDBPerfMetrics {
+// RetrieveLocalTiDBPerformance returns the performance of TiDB process on this machine
+func RetrieveLocalTiDBPerformance() *TiDBPerfMetrics {
 	var res = &TiDBPerfMetrics{}
 	ep, _ := utils.ParseEndpoint("http://127.0.0.1:10080")
-	if status, err := s.fetchTiDBStatusFromHttp(ep); err == nil {
+	if status, err := fetchTiDBStatusFromHttp(ep); err == nil {
 		// accumulating
 		res.Connections += status.Connections
 		res.TPS += status.TPS
@@ -104,7 +76,7 @@ func (s *TiDBService) RetrieveLocalRealPerformance() *TiDBPerfMetrics {
 	return res
 }
 
-func (s *TiDBService) fetchTiDBStatusFromHttp(addr utils.Endpoint) (*TiDBPerfMetrics, error) {
+func fetchTiDBStatusFromHttp(addr utils.Endpoint) (*TiDBPerfMetrics, error) {
 	url := addr.String() + "/status"
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -0,0 +1,218 @@
+package topology
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+type ActionType string
+
+const (
+	// create a new process, on the machine if given, otherwise placed by scheduler
+	ActionCreate = ActionType("create")
+	// destroy a process not desired any more
+	ActionDestroy = ActionType("destroy")
+	// update the run spec of a process in place, whose spec differs from the topology
+	ActionUpdate = ActionType("update")
+	// start a process which is stopped
+	ActionStart = ActionType("start")
+)
+
+// Action is a step to converge the processes in registry towards the topology
+type Action struct {
+	Type    ActionType
+	SvcName string
+	ProcID  string // empty if the process is to be created
+	MachID  string // empty if the machine is to be chosen by scheduler
+	Reason  string
+	Error   string // why the action failed, if applied
+	Spec    *ServiceSpec
+	// the status of process to destroy, update or start
+	Current *proc.ProcessStatus
+}
+
+// Diff compares the topology with the processes in registry, and returns the actions to converge,
+// all destroys come first in the reverse order of service dependencies, to free the machines before placing
+// new processes, then the others in the order of service dependencies
+func Diff(topo *Topology, procs map[string]*proc.ProcessStatus) ([]*Action, error) {
+	order, err := service.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+	rank := make(map[string]int)
+	for i, name := range order {
+		rank[name] = i
+	}
+	specs := make([]*ServiceSpec, len(topo.Services))
+	copy(specs, topo.Services)
+	sort.SliceStable(specs, func(i, j int) bool {
+		return rank[specs[i].Name] < rank[specs[j].Name]
+	})
+
+	destroys := []*Action{}
+	others := []*Action{}
+	for _, spec := range specs {
+		var serviceDestroys []*Action
+		for _, act := range diffService(spec, procs) {
+			if act.Type == ActionDestroy {
+				serviceDestroys = append(serviceDestroys, act)
+			} else {
+				others = append(others, act)
+			}
+		}
+		// the services depending on others are destroyed first
+		destroys = append(serviceDestroys, destroys...)
+	}
+	return append(destroys, others...), nil
+}
+
+func diffService(spec *ServiceSpec, procs map[string]*proc.ProcessStatus) []*Action {
+	existing := []*proc.ProcessStatus{}
+	for _, p := range procs {
+		if p.SvcName == spec.Name {
+			existing = append(existing, p)
+		}
+	}
+	// the up-to-date processes are kept first, then the older procIDs
+	sort.Slice(existing, func(i, j int) bool {
+		ui, uj := upToDate(spec, existing[i]), upToDate(spec, existing[j])
+		if ui != uj {
+			return ui
+		}
+		return existing[i].ProcID < existing[j].ProcID
+	})
+
+	actions := []*Action{}
+	used := make(map[string]bool)
+	keep := func(p *proc.ProcessStatus) {
+		used[p.ProcID] = true
+		if !upToDate(spec, p) {
+			actions = append(actions, &Action{
+				Type:    ActionUpdate,
+				SvcName: spec.Name,
+				ProcID:  p.ProcID,
+				MachID:  p.MachID,
+				Reason:  "the args, environments or restart policy differ from topology",
+				Spec:    spec,
+				Current: p,
+			})
+		} else if p.DesiredState != proc.StateStarted {
+			actions = append(actions, &Action{
+				Type:    ActionStart,
+				SvcName: spec.Name,
+				ProcID:  p.ProcID,
+				MachID:  p.MachID,
+				Reason:  "the process is stopped",
+				Spec:    spec,
+				Current: p,
+			})
+		}
+	}
+
+	for _, host := range spec.Hosts {
+		found := false
+		for _, p := range existing {
+			if !used[p.ProcID] && p.MachID == host {
+				keep(p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			actions = append(actions, &Action{
+				Type:    ActionCreate,
+				SvcName: spec.Name,
+				MachID:  host,
+				Reason:  fmt.Sprintf("no process on host %s", host),
+				Spec:    spec,
+			})
+		}
+	}
+
+	placed := spec.Replicas() - len(spec.Hosts)
+	for _, p := range existing {
+		if placed == 0 {
+			break
+		}
+		if used[p.ProcID] || !inPlace(spec, p) {
+			continue
+		}
+		keep(p)
+		placed--
+	}
+	for i := 0; i < placed; i++ {
+		actions = append(actions, &Action{
+			Type:    ActionCreate,
+			SvcName: spec.Name,
+			Reason:  fmt.Sprintf("%d processes desired", spec.Replicas()),
+			Spec:    spec,
+		})
+	}
+
+	for _, p := range existing {
+		if used[p.ProcID] {
+			continue
+		}
+		reason := fmt.Sprintf("more than %d processes", spec.Replicas())
+		if !inPlace(spec, p) {
+			reason = fmt.Sprintf("the host is out of region %q and IDC %q", spec.Region, spec.IDC)
+		}
+		actions = append(actions, &Action{
+			Type:    ActionDestroy,
+			SvcName: spec.Name,
+			ProcID:  p.ProcID,
+			MachID:  p.MachID,
+			Reason:  reason,
+			Spec:    spec,
+			Current: p,
+		})
+	}
+	return actions
+}
+
+// inPlace tells whether the process is placed in the region and IDC of spec
+func inPlace(spec *ServiceSpec, p *proc.ProcessStatus) bool {
+	return (len(spec.Region) == 0 || p.RunInfo.HostRegion == spec.Region) &&
+		(len(spec.IDC) == 0 || p.RunInfo.HostIDC == spec.IDC)
+}
+
+// upToDate tells whether the process runs with the args, environments and restart policy of spec,
+// the ones not given by spec are compared with the defaults of service
+func upToDate(spec *ServiceSpec, p *proc.ProcessStatus) bool {
+	status := service.Registered()[spec.Name].Status()
+	args := spec.Args
+	if len(args) == 0 {
+		args = status.Args
+	}
+	envs := spec.Environments
+	if len(envs) == 0 {
+		envs = status.Environments
+	}
+	restart := spec.RunInfo().Restart
+	if len(restart.Policy) == 0 {
+		restart.Policy = status.Restart.Policy
+	}
+	if restart.MaxRetries == 0 {
+		restart.MaxRetries = status.Restart.MaxRetries
+	}
+	return equalStrings(p.RunInfo.Args, args) && equalEnvironments(p.RunInfo.Environment, envs) &&
+		p.RunInfo.Restart.Policy.String() == restart.Policy.String() && p.RunInfo.Restart.MaxRetries == restart.MaxRetries
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func equalEnvironments(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,131 @@
+package topology
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+func newTestProcess(procID, svcName, machID, region string, desired proc.ProcessState) *proc.ProcessStatus {
+	ss := service.Registered()[svcName].Status()
+	return &proc.ProcessStatus{
+		ProcID:       procID,
+		SvcName:      svcName,
+		MachID:       machID,
+		DesiredState: desired,
+		RunInfo: proc.ProcessRunInfo{
+			HostRegion: region,
+			Args:       ss.Args,
+			Restart:    ss.Restart,
+		},
+	}
+}
+
+// describe returns the actions in short, e.g. "destroy PD 10001 on m1"
+func describe(actions []*Action) []string {
+	res := []string{}
+	for _, act := range actions {
+		res = append(res, fmt.Sprintf("%s %s %s on %s", act.Type, act.SvcName, act.ProcID, act.MachID))
+	}
+	return res
+}
+
+func TestDiff(t *testing.T) {
+	if err := service.RegisterDefinitions(service.DefaultDefinitions()); err != nil {
+		t.Fatalf("Failed to register services, %v", err)
+	}
+	outdated := newTestProcess("10003", service.PD_SERVICE, "m3", "bj", proc.StateStarted)
+	outdated.RunInfo.Args = []string{"--name", "pd-old"}
+
+	tests := []struct {
+		name     string
+		services []*ServiceSpec
+		procs    []*proc.ProcessStatus
+		want     []string
+	}{
+		{
+			"create in the order of dependencies",
+			[]*ServiceSpec{
+				{Name: service.TiKV_SERVICE, Hosts: []string{"m1", "m2"}},
+				{Name: service.PD_SERVICE, Count: 2},
+			},
+			nil,
+			[]string{
+				"create PD  on ", "create PD  on ",
+				"create TiKV  on m1", "create TiKV  on m2",
+			},
+		},
+		{
+			"nothing to do",
+			[]*ServiceSpec{{Name: service.PD_SERVICE, Count: 1}},
+			[]*proc.ProcessStatus{newTestProcess("10001", service.PD_SERVICE, "m1", "bj", proc.StateStarted)},
+			[]string{},
+		},
+		{
+			"start and destroy",
+			[]*ServiceSpec{{Name: service.PD_SERVICE, Count: 2}},
+			[]*proc.ProcessStatus{
+				newTestProcess("10001", service.PD_SERVICE, "m1", "bj", proc.StateStopped),
+				newTestProcess("10002", service.PD_SERVICE, "m2", "bj", proc.StateStarted),
+				outdated,
+			},
+			// the up-to-date processes are kept first
+			[]string{"destroy PD 10003 on m3", "start PD 10001 on m1"},
+		},
+		{
+			"update the outdated process on host",
+			[]*ServiceSpec{{Name: service.PD_SERVICE, Hosts: []string{"m3"}}},
+			[]*proc.ProcessStatus{outdated},
+			[]string{"update PD 10003 on m3"},
+		},
+		{
+			"replace the process out of region",
+			[]*ServiceSpec{{Name: service.PD_SERVICE, Count: 1, Region: "sh"}},
+			[]*proc.ProcessStatus{newTestProcess("10001", service.PD_SERVICE, "m1", "bj", proc.StateStarted)},
+			[]string{"destroy PD 10001 on m1", "create PD  on "},
+		},
+		{
+			"destroy in the reverse order of dependencies",
+			[]*ServiceSpec{
+				{Name: service.PD_SERVICE, Count: 0},
+				{Name: service.TiDB_SERVICE, Count: 0},
+			},
+			[]*proc.ProcessStatus{
+				newTestProcess("10001", service.PD_SERVICE, "m1", "bj", proc.StateStarted),
+				newTestProcess("10002", service.TiDB_SERVICE, "m1", "bj", proc.StateStarted),
+			},
+			[]string{"destroy TiDB 10002 on m1", "destroy PD 10001 on m1"},
+		},
+		{
+			"services not described are left",
+			[]*ServiceSpec{{Name: service.TiDB_SERVICE, Count: 1}},
+			[]*proc.ProcessStatus{
+				newTestProcess("10001", service.PD_SERVICE, "m1", "bj", proc.StateStopped),
+				newTestProcess("10002", service.TiDB_SERVICE, "m1", "bj", proc.StateStarted),
+			},
+			[]string{},
+		},
+		{
+			"restart policy of topology",
+			[]*ServiceSpec{{Name: service.PD_SERVICE, Count: 1, RestartPolicy: "always"}},
+			[]*proc.ProcessStatus{newTestProcess("10001", service.PD_SERVICE, "m1", "bj", proc.StateStarted)},
+			[]string{"update PD 10001 on m1"},
+		},
+	}
+	for _, tt := range tests {
+		procs := make(map[string]*proc.ProcessStatus)
+		for _, p := range tt.procs {
+			procs[p.ProcID] = p
+		}
+		actions, err := Diff(&Topology{Services: tt.services}, procs)
+		if err != nil {
+			t.Fatalf("%s: Diff failed, %v", tt.name, err)
+		}
+		if got := describe(actions); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Diff = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
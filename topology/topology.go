@@ -0,0 +1,144 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/qiuyesuifeng/tidb-demo/proc"
+	"github.com/qiuyesuifeng/tidb-demo/service"
+)
+
+// Topology describes the processes of services the cluster should run, see conf/topology.toml for example,
+// the services not described are left as they are
+type Topology struct {
+	Services []*ServiceSpec `toml:"service"`
+}
+
+// ServiceSpec describes how many processes of the service to run, and where
+type ServiceSpec struct {
+	Name string `toml:"name"`
+	// the number of processes, at least one on each of hosts, 0 means the number of hosts
+	Count int `toml:"count"`
+	// the machIDs of machines each of which runs one process
+	Hosts []string `toml:"hosts"`
+	// the region and IDC in which the processes not on hosts are placed by scheduler, empty means any
+	Region string `toml:"region"`
+	IDC    string `toml:"idc"`
+	// override the args and environments of service if given
+	Args          []string          `toml:"args"`
+	Environments  map[string]string `toml:"environments"`
+	RestartPolicy string            `toml:"restart-policy"`
+	MaxRetries    int               `toml:"max-retries"`
+	// override the placement rule of service if given, which is kept for the processes created afterwards
+	Placement *PlacementSpec `toml:"placement"`
+}
+
+// PlacementSpec is the placement rule of service, 0 means no limit, an empty one removes the rule,
+// e.g. to run more than one PD or TiKV process on a single host with distinct ports and data dirs given by args
+type PlacementSpec struct {
+	MaxPerHost     int               `toml:"max-per-host"`
+	MaxPerIDC      int               `toml:"max-per-idc"`
+	MaxPerRegion   int               `toml:"max-per-region"`
+	RequiredLabels map[string]string `toml:"required-labels"`
+}
+
+// PlacementRule returns the placement rule of service described, nil if no restriction
+func (s *PlacementSpec) PlacementRule() *service.PlacementRule {
+	if s.MaxPerHost == 0 && s.MaxPerIDC == 0 && s.MaxPerRegion == 0 && len(s.RequiredLabels) == 0 {
+		return nil
+	}
+	return &service.PlacementRule{
+		MaxPerHost:     s.MaxPerHost,
+		MaxPerIDC:      s.MaxPerIDC,
+		MaxPerRegion:   s.MaxPerRegion,
+		RequiredLabels: s.RequiredLabels,
+	}
+}
+
+// Replicas returns the number of processes of service desired
+func (s *ServiceSpec) Replicas() int {
+	if s.Count < len(s.Hosts) {
+		return len(s.Hosts)
+	}
+	return s.Count
+}
+
+// RunInfo returns the run info to create a process of service, the fields not overridden are left
+// empty to take the default of service
+func (s *ServiceSpec) RunInfo() *proc.ProcessRunInfo {
+	var policy proc.RestartPolicy
+	if len(s.RestartPolicy) > 0 {
+		// the policy has been validated on parsing
+		policy, _ = proc.ParseRestartPolicy(s.RestartPolicy)
+	}
+	return &proc.ProcessRunInfo{
+		Args:        s.Args,
+		Environment: s.Environments,
+		Restart: proc.RestartSpec{
+			Policy:     policy,
+			MaxRetries: s.MaxRetries,
+		},
+	}
+}
+
+// Parse parses the topology document in TOML, and validates it against the registered services
+func Parse(data []byte) (*Topology, error) {
+	topo := &Topology{}
+	md, err := toml.Decode(string(data), topo)
+	if err != nil {
+		return nil, err
+	}
+	// reject the misspelt keys, which would be ignored silently
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		return nil, errors.New(fmt.Sprintf("Unknown keys in topology: %s", strings.Join(keys, ", ")))
+	}
+	if err := topo.Validate(); err != nil {
+		return nil, err
+	}
+	return topo, nil
+}
+
+func (t *Topology) Validate() error {
+	names := make(map[string]bool)
+	for _, s := range t.Services {
+		if len(s.Name) == 0 {
+			return errors.New("Service name of topology is necessary")
+		}
+		if _, ok := service.Registered()[s.Name]; !ok {
+			return errors.New(fmt.Sprintf("Unregistered service in topology: %s", s.Name))
+		}
+		if names[s.Name] {
+			return errors.New(fmt.Sprintf("Service described more than once in topology: %s", s.Name))
+		}
+		names[s.Name] = true
+		if s.Count < 0 || s.MaxRetries < 0 {
+			return errors.New(fmt.Sprintf("The count and max-retries of service should not be negative: %s", s.Name))
+		}
+		if s.Count > 0 && s.Count < len(s.Hosts) {
+			return errors.New(fmt.Sprintf("The count of service %s is less than the number of hosts: %d < %d",
+				s.Name, s.Count, len(s.Hosts)))
+		}
+		hosts := make(map[string]bool)
+		for _, h := range s.Hosts {
+			if hosts[h] {
+				return errors.New(fmt.Sprintf("Host listed more than once for service %s: %s", s.Name, h))
+			}
+			hosts[h] = true
+		}
+		if _, err := proc.ParseRestartPolicy(s.RestartPolicy); err != nil {
+			return err
+		}
+		if p := s.Placement; p != nil && (p.MaxPerHost < 0 || p.MaxPerIDC < 0 || p.MaxPerRegion < 0) {
+			return errors.New(fmt.Sprintf("The placement limits of service should not be negative: %s", s.Name))
+		}
+	}
+	return nil
+}